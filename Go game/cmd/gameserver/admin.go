@@ -0,0 +1,531 @@
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/server"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// what operators see about a player
+type adminPlayer struct {
+	ID        string        `json:"id"`
+	Username  string        `json:"username"`
+	SessionID string        `json:"session_id"`
+	IP        string        `json:"ip"`
+	Connected bool          `json:"connected"`
+	Bot       bool          `json:"bot"`
+	Position  game.Position `json:"position"`
+	Health    int           `json:"health"`
+	Weapon    string        `json:"weapon"`
+	IsDead    bool          `json:"is_dead"`
+	Kills     int           `json:"kills"`
+	Deaths    int           `json:"deaths"`
+	Ping      int64         `json:"ping_ms"`
+}
+
+type adminRoom struct {
+	Name        string        `json:"name"`
+	MatchActive bool          `json:"match_active"`
+	Seq         uint64        `json:"seq"`
+	Players     []adminPlayer `json:"players"`
+	Spectators  int           `json:"spectators"`
+	Bullets     int           `json:"bullets"`
+	Weapons     int           `json:"weapons"`
+	PowerUps    int           `json:"powerups"`
+	Settings    config.Game   `json:"settings"`
+}
+
+// picks out players to kick or ban. any non empty field matches.
+type playerSelector struct {
+	Room      string `json:"room,omitempty"` // empty means every room
+	PlayerID  string `json:"player_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+}
+
+func (s playerSelector) matches(p *game.Player) bool {
+	return (s.PlayerID != "" && p.ID == s.PlayerID) ||
+		(s.SessionID != "" && p.SessionID == s.SessionID) ||
+		(s.IP != "" && p.IP == s.IP)
+}
+
+func (s playerSelector) empty() bool {
+	return s.PlayerID == "" && s.SessionID == "" && s.IP == ""
+}
+
+// every admin endpoint, mounted under /admin/ behind the admin token:
+//
+//	GET    /admin/rooms                       rooms with their players (position, health, weapon, ping)
+//	GET    /admin/rooms/{room}
+//	POST   /admin/rooms/{room}/spawn          {"kind":"weapon","type":"shotgun","x":100,"y":200}
+//	POST   /admin/rooms/{room}/end-match
+//	POST   /admin/rooms/{room}/start-match
+//	GET    /admin/rooms/{room}/settings
+//	PATCH  /admin/rooms/{room}/settings       any subset of the game settings, applied live
+//	POST   /admin/kick                        {"session_id":"...","reason":"..."} (or player_id / ip, optional room)
+//	GET    /admin/bans
+//	POST   /admin/bans                        {"session_id":"...","ip":"...","reason":"...","duration":"24h"}
+//	DELETE /admin/bans/{id}
+//	GET    /admin/mutes
+//	POST   /admin/mutes                       {"session_id":"...","reason":"...","duration":"1h"}
+//	DELETE /admin/mutes/{id}
+//	GET    /admin/heatmaps/{room}             ?kind=position|kill|death&match=12&format=json|png&scale=16
+//	GET    /admin/timeline/{session}          ?match=12
+func adminHandler(rooms *RoomManager) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/rooms", func(w http.ResponseWriter, r *http.Request) {
+		out := []adminRoom{}
+		for _, room := range rooms.all() {
+			out = append(out, room.adminView())
+		}
+		server.WriteJSON(w, http.StatusOK, out)
+	})
+
+	mux.HandleFunc("GET /admin/rooms/{room}", withRoom(rooms, func(w http.ResponseWriter, r *http.Request, room *GameState) {
+		server.WriteJSON(w, http.StatusOK, room.adminView())
+	}))
+
+	mux.HandleFunc("POST /admin/rooms/{room}/spawn", withRoom(rooms, func(w http.ResponseWriter, r *http.Request, room *GameState) {
+		var req struct {
+			Kind string  `json:"kind"` // "weapon" or "powerup"
+			Type string  `json:"type"`
+			X    float64 `json:"x"`
+			Y    float64 `json:"y"`
+		}
+		if err := server.DecodeJSON(r, &req); err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		id, err := room.forceSpawn(req.Kind, req.Type, game.Position{X: req.X, Y: req.Y})
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		logging.Main.Info("admin spawned pickup", "room", room.id, "kind", req.Kind, "type", req.Type, "x", req.X, "y", req.Y)
+		server.WriteJSON(w, http.StatusCreated, map[string]string{"id": id})
+	}))
+
+	mux.HandleFunc("POST /admin/rooms/{room}/end-match", withRoom(rooms, func(w http.ResponseWriter, r *http.Request, room *GameState) {
+		room.endMatch()
+		logging.Main.Info("admin ended match", "room", room.id)
+		server.WriteJSON(w, http.StatusOK, room.adminView())
+	}))
+
+	mux.HandleFunc("POST /admin/rooms/{room}/start-match", withRoom(rooms, func(w http.ResponseWriter, r *http.Request, room *GameState) {
+		room.startMatch()
+		logging.Main.Info("admin started match", "room", room.id)
+		server.WriteJSON(w, http.StatusOK, room.adminView())
+	}))
+
+	mux.HandleFunc("GET /admin/rooms/{room}/settings", withRoom(rooms, func(w http.ResponseWriter, r *http.Request, room *GameState) {
+		server.WriteJSON(w, http.StatusOK, room.settings())
+	}))
+
+	mux.HandleFunc("PATCH /admin/rooms/{room}/settings", withRoom(rooms, func(w http.ResponseWriter, r *http.Request, room *GameState) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		cfg, err := room.updateSettings(body)
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		logging.Main.Info("admin changed room settings", "room", room.id, "patch", string(body))
+		server.WriteJSON(w, http.StatusOK, cfg)
+	}))
+
+	mux.HandleFunc("POST /admin/kick", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			playerSelector
+			Reason string `json:"reason"`
+		}
+		if err := server.DecodeJSON(r, &req); err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.empty() {
+			server.WriteError(w, http.StatusBadRequest, errors.New("need a player_id, session_id or ip"))
+			return
+		}
+		if req.Reason == "" {
+			req.Reason = "kicked by an admin"
+		}
+		kicked := kickPlayers(rooms, req.playerSelector, "kicked", req.Reason)
+		logging.Main.Info("admin kick", "selector", req.playerSelector, "kicked", kicked)
+		server.WriteJSON(w, http.StatusOK, map[string][]string{"kicked": kicked})
+	})
+
+	mux.HandleFunc("GET /admin/bans", func(w http.ResponseWriter, r *http.Request) {
+		bans, err := rooms.store.ListBans()
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if bans == nil {
+			bans = []database.Ban{}
+		}
+		server.WriteJSON(w, http.StatusOK, bans)
+	})
+
+	mux.HandleFunc("POST /admin/bans", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SessionID string          `json:"session_id"`
+			IP        string          `json:"ip"`
+			Reason    string          `json:"reason"`
+			Duration  config.Duration `json:"duration"` // empty or 0 is permanent
+		}
+		if err := server.DecodeJSON(r, &req); err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.SessionID == "" && req.IP == "" {
+			server.WriteError(w, http.StatusBadRequest, errors.New("need a session_id or ip to ban"))
+			return
+		}
+		if req.Duration < 0 {
+			server.WriteError(w, http.StatusBadRequest, errors.New("duration can't be negative"))
+			return
+		}
+		if req.Reason == "" {
+			req.Reason = "banned by an admin"
+		}
+		ban := &database.Ban{SessionID: req.SessionID, IP: req.IP, Reason: req.Reason}
+		if req.Duration > 0 {
+			expires := time.Now().Add(req.Duration.Duration())
+			ban.ExpiresAt = &expires
+		}
+		if err := rooms.store.AddBan(ban); err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// anyone matching the ban who is playing right now goes too
+		kicked := kickPlayers(rooms, playerSelector{SessionID: req.SessionID, IP: req.IP}, "banned", req.Reason)
+		logging.Main.Info("admin ban", "ban", ban.ID, "session", ban.SessionID, "ip", ban.IP, "duration", req.Duration, "kicked", kicked)
+		server.WriteJSON(w, http.StatusCreated, map[string]any{"ban": ban, "kicked": kicked})
+	})
+
+	mux.HandleFunc("DELETE /admin/bans/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, fmt.Errorf("bad ban id %q", r.PathValue("id")))
+			return
+		}
+		found, err := rooms.store.RemoveBan(id)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			server.WriteError(w, http.StatusNotFound, fmt.Errorf("no ban with id %d", id))
+			return
+		}
+		logging.Main.Info("admin unban", "ban", id)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /admin/mutes", func(w http.ResponseWriter, r *http.Request) {
+		mutes, err := rooms.store.ListMutes()
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if mutes == nil {
+			mutes = []database.Mute{}
+		}
+		server.WriteJSON(w, http.StatusOK, mutes)
+	})
+
+	mux.HandleFunc("POST /admin/mutes", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SessionID string          `json:"session_id"`
+			Reason    string          `json:"reason"`
+			Duration  config.Duration `json:"duration"` // empty or 0 is permanent
+		}
+		if err := server.DecodeJSON(r, &req); err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.SessionID == "" {
+			server.WriteError(w, http.StatusBadRequest, errors.New("need a session_id to mute"))
+			return
+		}
+		if req.Duration < 0 {
+			server.WriteError(w, http.StatusBadRequest, errors.New("duration can't be negative"))
+			return
+		}
+		if req.Reason == "" {
+			req.Reason = "muted by an admin"
+		}
+		mute := &database.Mute{SessionID: req.SessionID, Reason: req.Reason}
+		if req.Duration > 0 {
+			expires := time.Now().Add(req.Duration.Duration())
+			mute.ExpiresAt = &expires
+		}
+		if err := rooms.store.AddMute(mute); err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		muted := setMute(rooms, req.SessionID, mute)
+		logging.Main.Info("admin mute", "mute", mute.ID, "session", mute.SessionID, "duration", req.Duration, "muted", muted)
+		server.WriteJSON(w, http.StatusCreated, map[string]any{"mute": mute, "muted": muted})
+	})
+
+	mux.HandleFunc("DELETE /admin/mutes/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, fmt.Errorf("bad mute id %q", r.PathValue("id")))
+			return
+		}
+		// need the session to let anyone playing chat again, expired mutes don't matter for that
+		mutes, err := rooms.store.ListMutes()
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		var sessionID string
+		for _, m := range mutes {
+			if m.ID == id {
+				sessionID = m.SessionID
+			}
+		}
+		found, err := rooms.store.RemoveMute(id)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			server.WriteError(w, http.StatusNotFound, fmt.Errorf("no mute with id %d", id))
+			return
+		}
+		if sessionID != "" {
+			// there might be another mute on the same session
+			next, err := rooms.store.FindMute(sessionID)
+			if err != nil {
+				server.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			setMute(rooms, sessionID, next)
+		}
+		logging.Main.Info("admin unmute", "mute", id, "session", sessionID)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// rooms that aren't running still have their heatmaps
+	mux.HandleFunc("GET /admin/heatmaps/{room}", handleHeatmap(rooms.store))
+	mux.HandleFunc("GET /admin/timeline/{session}", handleTimeline(rooms.store))
+
+	return mux
+}
+
+// resolves {room} from the path, 404 if that room isn't running
+func withRoom(rooms *RoomManager, h func(http.ResponseWriter, *http.Request, *GameState)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room := rooms.lookup(r.PathValue("room"))
+		if room == nil {
+			server.WriteError(w, http.StatusNotFound, fmt.Errorf("no room %q", r.PathValue("room")))
+			return
+		}
+		h(w, r, room)
+	}
+}
+
+func (gs *GameState) adminView() adminRoom {
+	gs.mutex.RLock()
+	defer gs.mutex.RUnlock()
+	view := adminRoom{
+		Name:        gs.id,
+		MatchActive: gs.matchActive,
+		Seq:         gs.seq,
+		Players:     []adminPlayer{},
+		Spectators:  len(gs.spectators),
+		Bullets:     len(gs.bullets),
+		Weapons:     len(gs.weapons),
+		PowerUps:    len(gs.powerups),
+		Settings:    gs.cfg,
+	}
+	for _, p := range gs.players {
+		view.Players = append(view.Players, adminPlayer{
+			ID:        p.ID,
+			Username:  p.Username,
+			SessionID: p.SessionID,
+			IP:        p.IP,
+			Connected: p.Conn != nil,
+			Bot:       p.IsBot,
+			Position:  p.Position,
+			Health:    p.Health,
+			Weapon:    p.Weapon,
+			IsDead:    p.IsDead,
+			Kills:     p.Kills,
+			Deaths:    p.Deaths,
+			Ping:      p.RTT.Milliseconds(),
+		})
+	}
+	sort.Slice(view.Players, func(i, j int) bool { return view.Players[i].ID < view.Players[j].ID })
+	return view
+}
+
+// kicks everyone matching sel out of the selected room (or all of them) and returns their ids
+func kickPlayers(rooms *RoomManager, sel playerSelector, msgType, reason string) []string {
+	kicked := []string{}
+	for _, room := range rooms.all() {
+		if sel.Room != "" && room.id != sel.Room {
+			continue
+		}
+		room.mutex.Lock()
+		for _, p := range room.players {
+			if sel.matches(p) {
+				room.kick(p, msgType, reason)
+				kicked = append(kicked, p.ID)
+			}
+		}
+		room.mutex.Unlock()
+	}
+	return kicked
+}
+
+// puts the mute (nil to lift it) on the session wherever it's playing, tells them and returns
+// their player ids
+func setMute(rooms *RoomManager, sessionID string, mute *database.Mute) []string {
+	affected := []string{}
+	for _, room := range rooms.all() {
+		room.mutex.Lock()
+		for _, p := range room.players {
+			if p.SessionID != sessionID || p.IsBot {
+				continue
+			}
+			applyMute(p, mute)
+			if mute == nil {
+				p.SendMessage(Message{Type: "unmuted", Seq: room.seq})
+			} else {
+				msg := Message{Type: "muted", Seq: room.seq, Reason: mute.Reason}
+				if mute.ExpiresAt != nil {
+					msg.Duration = time.Until(*mute.ExpiresAt).Milliseconds()
+				}
+				p.SendMessage(msg)
+			}
+			affected = append(affected, p.ID)
+		}
+		room.mutex.Unlock()
+	}
+	return affected
+}
+
+// tells the player why, closes their connection with a policy violation (the client
+// doesn't auto reconnect on that) and removes them without a grace window.
+// caller must hold the lock.
+func (gs *GameState) kick(p *game.Player, msgType, reason string) {
+	if conn := p.Conn; conn != nil {
+		p.SendMessage(Message{Type: msgType, Reason: reason})
+		p.Conn = nil
+		go conn.Drain(websocket.ClosePolicyViolation, reason, writeWait)
+	}
+	gs.dropPlayer(p)
+}
+
+// puts a pickup exactly where the admin asked, ignoring the spawn limits
+func (gs *GameState) forceSpawn(kind, typ string, pos game.Position) (string, error) {
+	if !isValidPosition(pos) || pos.X < 0 || pos.X > 1000 || pos.Y < 0 || pos.Y > 600 {
+		return "", fmt.Errorf("position %v is outside the map", pos)
+	}
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	switch kind {
+	case "weapon":
+		if _, ok := WeaponProperties[typ]; !ok {
+			return "", fmt.Errorf("unknown weapon %q", typ)
+		}
+		weapon := &Weapon{ID: generateID(gs.rng), Type: typ, Position: pos, SpawnTime: time.Now()}
+		gs.weapons[weapon.ID] = weapon
+		gs.broadcast(Message{
+			Type:     "weapon_spawn",
+			WeaponID: weapon.ID,
+			Position: weapon.Position,
+			Weapon:   weapon.Type,
+		})
+		return weapon.ID, nil
+	case "powerup":
+		if !slices.Contains(powerUpTypes, typ) {
+			return "", fmt.Errorf("unknown powerup %q", typ)
+		}
+		powerup := &PowerUp{ID: generateID(gs.rng), Type: typ, Position: pos, SpawnTime: time.Now()}
+		gs.powerups[powerup.ID] = powerup
+		gs.broadcast(Message{
+			Type:      "powerup_spawn",
+			PowerUpID: powerup.ID,
+			Position:  powerup.Position,
+			PowerUp:   powerup.Type,
+		})
+		return powerup.ID, nil
+	}
+	return "", fmt.Errorf("kind must be weapon or powerup, got %q", kind)
+}
+
+// stops the simulation, clears the bullets in flight and sends everyone the final scoreboard
+func (gs *GameState) endMatch() {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	if !gs.matchActive {
+		return
+	}
+	gs.wrapUpMatch()
+	gs.recorder.EndTick(gs.seq, time.Now())
+	gs.stopRecording()
+}
+
+// everything about ending the match but the recording, which shutdown closes once the loop
+// has stopped. caller must hold the lock.
+func (gs *GameState) wrapUpMatch() {
+	gs.matchActive = false
+	for id := range gs.bullets {
+		delete(gs.bullets, id)
+	}
+	table := gs.statsTable()
+	gs.broadcast(Message{Type: "match_end", Scoreboard: gs.scoreboard(), Stats: table})
+	gs.finishMatch(table)
+}
+
+func (gs *GameState) startMatch() {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	if gs.matchActive {
+		return
+	}
+	gs.beginMatch()
+	gs.broadcast(Message{Type: "match_started", MatchStarted: true})
+}
+
+// applies a partial settings update on top of the current ones. the loops pick
+// the new values up on their next tick/spawn.
+func (gs *GameState) updateSettings(patch []byte) (config.Game, error) {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	next := gs.cfg
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&next); err != nil {
+		return gs.cfg, fmt.Errorf("bad settings: %v", err)
+	}
+	if err := next.Validate(); err != nil {
+		return gs.cfg, err
+	}
+	gs.cfg = next
+	gs.chatFilter = newChatFilter(next)
+	return next, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/profile"
+	"math"
+	"sort"
+	"time"
+)
+
+// bots keep a room lively when there aren't many people on. they're ordinary players
+// without a connection: everything they do goes through handleInput like a human's
+// messages would, so they can't do anything a person couldn't.
+const (
+	botSpeed        = 300.0 // same as the web client, 5px a frame at 60fps
+	botKeepDistance = 180.0 // how close they like to get to whoever they're fighting
+	botAimEvery     = 100 * time.Millisecond
+)
+
+var botNames = []string{"Bolt", "Rivet", "Sprocket", "Cog", "Widget", "Gizmo", "Servo", "Piston", "Ratchet", "Flux"}
+
+// what a bot is thinking, the rest of its state lives on its game.Player like anyone else's
+type botBrain struct {
+	target    string    // who they're fighting
+	spotted   time.Time // when they picked that target, they hold fire until their reaction time is up
+	waypoint  game.Position
+	strafe    float64 // which way they circle, 1 or -1
+	lastAimAt time.Time
+}
+
+// people in the room, as opposed to bots. caller must hold the lock.
+func (gs *GameState) humanCount() int {
+	return len(gs.players) - len(gs.bots)
+}
+
+// adds or removes bots so people plus bots come to bot_fill. caller must hold the lock.
+func (gs *GameState) balanceBots() {
+	want := max(0, min(gs.cfg.BotFill, gs.cfg.MaxPlayers)-gs.humanCount())
+	for len(gs.bots) < want {
+		gs.addBot()
+	}
+	for len(gs.bots) > want {
+		gs.benchBot()
+	}
+}
+
+// caller must hold the lock
+func (gs *GameState) addBot() {
+	id := generateID(gs.rng)
+	p := &game.Player{
+		ID:        id,
+		SessionID: "bot-" + id,
+		Username:  "[BOT] " + botNames[gs.rng.Intn(len(botNames))],
+		IsBot:     true,
+		Health:    gs.cfg.MaxHealth,
+		Color:     gs.rng.Intn(0xFFFFFF),
+		Sprite:    profile.Sprites[gs.rng.Intn(len(profile.Sprites))],
+	}
+	p.Team = gs.smallestTeam()
+	p.ResetInventory("pistol")
+	p.Position = getRandomSpawnPoint(gs)
+	p.LastKnownPosition = p.Position
+	gs.players[id] = p
+	gs.bots[id] = &botBrain{waypoint: p.Position, strafe: 1}
+	logging.Sim.Info("bot joined", "room", gs.id, "player", id, "username", p.Username)
+}
+
+// takes a bot out to make room for a person. false if there are no bots.
+// caller must hold the lock.
+func (gs *GameState) benchBot() bool {
+	ids := gs.botIDs()
+	if len(ids) == 0 {
+		return false
+	}
+	p := gs.players[ids[len(ids)-1]]
+	gs.dropPlayer(p)
+	logging.Sim.Info("bot left", "room", gs.id, "player", p.ID)
+	return true
+}
+
+// sorted so bots think in the same order every tick, which keeps seeded matches repeatable
+func (gs *GameState) botIDs() []string {
+	ids := make([]string, 0, len(gs.bots))
+	for id := range gs.bots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// runs every bot for a tick, and about once a second tops the room up or thins it out.
+// caller must hold the lock.
+func tickBots(gameState *GameState, now time.Time, dt float64) {
+	if gameState.seq%uint64(gameState.cfg.TickRate) == 0 {
+		gameState.balanceBots()
+	}
+	skill := gameState.cfg.BotSkill()
+	for _, id := range gameState.botIDs() {
+		gameState.bots[id].think(gameState, gameState.players[id], skill, now, dt)
+	}
+}
+
+func (b *botBrain) think(gs *GameState, p *game.Player, skill config.BotSkill, now time.Time, dt float64) {
+	if p.IsDead {
+		b.target = ""
+		return
+	}
+	var goal game.Position
+	if enemy := b.pickTarget(gs, p, skill, now); enemy != nil {
+		dx, dy := enemy.Position.X-p.Position.X, enemy.Position.Y-p.Position.Y
+		if math.Hypot(dx, dy) > botKeepDistance {
+			goal = enemy.Position
+		} else {
+			// close enough, circle them
+			angle := math.Atan2(dy, dx) + b.strafe*math.Pi/2
+			goal = game.Position{X: p.Position.X + math.Cos(angle)*50, Y: p.Position.Y + math.Sin(angle)*50}
+		}
+		b.shoot(gs, p, enemy, skill, now)
+	} else {
+		if p.TriggerHeld {
+			handleInput(gs, p, Message{Type: "trigger", Held: false, Rotation: p.Aim}, now)
+		}
+		goal = b.loot(gs, p, now)
+	}
+	b.moveToward(gs, p, goal, dt, now)
+}
+
+// sticks with the current target while they're alive and not too far off, otherwise
+// picks the closest visible player in range
+func (b *botBrain) pickTarget(gs *GameState, p *game.Player, skill config.BotSkill, now time.Time) *game.Player {
+	if t, ok := gs.players[b.target]; ok && !t.IsDead && !t.HasEffect(effectInvisibility) &&
+		distance(p.Position, t.Position) < skill.Range*1.2 {
+		return t
+	}
+	var best *game.Player
+	bestDist := skill.Range
+	for _, other := range gs.players {
+		if other.ID == p.ID || other.IsDead || other.HasEffect(effectInvisibility) {
+			continue
+		}
+		if d := distance(p.Position, other.Position); d < bestDist {
+			best, bestDist = other, d
+		}
+	}
+	if best == nil {
+		b.target = ""
+		return nil
+	}
+	if best.ID != b.target {
+		b.target = best.ID
+		b.spotted = now
+		if gs.rng.Intn(2) == 0 {
+			b.strafe = -b.strafe
+		}
+	}
+	return best
+}
+
+// aims at the enemy, off by up to the skill's spread, and works the trigger like a person would
+func (b *botBrain) shoot(gs *GameState, p *game.Player, enemy *game.Player, skill config.BotSkill, now time.Time) {
+	if now.Sub(b.spotted) < skill.Reaction.Duration() {
+		return
+	}
+	aim := math.Atan2(enemy.Position.Y-p.Position.Y, enemy.Position.X-p.Position.X) + (gs.rng.Float64()*2-1)*skill.Spread
+	props := WeaponProperties[p.Weapon]
+	switch {
+	case p.Overheated[p.ActiveSlot]:
+		if p.TriggerHeld {
+			handleInput(gs, p, Message{Type: "trigger", Held: false, Rotation: aim}, now)
+		}
+		// swap to the other gun while this one cools
+		other := 1 - p.ActiveSlot
+		if p.Weapons[other] != "" {
+			handleInput(gs, p, Message{Type: "switch_weapon", Slot: slotNumber(other)}, now)
+		}
+	case props.Automatic:
+		// hold it down and keep the aim fresh
+		if !p.TriggerHeld || now.Sub(b.lastAimAt) >= botAimEvery {
+			b.lastAimAt = now
+			handleInput(gs, p, Message{Type: "trigger", Held: true, Rotation: aim}, now)
+		}
+	case p.TriggerHeld:
+		handleInput(gs, p, Message{Type: "trigger", Held: false, Rotation: aim}, now)
+	case !now.Before(p.NextShotAt):
+		handleInput(gs, p, Message{Type: "trigger", Held: true, Rotation: aim}, now)
+	}
+}
+
+// where to head when there's no one to fight: the closest weapon they don't have or
+// powerup, or else somewhere random on the map
+func (b *botBrain) loot(gs *GameState, p *game.Player, now time.Time) game.Position {
+	var goal *game.Position
+	best := math.Inf(1)
+	for _, w := range gs.weapons {
+		if p.HasWeapon(w.Type) {
+			continue
+		}
+		if d := distance(p.Position, w.Position); d < best {
+			goal, best = &w.Position, d
+		}
+		if best < weaponPickupRange {
+			handleInput(gs, p, Message{Type: "pickup_weapon"}, now)
+			break
+		}
+	}
+	for _, pu := range gs.powerups {
+		if d := distance(p.Position, pu.Position); d < best {
+			goal, best = &pu.Position, d
+		}
+	}
+	if goal != nil {
+		return *goal
+	}
+	if distance(p.Position, b.waypoint) < 20 {
+		b.waypoint = game.Position{X: 50 + gs.rng.Float64()*900, Y: 50 + gs.rng.Float64()*500}
+	}
+	return b.waypoint
+}
+
+// walks toward goal at human speed through the same position message the client sends
+func (b *botBrain) moveToward(gs *GameState, p *game.Player, goal game.Position, dt float64, now time.Time) {
+	dx, dy := goal.X-p.Position.X, goal.Y-p.Position.Y
+	dist := math.Hypot(dx, dy)
+	if dist < 1 {
+		return
+	}
+	step := min(dist, botSpeed*p.Magnitude(effectSpeedBoost)*dt)
+	next := game.Position{
+		X: max(0, min(1000, p.Position.X+dx/dist*step)),
+		Y: max(0, min(600, p.Position.Y+dy/dist*step)),
+	}
+	handleInput(gs, p, Message{Type: "position", Position: next}, now)
+}
+
+func distance(a, b game.Position) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/wordfilter"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// who a chat message goes to
+const (
+	chatRoom    = "room"    // everyone in the room, spectators too
+	chatTeam    = "team"    // players on the sender's team
+	chatWhisper = "whisper" // one player, Target
+)
+
+// quick chat ids the client can send instead of text. they're ours so they skip the filter
+// and the length cap, but not the rate limit or a mute.
+var quickChat = map[string]string{
+	"gg":        "Good game!",
+	"hello":     "Hello!",
+	"thanks":    "Thanks!",
+	"sorry":     "Sorry!",
+	"nice_shot": "Nice shot!",
+	"help":      "Need help!",
+	"follow_me": "Follow me!",
+	"on_my_way": "On my way!",
+	"retreat":   "Fall back!",
+}
+
+// a chat message kept for players who join after it was sent
+type chatEntry struct {
+	msg  Message
+	team string // the sender's team, for team messages
+}
+
+// who can read an entry. nil is a spectator, they only get the room's messages.
+func (e chatEntry) visibleTo(p *game.Player) bool {
+	switch e.msg.Scope {
+	case chatRoom:
+		return true
+	case chatTeam:
+		return p != nil && p.Team != "" && p.Team == e.team
+	case chatWhisper:
+		return p != nil && (p.ID == e.msg.PlayerID || p.ID == e.msg.Target)
+	}
+	return false
+}
+
+func newChatFilter(cfg config.Game) *wordfilter.Filter {
+	return wordfilter.New(cfg.ChatBlockedWords...)
+}
+
+// the messages p would have seen had they been here all along, oldest first.
+// caller must hold the lock.
+func (gs *GameState) chatHistory(p *game.Player) []Message {
+	history := []Message{}
+	for _, e := range gs.chat {
+		if e.visibleTo(p) {
+			history = append(history, e.msg)
+		}
+	}
+	return history
+}
+
+// caller must hold the lock
+func (gs *GameState) keepChat(e chatEntry) {
+	gs.chat = append(gs.chat, e)
+	if over := len(gs.chat) - gs.cfg.ChatHistory; over > 0 {
+		gs.chat = slices.Delete(gs.chat, 0, over)
+	}
+}
+
+// puts a mute (or nil for none) on a player
+func applyMute(p *game.Player, mute *database.Mute) {
+	p.Muted = mute != nil
+	p.MutedUntil = time.Time{}
+	if mute != nil && mute.ExpiresAt != nil {
+		p.MutedUntil = *mute.ExpiresAt
+	}
+}
+
+func mutedAt(p *game.Player, now time.Time) bool {
+	return p.Muted && (p.MutedUntil.IsZero() || now.Before(p.MutedUntil))
+}
+
+// takes one message out of the player's allowance, false when they've used it up. the
+// allowance refills by one every chat_interval up to chat_burst. caller must hold the lock.
+func (gs *GameState) allowChat(p *game.Player, now time.Time) bool {
+	burst := float64(gs.cfg.ChatBurst)
+	if p.ChatCheckedAt.IsZero() {
+		p.ChatAllowance = burst
+	} else {
+		p.ChatAllowance += float64(now.Sub(p.ChatCheckedAt)) / float64(gs.cfg.ChatInterval)
+		p.ChatAllowance = min(p.ChatAllowance, burst)
+	}
+	p.ChatCheckedAt = now
+	if p.ChatAllowance < 1 {
+		return false
+	}
+	p.ChatAllowance--
+	return true
+}
+
+// newlines and other control characters out, surrounding space trimmed
+func cleanChat(s string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+// a chat message from a player. anything wrong with it goes back to them alone as a
+// chat_error. caller must hold the lock.
+func (gs *GameState) handleChat(p *game.Player, msg Message, now time.Time) {
+	if p.IsBot {
+		return
+	}
+	fail := func(reason string) {
+		p.SendMessage(Message{Type: "chat_error", Seq: gs.seq, Reason: reason})
+	}
+	if mutedAt(p, now) {
+		fail("you're muted")
+		return
+	}
+	// every attempt counts, otherwise spamming bad messages would be free
+	if !gs.allowChat(p, now) {
+		fail("you're sending messages too fast")
+		return
+	}
+
+	var text string
+	if msg.QuickChat != "" {
+		var ok bool
+		if text, ok = quickChat[msg.QuickChat]; !ok {
+			fail(fmt.Sprintf("no quick chat %q", msg.QuickChat))
+			return
+		}
+	} else {
+		text = cleanChat(msg.Text)
+		if text == "" {
+			fail("message is empty")
+			return
+		}
+		if utf8.RuneCountInString(text) > gs.cfg.ChatMaxLength {
+			fail(fmt.Sprintf("messages are at most %d characters", gs.cfg.ChatMaxLength))
+			return
+		}
+		switch gs.cfg.ChatFilter {
+		case config.ChatFilterMask:
+			text = gs.chatFilter.Mask(text)
+		case config.ChatFilterBlock:
+			if gs.chatFilter.Contains(text) {
+				fail("that message isn't allowed")
+				return
+			}
+		}
+	}
+
+	out := Message{
+		Type:      "chat",
+		Seq:       gs.seq,
+		PlayerID:  p.ID,
+		Username:  p.Username,
+		Text:      text,
+		QuickChat: msg.QuickChat,
+		SentAt:    now.UnixMilli(),
+	}
+	switch msg.Scope {
+	case "", chatRoom:
+		out.Scope = chatRoom
+		gs.broadcast(out)
+	case chatTeam:
+		if p.Team == "" {
+			fail("there are no teams in this room")
+			return
+		}
+		out.Scope = chatTeam
+		for _, mate := range gs.players {
+			if mate.Team == p.Team {
+				mate.SendMessage(out)
+			}
+		}
+	case chatWhisper:
+		to, ok := gs.players[msg.Target]
+		if !ok || to.IsBot || to == p {
+			fail("no one to whisper to")
+			return
+		}
+		out.Scope = chatWhisper
+		out.Target = to.ID
+		to.SendMessage(out)
+		p.SendMessage(out) // so it shows up on their side too
+	default:
+		fail(fmt.Sprintf("unknown chat scope %q", msg.Scope))
+		return
+	}
+
+	gs.keepChat(chatEntry{msg: out, team: p.Team})
+	gs.logEvent(p, models.EventChat, models.ChatDetails{
+		Scope:     out.Scope,
+		Target:    out.Target,
+		Text:      text,
+		QuickChat: msg.QuickChat,
+	}, now)
+	logging.Main.Debug("chat", "room", gs.id, "player", p.ID, "scope", out.Scope, "target", out.Target, "text", text)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/heatmap"
+	"arena-tactics/internal/logging"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// damage within this long before a kill earns an assist
+const assistWindow = 10 * time.Second
+
+// the player's stats for the current match, created on first use. caller must hold the lock.
+func (gs *GameState) statsFor(p *game.Player) *game.MatchStats {
+	s, ok := gs.matchStats[p.ID]
+	if !ok {
+		s = &game.MatchStats{PlayerID: p.ID, Username: p.Username}
+		gs.matchStats[p.ID] = s
+	}
+	return s
+}
+
+// everyones match stats, best first (kills, then assists, then fewest deaths). caller must hold the lock.
+func (gs *GameState) statsTable() []game.MatchStats {
+	table := make([]game.MatchStats, 0, len(gs.matchStats))
+	for _, s := range gs.matchStats {
+		s.Finalize()
+		table = append(table, *s)
+	}
+	sort.Slice(table, func(i, j int) bool {
+		a, b := table[i], table[j]
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		if a.Assists != b.Assists {
+			return a.Assists > b.Assists
+		}
+		return a.Deaths < b.Deaths
+	})
+	return table
+}
+
+// lands one hit on the victim: the attacker's damage amp scales it, the force field soaks
+// up what it can and the rest comes off their health. sends a damage event and handles the
+// kill if it was the last hit. caller must hold the lock.
+func applyDamage(gameState *GameState, attacker, victim *game.Player, weapon string, amount int, now time.Time) {
+	amount = int(math.Round(float64(amount) * attacker.Magnitude(effectDamageAmp)))
+	absorbed := 0
+	if victim.ForceFieldActive && victim.Shield > 0 {
+		absorbed = min(victim.Shield, amount)
+		victim.Shield -= absorbed
+		if victim.Shield == 0 {
+			gameState.removeEffect(victim, effectForceField, "broken", now)
+		}
+	}
+	dealt := min(amount-absorbed, victim.Health)
+	victim.Health -= dealt
+
+	attackerStats := gameState.statsFor(attacker)
+	attackerStats.ShotsHit++
+	attackerStats.DamageDealt += dealt
+	victimStats := gameState.statsFor(victim)
+	victimStats.DamageTaken += dealt
+	victimStats.ShieldAbsorbed += absorbed
+
+	if victim.DamagedBy == nil {
+		victim.DamagedBy = make(map[string]time.Time)
+	}
+	victim.DamagedBy[attacker.ID] = now
+
+	gameState.broadcast(Message{
+		Type:       "damage",
+		PlayerID:   victim.ID,
+		AttackerID: attacker.ID,
+		Weapon:     weapon,
+		Damage:     dealt,
+		Absorbed:   absorbed,
+		Health:     victim.Health,
+	})
+
+	if victim.Health <= 0 {
+		handleKill(gameState, attacker, victim, weapon, now)
+	}
+}
+
+// credits a kill: death state, streaks, assists, lifetime stats in the db and the kill feed.
+// caller must hold the lock.
+func handleKill(gameState *GameState, killer, victim *game.Player, weapon string, now time.Time) {
+	handlePlayerDeath(gameState, victim, victim.LastKnownPosition, killer.ID, weapon)
+	// where it happened, for the kill and death heatmaps
+	if id := gameState.matchID(); id != 0 {
+		gameState.telemetry.Hit(id, gameState.id, heatmap.Kills, killer.Position.X, killer.Position.Y)
+		gameState.telemetry.Hit(id, gameState.id, heatmap.Deaths, victim.Position.X, victim.Position.Y)
+	}
+
+	killerStats := gameState.statsFor(killer)
+	killerStats.Kills++
+	killerStats.Streak++
+	killerStats.LongestStreak = max(killerStats.LongestStreak, killerStats.Streak)
+	victimStats := gameState.statsFor(victim)
+	victimStats.Deaths++
+	victimStats.Streak = 0
+
+	// everyone else who hurt the victim recently gets an assist
+	var assists []string
+	for id, hitAt := range victim.DamagedBy {
+		if id == killer.ID || now.Sub(hitAt) > assistWindow {
+			continue
+		}
+		if helper, ok := gameState.players[id]; ok {
+			gameState.statsFor(helper).Assists++
+			assists = append(assists, id)
+		}
+	}
+	sort.Strings(assists)
+	victim.DamagedBy = nil
+	gameState.logEvent(killer, models.EventKill, models.KillDetails{
+		VictimID: victim.ID,
+		Weapon:   weapon,
+		Assists:  assists,
+		Streak:   killerStats.Streak,
+	}, now)
+
+	// lifetime stats go through the telemetry writer, bots don't have a row in the players table
+	killer.Kills++
+	if !killer.IsBot {
+		gameState.telemetry.Stats(killer.SessionID, 1, 0)
+	}
+	victim.Deaths++
+	if !victim.IsBot {
+		gameState.telemetry.Stats(victim.SessionID, 0, 1)
+	}
+
+	gameState.broadcast(Message{
+		Type:     "kill",
+		KillerID: killer.ID,
+		PlayerID: victim.ID,
+		Weapon:   weapon,
+		Assists:  assists,
+		Streak:   killerStats.Streak,
+	})
+	logging.Sim.Info("kill", "room", gameState.id, "killer", killer.ID, "victim", victim.ID,
+		"weapon", weapon, "assists", assists, "streak", killerStats.Streak)
+
+	creditMultiKill(gameState, killer, killerStats, now)
+	grantStreakReward(gameState, killer, killerStats, now)
+}
+
+// chains kills that land within the multi kill window and announces double kills and up.
+// caller must hold the lock.
+func creditMultiKill(gameState *GameState, killer *game.Player, stats *game.MatchStats, now time.Time) {
+	if !stats.LastKillAt.IsZero() && now.Sub(stats.LastKillAt) <= gameState.cfg.MultiKillWindow.Duration() {
+		stats.MultiKill++
+	} else {
+		stats.MultiKill = 1
+	}
+	stats.LastKillAt = now
+	stats.BestMultiKill = max(stats.BestMultiKill, stats.MultiKill)
+	if stats.MultiKill < 2 {
+		return
+	}
+	gameState.broadcast(Message{
+		Type:      "multikill",
+		PlayerID:  killer.ID,
+		MultiKill: stats.MultiKill,
+	})
+}
+
+// the effect each killstreak reward applies
+var rewardEffects = map[string]string{
+	config.RewardForceField: effectForceField,
+	config.RewardTeleport:   effectTeleport,
+	config.RewardRadar:      effectRadar,
+}
+
+// hands out the killstreak reward for the killer's current streak, if there is one.
+// rewards are effects like the powerups and are lost on death like them.
+// caller must hold the lock.
+func grantStreakReward(gameState *GameState, killer *game.Player, stats *game.MatchStats, now time.Time) {
+	if killer.IsDead {
+		// traded kills don't pay out, the streak is already gone
+		return
+	}
+	var reward string
+	for _, ks := range gameState.cfg.Killstreaks {
+		if ks.Kills == stats.Streak {
+			reward = ks.Reward
+			break
+		}
+	}
+	if reward == "" {
+		return
+	}
+	if !gameState.applyEffect(killer, rewardEffects[reward], now) {
+		// already had it and it doesn't stack, so there's nothing to announce
+		return
+	}
+	gameState.broadcast(Message{Type: "killstreak", PlayerID: killer.ID, Streak: stats.Streak, Reward: reward})
+	stats.Rewards = append(stats.Rewards, reward)
+	gameState.logPowerUp(killer, rewardEffects[reward], now)
+	logging.Sim.Info("killstreak reward", "room", gameState.id, "player", killer.ID, "streak", stats.Streak, "reward", reward)
+}
+
+// sets up a fresh match: clean stats, a new recording and a row in the store. the row is
+// written by the telemetry writer, the match gets its id once that's done. caller must hold the lock.
+func (gs *GameState) beginMatch() {
+	now := time.Now()
+	gs.matchActive = true
+	gs.matchStats = make(map[string]*game.MatchStats)
+	gs.startRecording()
+	match := &models.Match{Key: fmt.Sprintf("%s-%d", gs.id, now.UnixNano()), Room: gs.id, StartedAt: now}
+	gs.match = match
+	gs.telemetry.StartMatch(*match, func(id int64) {
+		gs.mutex.Lock()
+		defer gs.mutex.Unlock()
+		match.ID = id
+	})
+}
+
+// the current match's id, 0 between matches, until its row is written or when it couldn't be.
+// caller must hold the lock.
+func (gs *GameState) matchID() int64 {
+	if gs.match == nil {
+		return 0
+	}
+	return gs.match.ID
+}
+
+// records the end of the match and who won it. caller must hold the lock.
+func (gs *GameState) finishMatch(table []game.MatchStats) {
+	if gs.match == nil {
+		return
+	}
+	now := time.Now()
+	gs.match.EndedAt = &now
+	// bots and players who already left don't have a session to credit
+	if len(table) > 0 && table[0].Kills > 0 {
+		if p, ok := gs.players[table[0].PlayerID]; ok && !p.IsBot {
+			gs.match.WinnerSessionID = p.SessionID
+		}
+	}
+	gs.telemetry.EndMatch(*gs.match)
+	gs.match = nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"sort"
+	"time"
+)
+
+// every powerup and killstreak reward is a status effect on the player. the powerup
+// ones share their name with the powerup type, so adding a powerup is a case in
+// effectDef plus an entry in powerUpTypes.
+const (
+	effectTeleport     = "teleportation"
+	effectForceField   = "force_field"
+	effectRegen        = "health_regen"
+	effectSpeedBoost   = "speed_boost"
+	effectDamageAmp    = "damage_amp"
+	effectInvisibility = "invisibility"
+	effectRadar        = "radar" // killstreak only, enemies show on the minimap
+)
+
+// one active effect as clients see it. remaining is in milliseconds, 0 when it has no timer.
+type EffectState struct {
+	Name      string  `json:"name"`
+	Stacks    int     `json:"stacks"`
+	Remaining int64   `json:"remaining,omitempty"`
+	Magnitude float64 `json:"magnitude,omitempty"`
+}
+
+// builds the named effect from the room's current settings, nil if there's no such effect.
+// caller must hold the lock.
+func (gs *GameState) effectDef(name string) *game.EffectDef {
+	cfg := gs.cfg
+	switch name {
+	case effectTeleport:
+		// stays until death, using it puts it on cooldown instead (see the teleport message)
+		return &game.EffectDef{
+			Name:     name,
+			Stacking: game.StackIgnore,
+			OnApply:  func(p *game.Player, _ *game.Effect) { p.TeleportAvailable = true },
+			OnExpire: func(p *game.Player, _ *game.Effect) { p.TeleportAvailable = false },
+		}
+	case effectForceField:
+		// lasts until the shield is shot through, another pickup tops it back up
+		return &game.EffectDef{
+			Name:     name,
+			Stacking: game.StackRefresh,
+			Cooldown: cfg.ForceFieldCooldown.Duration(),
+			OnApply: func(p *game.Player, _ *game.Effect) {
+				p.ForceFieldActive = true
+				p.Shield = cfg.ShieldStrength
+			},
+			OnExpire: func(p *game.Player, _ *game.Effect) {
+				p.ForceFieldActive = false
+				p.Shield = 0
+			},
+		}
+	case effectRegen:
+		return &game.EffectDef{
+			Name:     name,
+			Duration: cfg.RegenDuration.Duration(),
+			Stacking: game.StackRefresh,
+			Cooldown: cfg.RegenCooldown.Duration(),
+			OnApply: func(p *game.Player, e *game.Effect) {
+				p.HealthRegenActive = true
+				e.Acc = 0
+			},
+			OnTick: func(p *game.Player, e *game.Effect, dt float64) {
+				if p.Health >= cfg.MaxHealth {
+					e.Acc = 0
+					return
+				}
+				// health comes in whole points, the fraction carries over to the next tick
+				e.Acc += cfg.RegenRate * dt
+				if e.Acc >= 1 {
+					increment := int(e.Acc)
+					p.Health = min(p.Health+increment, cfg.MaxHealth)
+					e.Acc -= float64(increment)
+				}
+			},
+			OnExpire: func(p *game.Player, _ *game.Effect) { p.HealthRegenActive = false },
+		}
+	case effectSpeedBoost:
+		// movement is client side, the client scales its speed by the magnitude
+		return &game.EffectDef{
+			Name:      name,
+			Duration:  cfg.SpeedBoostDuration.Duration(),
+			Stacking:  game.StackExtend,
+			Cooldown:  cfg.SpeedBoostCooldown.Duration(),
+			Magnitude: cfg.SpeedBoostFactor,
+		}
+	case effectDamageAmp:
+		return &game.EffectDef{
+			Name:      name,
+			Duration:  cfg.DamageAmpDuration.Duration(),
+			Stacking:  game.StackIntensity,
+			MaxStacks: cfg.DamageAmpMaxStacks,
+			Cooldown:  cfg.DamageAmpCooldown.Duration(),
+			Magnitude: cfg.DamageAmpFactor,
+		}
+	case effectInvisibility:
+		// clients stop drawing the player, shooting gives them away (see the shoot message)
+		return &game.EffectDef{
+			Name:     name,
+			Duration: cfg.InvisibilityDuration.Duration(),
+			Stacking: game.StackRefresh,
+			Cooldown: cfg.InvisibilityCooldown.Duration(),
+		}
+	case effectRadar:
+		return &game.EffectDef{
+			Name:     name,
+			Duration: cfg.RadarDuration.Duration(),
+			Stacking: game.StackExtend,
+		}
+	}
+	return nil
+}
+
+// applies the named effect and tells everyone. false if it didn't take because
+// it's cooling down or already active and doesn't stack. caller must hold the lock.
+func (gs *GameState) applyEffect(p *game.Player, name string, now time.Time) bool {
+	def := gs.effectDef(name)
+	if def == nil {
+		logging.Sim.Warn("unknown effect", "room", gs.id, "effect", name)
+		return false
+	}
+	e := p.ApplyEffect(def, now)
+	if e == nil {
+		return false
+	}
+	gs.broadcast(Message{
+		Type:      "effect_start",
+		PlayerID:  p.ID,
+		Effect:    name,
+		Stacks:    e.Stacks,
+		Duration:  e.Remaining(now).Milliseconds(),
+		Magnitude: p.Magnitude(name),
+	})
+	logging.Sim.Debug("effect applied", "room", gs.id, "player", p.ID, "effect", name, "stacks", e.Stacks)
+	return true
+}
+
+// ends an effect early, reason is something like "used" or "broken". caller must hold the lock.
+func (gs *GameState) removeEffect(p *game.Player, name, reason string, now time.Time) {
+	if p.RemoveEffect(name, now) {
+		gs.effectEnded(p, name, reason, now)
+	}
+}
+
+// drops every effect without cooldowns, on death and respawn. caller must hold the lock.
+func (gs *GameState) clearEffects(p *game.Player, reason string, now time.Time) {
+	for _, name := range p.ClearEffects() {
+		gs.effectEnded(p, name, reason, now)
+	}
+}
+
+func (gs *GameState) effectEnded(p *game.Player, name, reason string, now time.Time) {
+	gs.broadcast(Message{
+		Type:     "effect_end",
+		PlayerID: p.ID,
+		Effect:   name,
+		Reason:   reason,
+		Cooldown: p.CooldownLeft(name, now).Milliseconds(),
+	})
+	logging.Sim.Debug("effect ended", "room", gs.id, "player", p.ID, "effect", name, "reason", reason)
+}
+
+// runs every effect for one tick and expires the ones that ran out. caller must hold the lock.
+func tickEffects(gameState *GameState, now time.Time, dt float64) {
+	for _, p := range gameState.players {
+		if len(p.Effects) == 0 {
+			continue
+		}
+		health := p.Health
+		ended := p.TickEffects(now, dt)
+		if p.Health != health {
+			gameState.broadcast(Message{
+				Type:     "health_update",
+				PlayerID: p.ID,
+				Health:   p.Health,
+			})
+			// this runs every tick so it's sampled, once a second per player is plenty
+			if tickSampler.Allow("effects:" + p.ID) {
+				logging.Sim.Debug("health changed by effects", "room", gameState.id, "player", p.ID, "health", p.Health)
+			}
+		}
+		for _, name := range ended {
+			gameState.effectEnded(p, name, "expired", now)
+		}
+	}
+}
+
+// the player's active effects for a state sync
+func effectStates(p *game.Player, now time.Time) []EffectState {
+	if len(p.Effects) == 0 {
+		return nil
+	}
+	out := make([]EffectState, 0, len(p.Effects))
+	for name, e := range p.Effects {
+		out = append(out, EffectState{
+			Name:      name,
+			Stacks:    e.Stacks,
+			Remaining: e.Remaining(now).Milliseconds(),
+			Magnitude: p.Magnitude(name),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/server"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// puts an entry in the player's event log at wherever they are now. it goes through the
+// telemetry writer so the tick loop never waits on the database. bots aren't logged, same as
+// their positions. caller must hold the lock.
+func (gs *GameState) logEvent(p *game.Player, typ models.EventType, details any, now time.Time) {
+	if p.IsBot {
+		return
+	}
+	gs.telemetry.Event(models.Event{
+		SessionID: p.SessionID,
+		PlayerID:  p.ID,
+		MatchID:   gs.matchID(),
+		Room:      gs.id,
+		Type:      typ,
+		At:        now,
+		X:         p.Position.X,
+		Y:         p.Position.Y,
+	}, details)
+}
+
+// logs a weapon or powerup being picked up, both as the player's event and in the pickups
+// table. caller must hold the lock.
+func (gs *GameState) logPickup(p *game.Player, kind, id, typ string, pos game.Position, spawnedAt, now time.Time) {
+	if p.IsBot {
+		return
+	}
+	gs.logEvent(p, models.EventPickup, models.PickupDetails{Kind: kind, Item: typ, PickupID: id}, now)
+	gs.telemetry.Pickup(models.Pickup{
+		PickupID:  id,
+		Kind:      kind,
+		Type:      typ,
+		MatchID:   gs.matchID(),
+		Room:      gs.id,
+		X:         pos.X,
+		Y:         pos.Y,
+		SpawnedAt: spawnedAt,
+		SessionID: p.SessionID,
+		PickedAt:  now,
+	})
+}
+
+// logs a powerup (or killstreak reward) that just took effect. caller must hold the lock.
+func (gs *GameState) logPowerUp(p *game.Player, name string, now time.Time) {
+	e, ok := p.Effects[name]
+	if !ok {
+		return
+	}
+	gs.logEvent(p, models.EventPowerUpUse, models.PowerUpDetails{
+		PowerUp:  name,
+		Stacks:   e.Stacks,
+		Duration: e.Remaining(now).Milliseconds(),
+	}, now)
+}
+
+// GET /admin/timeline/{session}?match=12
+//
+// everything that happened to a session, oldest first. without match it's every match
+// they've played. rebuilds things like what they were carrying when they died.
+func handleTimeline(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.PathValue("session")
+		var matchID int64
+		if m := r.URL.Query().Get("match"); m != "" {
+			id, err := strconv.ParseInt(m, 10, 64)
+			if err != nil || id < 1 {
+				server.WriteError(w, http.StatusBadRequest, fmt.Errorf("bad match id %q", m))
+				return
+			}
+			matchID = id
+		}
+		events, err := store.Timeline(sessionID, matchID)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if events == nil {
+			events = []models.Event{} // [] rather than null
+		}
+		server.WriteJSON(w, http.StatusOK, struct {
+			SessionID string         `json:"session_id"`
+			MatchID   int64          `json:"match_id,omitempty"`
+			Events    []models.Event `json:"events"`
+		}{sessionID, matchID, events})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"arena-tactics/internal/game"
+	"math"
+	"time"
+)
+
+// shots can arrive a bit early thanks to network jitter, so the fire rate is only
+// enforced down to this fraction of the real interval
+const fireRateSlack = 0.8
+
+// how often the shooter gets a heat update while the number is moving, in ticks.
+// overheating and cooling down are always sent straight away.
+const heatSyncTicks = 6
+
+// time between shots right now. with a spin up the rate ramps linearly from
+// SpinUpFireRate to FireRate over the first SpinUp of holding the trigger.
+func fireInterval(weapon string, heldFor time.Duration) time.Duration {
+	props := WeaponProperties[weapon]
+	rate := props.FireRate
+	if props.SpinUp > 0 && heldFor < props.SpinUp {
+		spun := float64(heldFor) / float64(props.SpinUp)
+		rate = props.SpinUpFireRate + (props.FireRate-props.SpinUpFireRate)*spun
+	}
+	return time.Duration(rate * float64(time.Millisecond))
+}
+
+// how far through the spin up the weapon in hand is, 0 to 1
+func spinProgress(p *game.Player, now time.Time) float64 {
+	props := WeaponProperties[p.Weapon]
+	if props.SpinUp <= 0 || !p.TriggerHeld {
+		return 0
+	}
+	return min(1, float64(now.Sub(p.TriggerSince))/float64(props.SpinUp))
+}
+
+// fires the weapon in hand once if it's ready: not mid switch, not overheated and
+// past its fire rate. returns whether a shot went out. caller must hold the lock.
+func fireWeapon(gameState *GameState, player *game.Player, rotation float64, now time.Time) bool {
+	slot := player.ActiveSlot
+	if player.IsDead || !player.CanFire(now) || player.Overheated[slot] || now.Before(player.NextShotAt) {
+		return false
+	}
+	// shooting gives away where you are
+	gameState.removeEffect(player, effectInvisibility, "attacked", now)
+
+	props := WeaponProperties[player.Weapon]
+	// different weapons create different bullet patterns
+	switch player.Weapon {
+	case "shotgun":
+		// shotgun shoots multiple pellets in a spread pattern
+		for i := -3; i <= 4; i++ {
+			gameState.spawnBullet(player, rotation+(float64(i)*5.0)*(math.Pi/180), props.Speed, props.Lifetime)
+		}
+	default:
+		// a single bullet for the pistol, machine gun and everything else
+		gameState.spawnBullet(player, rotation, props.Speed, props.Lifetime)
+	}
+
+	// a plain shoot message doesn't hold the trigger, so it never gets past the spin up
+	var heldFor time.Duration
+	if player.TriggerHeld {
+		heldFor = now.Sub(player.TriggerSince)
+	}
+	interval := fireInterval(player.Weapon, heldFor)
+	player.NextShotAt = now.Add(time.Duration(float64(interval) * fireRateSlack))
+
+	if props.HeatPerShot > 0 {
+		player.Heat[slot] = min(1, player.Heat[slot]+props.HeatPerShot)
+		if player.Heat[slot] >= 1 {
+			player.Overheated[slot] = true
+			sendHeat(player, now)
+		}
+	}
+	return true
+}
+
+// caller must hold the lock
+func (gs *GameState) spawnBullet(player *game.Player, rotation, speed, lifetime float64) {
+	bullet := &Bullet{
+		ID:       generateID(gs.rng),
+		PlayerID: player.ID,
+		Weapon:   player.Weapon,
+		Position: player.Position,
+		Rotation: rotation,
+		Speed:    speed,
+		Lifetime: lifetime,
+	}
+	gs.bullets[bullet.ID] = bullet
+	gs.statsFor(player).ShotsFired++
+}
+
+// a trigger message: down fires straight away (once for non automatic weapons),
+// held down automatic weapons keep going from the tick loop. caller must hold the lock.
+func pullTrigger(gameState *GameState, player *game.Player, held bool, rotation float64, now time.Time) {
+	player.Aim = rotation
+	wasHeld := player.TriggerHeld
+	player.TriggerHeld = held
+	if !held {
+		if wasHeld && WeaponProperties[player.Weapon].SpinUp > 0 {
+			sendHeat(player, now) // spin drops back to 0
+		}
+		return
+	}
+	if !wasHeld {
+		player.TriggerSince = now
+		fireWeapon(gameState, player, rotation, now)
+	}
+}
+
+// keeps automatic weapons firing while the trigger is down and lets every weapon cool.
+// caller must hold the lock.
+func tickWeapons(gameState *GameState, now time.Time, dt float64) {
+	for _, p := range gameState.players {
+		props := WeaponProperties[p.Weapon]
+		fired := false
+		// one shot per tick at most, so fire rates faster than the tick rate are capped by it
+		if p.TriggerHeld && props.Automatic {
+			fired = fireWeapon(gameState, p, p.Aim, now)
+		}
+
+		changed := false
+		for slot, weapon := range p.Weapons {
+			if p.Heat[slot] == 0 || (fired && slot == p.ActiveSlot) {
+				continue
+			}
+			p.Heat[slot] = max(0, p.Heat[slot]-WeaponProperties[weapon].CoolRate*dt)
+			changed = true
+			if p.Heat[slot] == 0 && p.Overheated[slot] {
+				// cooled right down, good to go again
+				p.Overheated[slot] = false
+				sendHeat(p, now)
+				changed = false
+			}
+		}
+		hasGauge := props.HeatPerShot > 0 || props.SpinUp > 0
+		if hasGauge && (fired || changed) && gameState.seq%heatSyncTicks == 0 {
+			sendHeat(p, now)
+		}
+	}
+}
+
+// tells the shooter where their weapon in hand is at
+func sendHeat(p *game.Player, now time.Time) {
+	slot := p.ActiveSlot
+	p.SendMessage(Message{
+		Type:       "weapon_heat",
+		PlayerID:   p.ID,
+		Weapon:     p.Weapon,
+		Slot:       slotNumber(slot),
+		Heat:       p.Heat[slot],
+		Overheated: p.Overheated[slot],
+		Spin:       spinProgress(p, now),
+	})
+}
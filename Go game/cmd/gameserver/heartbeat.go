@@ -0,0 +1,142 @@
+package main
+
+import (
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// heartbeat timings. the browser answers pings on its own so we dont need anything client side.
+// pongWait has to be comfortably longer than pingPeriod or we'd kick people on a single late pong.
+const (
+	pongWait           = 15 * time.Second
+	pingPeriod         = 5 * time.Second
+	writeWait          = 5 * time.Second
+	scoreboardInterval = 2 * time.Second
+)
+
+// one row of the scoreboard. ping is in milliseconds since thats what people expect to see.
+type ScoreboardEntry struct {
+	PlayerID string `json:"player_id"`
+	Username string `json:"username"`
+	Kills    int    `json:"kills"`
+	Deaths   int    `json:"deaths"`
+	Score    int    `json:"score"`
+	Ping     int64  `json:"ping"`
+}
+
+// sets up the read deadline and pong handler for a connection.
+// every pong (or any message really) pushes the deadline forward, so a half open
+// tcp connection will eventually fail ReadMessage and go down the normal disconnect path.
+func setupHeartbeat(gameState *GameState, player *game.Player, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		// the ping payload is the send time so we can work out the round trip
+		sent, err := strconv.ParseInt(appData, 10, 64)
+		if err != nil {
+			return nil
+		}
+		gameState.mutex.Lock()
+		player.RTT = time.Since(time.Unix(0, sent))
+		player.LastPong = time.Now()
+		gameState.mutex.Unlock()
+		return nil
+	})
+}
+
+// sends a ping every pingPeriod until the connection is done.
+// WriteControl is safe to call alongside the other writers so this doesnt need the write lock.
+func pingLoop(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// true if the read failed because the client stopped answering pings
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// builds the scoreboard sorted by kills then deaths. caller must hold the lock.
+func (gs *GameState) scoreboard() []ScoreboardEntry {
+	entries := make([]ScoreboardEntry, 0, len(gs.players))
+	for _, p := range gs.players {
+		entries = append(entries, ScoreboardEntry{
+			PlayerID: p.ID,
+			Username: p.Username,
+			Kills:    p.Kills,
+			Deaths:   p.Deaths,
+			Score:    p.Score,
+			Ping:     p.RTT.Milliseconds(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Kills != entries[j].Kills {
+			return entries[i].Kills > entries[j].Kills
+		}
+		return entries[i].Deaths < entries[j].Deaths
+	})
+	return entries
+}
+
+// the full scoreboard plus this match's stats, sent when a client asks for it
+func (gs *GameState) scoreboardMessage() Message {
+	return Message{
+		Type:       "scoreboard",
+		Scoreboard: gs.scoreboard(),
+		Stats:      gs.statsTable(),
+	}
+}
+
+// pushes the scoreboard (with everyones ping) out every couple of seconds
+func scoreboardLoop(ctx context.Context, gameState *GameState) {
+	ticker := time.NewTicker(scoreboardInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			gameState.mutex.Lock()
+			if len(gameState.players) > 0 {
+				gameState.broadcast(Message{
+					Type:       "scoreboard",
+					Scoreboard: gameState.scoreboard(),
+				})
+			}
+			gameState.mutex.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logs why a connection went away. timeouts are the interesting ones since
+// those are the ghost players we used to keep around forever.
+func logDisconnect(player *game.Player, err error) {
+	if isTimeout(err) {
+		logging.Net.Info("player timed out", "player", player.ID, "pong_wait", pongWait)
+		return
+	}
+	logging.Net.Info("connection closed", "player", player.ID, "err", err)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/heatmap"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/server"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// how often old positions get downsampled, and how many rows go in one transaction
+const (
+	retentionInterval = time.Hour
+	retentionChunk    = 5000
+)
+
+// folds raw positions older than retention into heatmap cells, once at startup and then every
+// hour until ctx is cancelled. every server runs it, the store makes sure two of them at once
+// don't count the same rows.
+func runRetention(ctx context.Context, store database.Store, retention time.Duration) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		downsampleOldPositions(ctx, store, time.Now().Add(-retention))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// a chunk at a time so no one transaction holds the table for long
+func downsampleOldPositions(ctx context.Context, store database.Store, before time.Time) {
+	start := time.Now()
+	total := 0
+	for ctx.Err() == nil {
+		n, err := store.DownsamplePositions(before, retentionChunk)
+		if err != nil {
+			logging.DB.Error("downsampling old positions failed", "done", total, "err", err)
+			return
+		}
+		total += n
+		if n < retentionChunk {
+			break
+		}
+	}
+	if total > 0 {
+		logging.DB.Info("downsampled old positions", "rows", total, "before", before, "took", time.Since(start))
+	}
+}
+
+// GET /admin/heatmaps/{room}?kind=death&match=12&format=png&scale=16
+//
+// kind is position (the default), kill or death. without match it's every match the room has
+// had. json by default, png draws scale pixels per cell.
+func handleHeatmap(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		room := r.PathValue("room")
+		if !validRoomName.MatchString(room) {
+			server.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid room name %q", room))
+			return
+		}
+		q := r.URL.Query()
+		kind := q.Get("kind")
+		if kind == "" {
+			kind = heatmap.Positions
+		}
+		if !heatmap.ValidKind(kind) {
+			server.WriteError(w, http.StatusBadRequest, fmt.Errorf("kind must be position, kill or death, got %q", kind))
+			return
+		}
+		var matchID int64
+		if m := q.Get("match"); m != "" {
+			id, err := strconv.ParseInt(m, 10, 64)
+			if err != nil || id < 1 {
+				server.WriteError(w, http.StatusBadRequest, fmt.Errorf("bad match id %q", m))
+				return
+			}
+			matchID = id
+		}
+		scale := 16
+		if s := q.Get("scale"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > 64 {
+				server.WriteError(w, http.StatusBadRequest, errors.New("scale must be between 1 and 64"))
+				return
+			}
+			scale = n
+		}
+
+		grid, err := store.Heatmap(room, kind, matchID)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		switch q.Get("format") {
+		case "", "json":
+			server.WriteJSON(w, http.StatusOK, grid)
+		case "png":
+			// drawn into a buffer first so a failure can still be an error response
+			var buf bytes.Buffer
+			if err := grid.WritePNG(&buf, scale); err != nil {
+				server.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(buf.Bytes())
+		default:
+			server.WriteError(w, http.StatusBadRequest, errors.New("format must be json or png"))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"math"
+	"slices"
+	"time"
+)
+
+// how close a weapon on the ground has to be for the pickup key to grab it
+const weaponPickupRange = 30.0
+
+// slots go over the wire numbered like the keys that pick them, 1 and 2
+func slotNumber(slot int) int { return slot + 1 }
+
+// a copy, messages and keyframes get encoded after the lock's let go while the slots change
+func inventoryOf(p *game.Player) []string {
+	return slices.Clone(p.Weapons[:])
+}
+
+// grabs the closest weapon in reach that the player isn't already carrying. with both
+// slots full the weapon in hand is swapped out and left where they stand.
+// caller must hold the lock.
+func pickUpWeapon(gameState *GameState, p *game.Player, now time.Time) {
+	var closest *Weapon
+	best := weaponPickupRange
+	for _, w := range gameState.weapons {
+		// no point picking up a second shotgun
+		if p.HasWeapon(w.Type) {
+			continue
+		}
+		if d := math.Hypot(w.Position.X-p.Position.X, w.Position.Y-p.Position.Y); d < best {
+			closest, best = w, d
+		}
+	}
+	if closest == nil {
+		return
+	}
+	delete(gameState.weapons, closest.ID)
+	dropped, heat := p.PickUpWeapon(closest.Type, closest.heatAt(now))
+	gameState.logPickup(p, "weapon", closest.ID, closest.Type, closest.Position, closest.SpawnTime, now)
+	gameState.broadcast(Message{
+		Type:      "weapon_pickup",
+		PlayerID:  p.ID,
+		WeaponID:  closest.ID,
+		Weapon:    closest.Type,
+		Slot:      slotNumber(p.ActiveSlot),
+		Inventory: inventoryOf(p),
+	})
+	logging.Sim.Debug("weapon picked up", "room", gameState.id, "player", p.ID, "weapon", closest.Type, "dropped", dropped)
+	if dropped != "" {
+		gameState.placeWeapon(dropped, heat, p.Position, now)
+	}
+	if p.Heat[p.ActiveSlot] > 0 {
+		sendHeat(p, now)
+	}
+}
+
+// slot is 1 based like on the wire. caller must hold the lock.
+func switchWeapon(gameState *GameState, p *game.Player, slot int, now time.Time) {
+	delay := gameState.cfg.WeaponSwitchDelay.Duration()
+	if !p.SwitchWeapon(slot-1, now, delay) {
+		return
+	}
+	gameState.broadcast(Message{
+		Type:     "weapon_switch",
+		PlayerID: p.ID,
+		Weapon:   p.Weapon,
+		Slot:     slot,
+		Cooldown: delay.Milliseconds(),
+	})
+}
+
+// drops the weapon in hand and switches to the other one. caller must hold the lock.
+func dropWeapon(gameState *GameState, p *game.Player, now time.Time) {
+	delay := gameState.cfg.WeaponSwitchDelay.Duration()
+	dropped, heat := p.DropWeapon(now, delay)
+	if dropped == "" {
+		// can't go empty handed
+		return
+	}
+	w := gameState.placeWeapon(dropped, heat, p.Position, now)
+	gameState.broadcast(Message{
+		Type:      "weapon_drop",
+		PlayerID:  p.ID,
+		WeaponID:  w.ID,
+		Weapon:    dropped,
+		Slot:      slotNumber(p.ActiveSlot),
+		Inventory: inventoryOf(p),
+	})
+	gameState.broadcast(Message{
+		Type:     "weapon_switch",
+		PlayerID: p.ID,
+		Weapon:   p.Weapon,
+		Slot:     slotNumber(p.ActiveSlot),
+		Cooldown: delay.Milliseconds(),
+	})
+}
+
+// how hot a weapon on the ground is by now, it cools at the same rate as it would in hand
+func (w *Weapon) heatAt(now time.Time) game.WeaponHeat {
+	heat := w.Heat
+	if heat.Heat > 0 {
+		heat.Heat = max(0, heat.Heat-WeaponProperties[w.Type].CoolRate*now.Sub(w.SpawnTime).Seconds())
+	}
+	if heat.Heat == 0 {
+		heat.Overheated = false
+	}
+	return heat
+}
+
+// puts a dropped weapon on the ground like a fresh spawn, still as hot as it was.
+// caller must hold the lock.
+func (gs *GameState) placeWeapon(weaponType string, heat game.WeaponHeat, pos game.Position, now time.Time) *Weapon {
+	w := &Weapon{
+		ID:        generateID(gs.rng),
+		Type:      weaponType,
+		Position:  pos,
+		SpawnTime: now,
+		Heat:      heat,
+	}
+	gs.weapons[w.ID] = w
+	gs.broadcast(Message{
+		Type:     "weapon_spawn",
+		WeaponID: w.ID,
+		Position: w.Position,
+		Weapon:   w.Type,
+	})
+	return w
+}
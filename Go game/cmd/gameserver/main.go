@@ -13,20 +13,30 @@
 package main
 
 import (
+	"arena-tactics/internal/config"
 	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
 	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/metrics"
+	"arena-tactics/internal/profile"
+	"arena-tactics/internal/replay"
+	"arena-tactics/internal/server"
+	"arena-tactics/internal/telemetry"
+	"arena-tactics/internal/wordfilter"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"github.com/gorilla/websocket"
-	"github.com/joho/godotenv"
-	"log"
-	"fmt"
 	"math"
 	"math/rand"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
+	"os/signal"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -39,9 +49,8 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-// 60 updates per second - same as most modern games.
-// smooth movement without killing the cpu. maybe make it based on hardware instead of a magic number
-const tickRate = time.Second / 60
+// debug lines inside the tick loop go through this so sim=debug stays readable
+var tickSampler = logging.NewSampler(time.Second)
 
 // bullet type represents a projectile in the game.
 // these are made super simple - just position, direction, and lifetime.
@@ -49,6 +58,7 @@ const tickRate = time.Second / 60
 type Bullet struct {
 	ID       string
 	PlayerID string // need track who fired it for damage attribution
+	Weapon   string // what fired it, so swapping guns mid flight doesn't change the damage
 	Position game.Position
 	Rotation float64 // direction in radians easier for trig calculations
 	Speed    float64
@@ -60,7 +70,8 @@ type Weapon struct {
 	ID        string
 	Type      string // "pistol", "shotgun", etc
 	Position  game.Position
-	SpawnTime time.Time // just used for despawning old weapons
+	SpawnTime time.Time       // just used for despawning old weapons
+	Heat      game.WeaponHeat // as it was when it was dropped, it cools on the ground from SpawnTime
 }
 
 // power add some strategic depth to the gameplay.
@@ -74,11 +85,19 @@ type PowerUp struct {
 
 // The damage/speed/fire rate balance is crucial for gameplay (some stuff needs to be reworked though)
 // Shotgun does more total damage but has spread, machine gun is rapid but weak, etc.
+// automatic weapons keep firing while the trigger is held, the rest fire once per press.
+// SpinUp ramps the fire rate from SpinUpFireRate to FireRate while the trigger stays down,
+// and every shot adds HeatPerShot until the weapon overheats at 1 and has to cool off completely.
 var WeaponProperties = map[string]struct {
-	FireRate float64
-	Damage   int
-	Speed    float64
-	Lifetime float64
+	FireRate       float64
+	Damage         int
+	Speed          float64
+	Lifetime       float64
+	Automatic      bool
+	SpinUp         time.Duration
+	SpinUpFireRate float64 // milliseconds between shots when the spin up starts
+	HeatPerShot    float64
+	CoolRate       float64 // heat lost per second
 }{
 	"pistol": {
 		FireRate: 500, // milliseconds between shots
@@ -93,10 +112,15 @@ var WeaponProperties = map[string]struct {
 		Lifetime: 0.5, // short range is key for shotgun balance
 	},
 	"machine_gun": {
-		FireRate: 100, // super fast firing 10 shots per second!
-		Damage:   7,   // low damage per bullet but high dps
-		Speed:    700, // fastest projectiles
-		Lifetime: 0.725,
+		FireRate:       100, // super fast firing 10 shots per second!
+		Damage:         7,   // low damage per bullet but high dps
+		Speed:          700, // fastest projectiles
+		Lifetime:       0.725,
+		Automatic:      true,
+		SpinUp:         1200 * time.Millisecond, // needs a wind up to get up to speed
+		SpinUpFireRate: 350,
+		HeatPerShot:    0.03, // about 3 seconds of full auto
+		CoolRate:       0.35,
 	},
 	"rocket_launcher": {
 		FireRate: 1000, // slow firing this thing is powerful (still need to add it)
@@ -105,10 +129,13 @@ var WeaponProperties = map[string]struct {
 		Lifetime: 1.5,  // longer range
 	},
 	"laser": {
-		FireRate: 20,   // super fast firing 10 shots per second!
-		Damage:   2,    // low damage per bullet but high dps
-		Speed:    2500, // fastest projectiles
-		Lifetime: 2.0,
+		FireRate:    20,   // super fast firing 10 shots per second!
+		Damage:      2,    // low damage per bullet but high dps
+		Speed:       2500, // fastest projectiles
+		Lifetime:    2.0,
+		Automatic:   true,
+		HeatPerShot: 0.008,
+		CoolRate:    0.3,
 	},
 }
 
@@ -117,93 +144,214 @@ var WeaponProperties = map[string]struct {
 // dozens of different message structs.
 
 type Message struct {
-	Type              string         `json:"type"`
-	Position          game.Position  `json:"position,omitempty"`
-	PlayerID          string         `json:"player_id,omitempty"`
-	Color             int            `json:"color,omitempty"`
-	SessionID         string         `json:"session_id,omitempty"`
-	Weapon            string         `json:"weapon,omitempty"`
-	WeaponID          string         `json:"weapon_id,omitempty"`
-	Rotation          float64        `json:"rotation,omitempty"`
-	Health            int            `json:"health,omitempty"`
-	IsDead            bool           `json:"is_dead,omitempty"`
-	DeathTime         int64          `json:"death_time,omitempty"`
-	PowerUp           string         `json:"powerup,omitempty"`
-	PowerUpID         string         `json:"powerup_id,omitempty"`
-	CursorPos         *game.Position `json:"cursorPos,omitempty"`
-	TeleportAvailable bool           `json:"teleportAvailable,omitempty"`
-	ForceFieldActive  bool           `json:"forceFieldActive,omitempty"`
-	HealthRegenActive bool           `json:"healthRegenActive,omitempty"`
-	LobbyUpdate       []string       `json:"lobby_update,omitempty"`
-	MatchStarted      bool           `json:"match_started,omitempty"`
+	Type              string            `json:"type"`
+	Position          game.Position     `json:"position,omitempty"`
+	PlayerID          string            `json:"player_id,omitempty"`
+	Color             int               `json:"color,omitempty"`
+	SessionID         string            `json:"session_id,omitempty"`
+	Weapon            string            `json:"weapon,omitempty"`
+	WeaponID          string            `json:"weapon_id,omitempty"`
+	BulletID          string            `json:"bullet_id,omitempty"`
+	Rotation          float64           `json:"rotation,omitempty"`
+	Health            int               `json:"health,omitempty"`
+	IsDead            bool              `json:"is_dead,omitempty"`
+	DeathTime         int64             `json:"death_time,omitempty"`
+	PowerUp           string            `json:"powerup,omitempty"`
+	PowerUpID         string            `json:"powerup_id,omitempty"`
+	Reason            string            `json:"reason,omitempty"`
+	CursorPos         *game.Position    `json:"cursorPos,omitempty"`
+	TeleportAvailable bool              `json:"teleportAvailable,omitempty"`
+	ForceFieldActive  bool              `json:"forceFieldActive,omitempty"`
+	HealthRegenActive bool              `json:"healthRegenActive,omitempty"`
+	LobbyUpdate       []string          `json:"lobby_update,omitempty"`
+	MatchStarted      bool              `json:"match_started,omitempty"`
+	Seq               uint64            `json:"seq,omitempty"`      // tick the message was sent on
+	LastSeq           uint64            `json:"last_seq,omitempty"` // highest seq the client saw before reconnecting
+	State             *StateSync        `json:"state,omitempty"`
+	Scoreboard        []ScoreboardEntry `json:"scoreboard,omitempty"`
+	Spectate          bool              `json:"spectate,omitempty"`  // session_init: watch the room instead of playing
+	Target            string            `json:"target,omitempty"`    // player a spectator (or a dead player) is following, or a whisper's recipient
+	KillerID          string            `json:"killer_id,omitempty"` // who got the kill on a player_death
+	AttackerID        string            `json:"attacker_id,omitempty"`
+	Damage            int               `json:"damage,omitempty"`   // health actually taken off by a hit
+	Absorbed          int               `json:"absorbed,omitempty"` // part of a hit the force field soaked up
+	Assists           []string          `json:"assists,omitempty"`
+	Streak            int               `json:"streak,omitempty"` // killer's kills since their last death
+	Stats             []game.MatchStats `json:"stats,omitempty"`
+	Reward            string            `json:"reward,omitempty"`     // killstreak reward granted or expiring
+	MultiKill         int               `json:"multi_kill,omitempty"` // 2 for a double kill, 3 for a triple...
+	Duration          int64             `json:"duration,omitempty"`   // milliseconds a timed effect lasts
+	Effect            string            `json:"effect,omitempty"`
+	Stacks            int               `json:"stacks,omitempty"`
+	Magnitude         float64           `json:"magnitude,omitempty"` // effect multiplier (speed, damage)
+	Cooldown          int64             `json:"cooldown,omitempty"`  // milliseconds until it can be used again
+	Slot              int               `json:"slot,omitempty"`      // inventory slot, 1 or 2
+	Inventory         []string          `json:"inventory,omitempty"` // both slots, "" when empty
+	Held              bool              `json:"held,omitempty"`      // trigger: down or released
+	Heat              float64           `json:"heat,omitempty"`      // weapon_heat: 0 to 1
+	Overheated        bool              `json:"overheated,omitempty"`
+	Spin              float64           `json:"spin,omitempty"` // weapon_heat: spin up progress, 0 to 1
+	Username          string            `json:"username,omitempty"`
+	Sprite            string            `json:"sprite,omitempty"`
+	Profile           *ProfileUpdate    `json:"profile,omitempty"` // profile_update from a client
+	Text              string            `json:"text,omitempty"`    // chat
+	Scope             string            `json:"scope,omitempty"`   // chat: room, team or whisper
+	Team              string            `json:"team,omitempty"`    // profile_update: their team, empty in free for all
+	QuickChat         string            `json:"quick_chat,omitempty"`
+	SentAt            int64             `json:"sent_at,omitempty"` // chat: unix milliseconds
 }
 
 // Essentially the core of the game its the state container that holds everything.
 // Using maps for o(1) lookup by id, which matters when we've got dozens of
 // entities and need to check collisions every frame.
 type GameState struct {
+	id          string            // room name
+	cfg         config.Game       // this room's settings (tick rate, spawn limits, timers...), admins can change it live so read it under the lock
+	store       database.Store    // players, positions, matches and bans, shared by every room
+	telemetry   *telemetry.Writer // movement log, written in the background so the game never waits on the db
+	match       *models.Match     // the current match's row, nil between matches
 	players     map[string]*game.Player
+	matchStats  map[string]*game.MatchStats // this match only, keyed by player id
+	bots        map[string]*botBrain        // the players in players that are bots, keyed by player id
+	spectators  map[string]*Spectator
 	bullets     map[string]*Bullet
 	weapons     map[string]*Weapon
 	powerups    map[string]*PowerUp
 	mutex       sync.RWMutex // super crucial mutex since we're accessing state from multiple goroutines
 	matchActive bool
+	seed        int64            // what rng was seeded with, goes into the replay header
+	rng         *rand.Rand       // every random choice the simulation makes comes from here, only use it under the lock
+	seq         uint64           // bumped every tick, stamped on broadcasts so clients can tell how far behind they are
+	replayDir   string           // where matches get recorded, empty to not record
+	recorder    *replay.Recorder // the current match's replay, nil when not recording
+	recordings  sync.WaitGroup   // replays still being written out in the background
+	// replay files are split once they get this big or this long
+	replayMaxSize     int64
+	replayMaxDuration time.Duration
+	recordingPart     int                // which of the match's replay files is being written
+	recordingIdle     bool               // the room was empty on the last tick, so nothing was recorded
+	chat              []chatEntry        // the last chat_history messages, oldest first
+	chatFilter        *wordfilter.Filter // built from cfg, rebuilt when the settings change
+	permanent         bool               // the default room or one from the config, never closed
+	emptySince        time.Time          // when the last person left, zero while someone's here
+	closed            bool               // closed for being empty, whoever still gets here has to join again
+	stop              context.CancelFunc // stops the room's loops
 }
 
 // broadcasts a message to all connected players.
 // using this pattern a lot it's cleaner than repeating the loop everywhere.
+// the message is encoded once and queued on every connection so a slow client
+// can't hold up the tick (we are usually holding the game mutex in here).
 func (gs *GameState) broadcast(message Message) {
+	message.Seq = gs.seq
+	data, err := json.Marshal(message)
+	if err != nil {
+		logging.Net.Error("encoding broadcast failed", "room", gs.id, "type", message.Type, "err", err)
+		return
+	}
+	gs.recorder.Message(data)
+	key := coalesceKey(message)
+	sent := metrics.MessagesOut.WithLabelValues(message.Type)
 	for _, p := range gs.players {
 		// sends message to all connected players
 		if p.Conn != nil {
-			p.WriteMu.Lock()
-			p.Conn.WriteJSON(message)
-			p.WriteMu.Unlock()
+			sent.Inc()
+			if err := p.Conn.Send(data, key); err != nil {
+				logging.Net.Warn("player fell too far behind, dropping connection", "room", gs.id, "player", p.ID)
+			}
 		}
 	}
+	// spectators see everything the players see
+	for _, s := range gs.spectators {
+		sent.Inc()
+		if err := s.Conn.Send(data, key); err != nil {
+			logging.Net.Warn("spectator fell too far behind, dropping connection", "room", gs.id, "spectator", s.ID)
+		}
+	}
+}
+
+// state updates where only the newest one matters get a key so the send queue
+// can replace a stale one instead of queueing both. events (deaths, pickups...) return "".
+func coalesceKey(message Message) string {
+	switch message.Type {
+	case "position_update", "health_update":
+		return message.Type + ":" + message.PlayerID
+	case "bullet_update":
+		return message.Type + ":" + message.BulletID
+	case "scoreboard":
+		return message.Type
+	}
+	return ""
 }
 
 // creates a new weapon at a random position.
 // might seem simple but this little function prevents a lot of code duplication.
-func spawnWeapon() *Weapon {
+func spawnWeapon(rng *rand.Rand) *Weapon {
 	weapons := []string{"pistol", "shotgun", "machine_gun"}
 	return &Weapon{
-		ID:   generateID(),
-		Type: weapons[rand.Intn(len(weapons))], // random weapon type for variety
+		ID:   generateID(rng),
+		Type: weapons[rng.Intn(len(weapons))], // random weapon type for variety
 		Position: game.Position{
-			X: rand.Float64() * 1000, // and random position within game bounds
-			Y: rand.Float64() * 600,
+			X: rng.Float64() * 1000, // and random position within game bounds
+			Y: rng.Float64() * 600,
 		},
 		SpawnTime: time.Now(),
 	}
 }
 
+// every kind of power up the tick loop knows how to apply
+var powerUpTypes = []string{effectTeleport, effectForceField, effectRegen, effectSpeedBoost, effectDamageAmp, effectInvisibility}
+
 // creates a power up at a random position.
 // they should be rarer than weapons and add strategic depth to gameplay.
-func spawnPowerUp() *PowerUp {
-	types := []string{"teleportation", "force_field", "health_regen"}
+func spawnPowerUp(rng *rand.Rand) *PowerUp {
 	powerup := &PowerUp{
-		ID:        generateID(),
-		Type:      types[rand.Intn(len(types))],
-		Position:  game.Position{X: rand.Float64() * 1000, Y: rand.Float64() * 600},
+		ID:        generateID(rng),
+		Type:      powerUpTypes[rng.Intn(len(powerUpTypes))],
+		Position:  game.Position{X: rng.Float64() * 1000, Y: rng.Float64() * 600},
 		SpawnTime: time.Now(),
 	}
-	log.Printf("Spawning power-up: %+v", powerup)
+	logging.Spawn.Debug("powerup created", "id", powerup.ID, "type", powerup.Type, "position", powerup.Position)
 	return powerup
 }
 
 // factory function for creating a fresh game state.
 // they make the initialization intent very clear
 // and ensure i don't forget to initialize any maps.
-func newGameState() *GameState {
+func newGameState(id string, cfg config.Game, store database.Store) *GameState {
+	seed := newSeed(cfg)
 	return &GameState{
-		players:  make(map[string]*game.Player),
-		weapons:  make(map[string]*Weapon),
-		bullets:  make(map[string]*Bullet),
-		powerups: make(map[string]*PowerUp),
+		id:         id,
+		cfg:        cfg,
+		store:      store,
+		seed:       seed,
+		rng:        rand.New(rand.NewSource(seed)),
+		players:    make(map[string]*game.Player),
+		spectators: make(map[string]*Spectator),
+		matchStats: make(map[string]*game.MatchStats),
+		bots:       make(map[string]*botBrain),
+		weapons:    make(map[string]*Weapon),
+		bullets:    make(map[string]*Bullet),
+		powerups:   make(map[string]*PowerUp),
+		chatFilter: newChatFilter(cfg),
 	}
 }
 
+// the configured seed, or a fresh one when it's left at 0
+func newSeed(cfg config.Game) int64 {
+	if cfg.Seed != 0 {
+		return cfg.Seed
+	}
+	return time.Now().UnixNano()
+}
+
+// copy of the room's settings for loops that read them without holding the lock
+func (gs *GameState) settings() config.Game {
+	gs.mutex.RLock()
+	defer gs.mutex.RUnlock()
+	return gs.cfg
+}
+
 // many bugs were caused by nan positions...
 func isValidPosition(pos game.Position) bool {
 	return !math.IsNaN(pos.X) && !math.IsNaN(pos.Y) &&
@@ -228,14 +376,12 @@ func handleWebSocket(gameState *GameState, w http.ResponseWriter, r *http.Reques
 	// tried detecting incognito mode but its unreliable:
 	// isIncognito := strings.Contains(r.Header.Get("User-Agent"), "Incognito")
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		logging.Net.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+		metrics.UpgradeFailures.Inc()
 		return
 	}
 	defer conn.Close()
 
-	ticker := time.NewTicker(tickRate)
-	defer ticker.Stop()
-
 	// Create a done channel for cleanup
 	done := make(chan struct{})
 	defer close(done)
@@ -244,21 +390,65 @@ func handleWebSocket(gameState *GameState, w http.ResponseWriter, r *http.Reques
 
 	// Run weapon spawning in a separate goroutine
 
+	// dont wait forever on a client that connects and never says anything
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+
 	// Read initial session message
 	_, messageBytes, err := conn.ReadMessage()
 	if err != nil {
-		log.Printf("Error reading initial message: %v", err)
+		logging.Net.Info("no session message", "room", gameState.id, "remote", r.RemoteAddr, "err", err)
 		return
 	}
 
 	var initMessage Message
 	if err := json.Unmarshal(messageBytes, &initMessage); err != nil {
-		log.Printf("Error parsing initial message: %v", err)
+		logging.Net.Warn("bad session message", "room", gameState.id, "remote", r.RemoteAddr, "err", err)
+		return
+	}
+
+	// from here on every write goes through the connection's send queue
+	client := game.NewConn(conn)
+	defer client.Close()
+
+	// banned sessions and ips get told why and turned away before they touch the room
+	ip := server.ClientIP(r)
+	ban, err := gameState.store.FindBan(initMessage.SessionID, ip)
+	if err != nil {
+		// let them in rather than lock everyone out while the db is struggling
+		logging.DB.Error("ban lookup failed", "remote", ip, "err", err)
+	} else if ban != nil {
+		logging.Net.Info("banned client turned away", "room", gameState.id, "session", initMessage.SessionID, "remote", ip, "ban", ban.ID)
+		client.SendJSON(Message{Type: "banned", Reason: ban.Reason})
+		client.Drain(websocket.ClosePolicyViolation, "banned", writeWait)
+		return
+	}
+	// muted players still play, they just can't chat
+	mute, muteErr := gameState.store.FindMute(initMessage.SessionID)
+	if muteErr != nil {
+		logging.DB.Error("mute lookup failed", "session", initMessage.SessionID, "err", muteErr)
+	}
+
+	// spectators never become players, they get their own much simpler loop
+	if initMessage.Spectate {
+		handleSpectator(gameState, conn, client, initMessage.Target, done)
 		return
 	}
+	// chosen name, color and sprite, or the defaults (the color is the same on every join).
+	// looked up before taking the lock like the ban and mute, only new players end up using it
+	prof, err := loadProfile(gameState.store, initMessage.SessionID)
+	if err != nil {
+		logging.DB.Error("loading profile failed", "room", gameState.id, "session", initMessage.SessionID, "err", err)
+		prof = models.Profile{Color: profile.DefaultColor(initMessage.SessionID), Sprite: profile.DefaultSprite}
+	}
 
 	// (thread safety) locking before touching shared state.
 	gameState.mutex.Lock()
+	if gameState.closed {
+		gameState.mutex.Unlock()
+		client.SendJSON(Message{Type: "room_closed", Reason: "the room was closed, join again"})
+		client.Drain(websocket.CloseTryAgainLater, "room closed", writeWait)
+		return
+	}
 	var player *game.Player
 
 	// check if this is a returning player by looking for their session id.
@@ -266,189 +456,104 @@ func handleWebSocket(gameState *GameState, w http.ResponseWriter, r *http.Reques
 	// there character is technically ephemeral in the sense they are removed from the database on disconnect.
 	// but for continuity if they refresh the page we want them to have the same character as before (color, position, weapon, etc).
 	for _, p := range gameState.players {
-		if p.SessionID == initMessage.SessionID {
-			// found existing player thus handle reconnection
-			// close any existing connection can't have two connections for one player!
-			if p.Conn != nil {
-				p.Conn.Close()
-			}
-
-			// update connection
-			p.Conn = conn
+		if p.SessionID == initMessage.SessionID && !p.IsBot {
 			player = p
-			// Sanity check position
-			if !isValidPosition(p.Position) {
-				p.Position = game.Position{
-					X: 500,
-					Y: 300,
-				}
-			}
-			// handle reconnection for dead players specially
-			if p.IsDead {
-				if !isValidPosition(p.Position) {
-					p.Position = game.Position{
-						X: 500,
-						Y: 300,
-					}
-				}
-				if math.IsNaN(p.Position.X) || math.IsNaN(p.Position.Y) {
-					p.Position = game.Position{X: 500, Y: 300}
-				}
-
-				// let the player know they're still dead
-				p.WriteMu.Lock()
-				conn.WriteJSON(Message{
-					Type:      "player_death",
-					PlayerID:  p.ID,
-					Position:  p.Position,
-					DeathTime: p.DeathTime.Unix(),
-				})
-				p.WriteMu.Unlock()
-
-				// manages the death timer for reconnects.
-				// needs to calculate the remaining time on the death timer.
-				if p.DeathTimer != nil {
-					p.DeathTimer.Stop()
-					p.DeathTimer = nil
-				}
-
-				// calculate time since death
-				timeSinceDeath := time.Since(p.DeathTime)
-				if timeSinceDeath < 3*time.Second {
-
-					// resume the timer with remaining time
-					remaining := 3*time.Second - timeSinceDeath
-					p.DeathTimer = time.AfterFunc(remaining, func() {
-						respawnPlayer(gameState, p)
-					})
-				} else {
-					// respawn immediately if timer expired
-					respawnPlayer(gameState, p)
-				}
-			}
-
-			// sends the current state to reconnected player
-			p.WriteMu.Lock()
-			conn.WriteJSON(Message{
-				Type:              "player_init",
-				PlayerID:          p.ID,
-				Color:             p.Color,
-				Position:          p.Position,
-				Health:            p.Health,
-				Weapon:            p.Weapon,
-				IsDead:            p.IsDead,
-				DeathTime:         p.DeathTime.Unix(),
-				TeleportAvailable: p.TeleportAvailable,
-				ForceFieldActive:  p.ForceFieldActive,
-				HealthRegenActive: p.HealthRegenActive,
-			})
-			p.WriteMu.Unlock()
-			log.Printf("Player %s reconnected with session %s at position %v",
-				p.ID, p.SessionID, p.Position)
-			// the code is duplicated but its ok because the
-			// logic is slightly different for reconnection vs. initial setup
-			if p.IsDead {
-				timeSinceDeath := time.Since(p.DeathTime)
-				if timeSinceDeath >= 3*time.Second {
-					// force respawn if timer expired during reload
-					respawnPlayer(gameState, p)
-				} else {
-					// send current state before creating new timer
-					p.SendMessage(Message{
-						Type:      "player_death",
-						PlayerID:  p.ID,
-						Position:  p.Position,
-						DeathTime: p.DeathTime.Unix(),
-					})
-
-					// create new timer with remaining time
-					remaining := 3*time.Second - timeSinceDeath
-					p.DeathTimer = time.AfterFunc(remaining, func() {
-						respawnPlayer(gameState, p)
-					})
-				}
-			}
 			break
-
 		}
-
 	}
-	// no existing player found (so create a new one)
-	// this is where first time players enter the game.
-	if player == nil {
+	resumed := player != nil
+	if resumed {
+		player.IP = ip
+		// a failed lookup keeps whatever mute they had before they dropped
+		if muteErr == nil {
+			applyMute(player, mute)
+		}
+		gameState.resumeSession(player, client, initMessage.LastSeq)
+	} else {
+		// no existing player found (so create a new one)
+		// this is where first time players enter the game.
+		// returning players (above) keep their slot, new ones need a free one
+		// bots give up their seat to people
+		if len(gameState.players) >= gameState.cfg.MaxPlayers {
+			gameState.benchBot()
+		}
+		if len(gameState.players) >= gameState.cfg.MaxPlayers {
+			gameState.mutex.Unlock()
+			logging.Net.Info("room full", "room", gameState.id, "remote", ip, "max_players", gameState.cfg.MaxPlayers)
+			client.SendJSON(Message{Type: "room_full", Reason: "room is full, try again later or spectate"})
+			client.Drain(websocket.CloseTryAgainLater, "room full", writeWait)
+			return
+		}
 		// get or create player from database this lets us persist stats*
-		dbPlayer, err := database.GetOrCreatePlayer(initMessage.SessionID)
+		record, err := gameState.store.GetOrCreatePlayer(initMessage.SessionID)
 		if err != nil {
-			log.Printf("Error getting or creating player: %v", err)
+			logging.DB.Error("get or create player failed", "room", gameState.id, "err", err)
 			gameState.mutex.Unlock()
 			return
 		}
+		dbPlayer := &game.Player{
+			ID:        record.ID,
+			SessionID: record.SessionID,
+			Username:  record.Username,
+			Kills:     record.Kills,
+			Deaths:    record.Deaths,
+			Score:     record.Score,
+		}
 		// in game fields that arent persisted
-		dbPlayer.Conn = conn
-		dbPlayer.Health = 100
-		dbPlayer.Weapon = "pistol" // everyone starts with the basic pistol
+		dbPlayer.Conn = client
+		dbPlayer.IP = ip
+		dbPlayer.Health = gameState.cfg.MaxHealth
+		dbPlayer.ResetInventory("pistol") // everyone starts with the basic pistol
 		dbPlayer.IsDead = false
 		dbPlayer.Position = game.Position{X: 500, Y: 300} // center of the map
-		dbPlayer.Color = rand.Intn(0xFFFFFF)              // random color to distinguish players
-		dbPlayer.WriteMu = sync.Mutex{}
+		applyMute(dbPlayer, mute)
+		gameState.applyProfile(dbPlayer, prof)
+		dbPlayer.Team = gameState.smallestTeam() // picked before they're counted
 
 		// adds the player to our game state
 		gameState.players[dbPlayer.ID] = dbPlayer
 		player = dbPlayer
 		// Get last known position if it exists
-		lastPos, err := database.GetLastKnownPosition(initMessage.SessionID)
+		lastPos, err := gameState.store.GetLastKnownPosition(initMessage.SessionID)
 		if err != nil {
-			log.Printf("Error retrieving last known position: %v", err)
+			logging.DB.Error("loading last known position failed", "player", player.ID, "err", err)
 		}
 		if lastPos != nil {
 			player.Position = *lastPos
 		} else {
 			player.Position = game.Position{X: 500, Y: 300} // Default spawn
 		}
+		gameState.logEvent(player, models.EventSpawn, models.SpawnDetails{
+			Restored: lastPos != nil,
+			Health:   player.Health,
+			Weapon:   player.Weapon,
+		}, time.Now())
+		// everyone already here learns the newcomer's name and looks, they get ours in the state_sync
+		gameState.broadcastProfile(player)
 	}
 
-	// Send initialization message.
-	player.WriteMu.Lock()
-	initResp := Message{
-		Type:      "player_init",
-		PlayerID:  player.ID,
-		Color:     player.Color,
-		Position:  player.Position,
-		Health:    player.Health,
-		Weapon:    player.Weapon,
-		IsDead:    player.IsDead,
-		DeathTime: player.DeathTime.Unix(),
-	}
-	err = conn.WriteJSON(initResp)
-	player.WriteMu.Unlock()
-	// if gameState.matchActive {
-	// 	player.SendMessage(Message{Type: "match_started", MatchStarted: true})
-	// }
-
+	// one authoritative snapshot of the whole match, same for new and returning players,
+	// with the chat they missed
+	state := gameState.snapshot(resumed)
+	state.Chat = gameState.chatHistory(player)
+	err = player.SendMessage(Message{
+		Type:     "state_sync",
+		PlayerID: player.ID,
+		Seq:      gameState.seq,
+		State:    state,
+	})
 	if err != nil {
-		log.Printf("Error sending initial state: %v", err)
+		logging.Net.Warn("sending state_sync failed", "room", gameState.id, "player", player.ID, "err", err)
+		player.Conn = nil
+		gameState.logEvent(player, models.EventDisconnect, models.DisconnectDetails{Reason: "closed"}, time.Now())
+		gameState.scheduleRemoval(player)
 		gameState.mutex.Unlock()
 		return
 	}
 
-	// send all existing weapons to the player they need to see what is already on the map
-	for id, weapon := range gameState.weapons {
-		player.SendMessage(Message{
-			Type:     "weapon_spawn",
-			WeaponID: id,
-			Position: weapon.Position,
-			Weapon:   weapon.Type,
-		})
-	}
-	// and same for powerups
-	for id, powerup := range gameState.powerups {
-		player.SendMessage(Message{
-			Type:      "powerup_spawn",
-			PowerUpID: id,
-			Position:  powerup.Position,
-			PowerUp:   powerup.Type,
-		})
-	}
+	// start the heartbeat so dead connections get noticed
+	setupHeartbeat(gameState, player, conn)
+	go pingLoop(conn, done)
 
 	//set up done release the lock
 	gameState.mutex.Unlock()
@@ -457,171 +562,188 @@ func handleWebSocket(gameState *GameState, w http.ResponseWriter, r *http.Reques
 	for {
 		_, messageBytes, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Connection error for player %s: %v", player.ID, err)
+			logDisconnect(player, err)
 
 			gameState.mutex.Lock()
-			if player.Conn == conn {
+			if player.Conn == client {
 				player.Conn = nil
-				// wait 5 seconds before actually removing the player
+				reason := "closed"
+				if isTimeout(err) {
+					reason = "timeout"
+				}
+				gameState.logEvent(player, models.EventDisconnect, models.DisconnectDetails{Reason: reason}, time.Now())
+				// wait out the grace window before actually removing the player
 				// this gives them a chance to reconnect without losing their character
-				go gameState.removePlayer(player.ID)
+				gameState.scheduleRemoval(player)
 			}
 			gameState.mutex.Unlock()
 			return
 		}
 
+		// any message proves the client is still there
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
 		var message Message
 		if err := json.Unmarshal(messageBytes, &message); err != nil {
 			continue
 		}
+		metrics.MessagesIn.WithLabelValues(inboundLabel(message.Type)).Inc()
 		gameState.mutex.Lock()
-		// Process the different message types
-		switch message.Type {
-		case "position":
-			// Only log if player is alive and position is valid
-			if !player.IsDead && isValidPosition(message.Position) {
-				player.PendingPosition = &message.Position
-				player.LastKnownPosition = message.Position
-
-				// Log movement to DB
-				go func(sessionID, playerID string, pos game.Position) {
-					if err := database.InsertPlayerPosition(sessionID, playerID, pos); err != nil {
-						log.Printf("Error inserting player position: %v", err)
-					}
-					if err := database.UpdateLastKnownPosition(sessionID, playerID, pos); err != nil {
-						log.Printf("Error updating last known position: %v", err)
-					}
-				}(player.SessionID, player.ID, message.Position)
-			}
+		handleInput(gameState, player, message, time.Now())
+		gameState.mutex.Unlock()
+
+	}
+}
 
-		case "shoot":
-			// dead players can't shoot
-			if player.IsDead {
+// acts on one message from a player. humans come through here from their websocket
+// and bots from the tick loop, so both play by exactly the same rules.
+// caller must hold the lock.
+func handleInput(gameState *GameState, player *game.Player, message Message, now time.Time) {
+	// Process the different message types
+	switch message.Type {
+	case "position":
+		// Only log if player is alive and position is valid
+		if !player.IsDead && isValidPosition(message.Position) {
+			player.PendingPosition = &message.Position
+			player.LastKnownPosition = message.Position
+			gameState.recordInput(player, message)
+			if player.IsBot {
 				break
 			}
-			props := WeaponProperties[player.Weapon]
-			// different weapons create different bullet patterns
-			switch player.Weapon {
-			case "shotgun":
-				// shotgun shoots multiple pellets in a spread pattern
-				for i := -3; i <= 4; i++ {
-					bullet := &Bullet{
-						ID:       generateID(),
-						PlayerID: player.ID,
-						Position: player.Position,
-						Rotation: message.Rotation + (float64(i)*5.0)*(math.Pi/180),
-						Speed:    props.Speed,
-						Lifetime: props.Lifetime,
-					}
-					gameState.bullets[bullet.ID] = bullet
-				}
-			case "machine_gun":
-				// machine gun just shoots one bullet but very rapidly
-				bullet := &Bullet{
-					ID:       generateID(),
-					PlayerID: player.ID,
-					Position: player.Position,
-					Rotation: message.Rotation,
-					Speed:    props.Speed,
-					Lifetime: props.Lifetime,
-				}
-				gameState.bullets[bullet.ID] = bullet
-			default:
-				// default single bullet for pistol and other weapons
-				bullet := &Bullet{
-					ID:       generateID(),
+			// movement log, queued for the telemetry writer (dropped if it's backed up)
+			gameState.telemetry.Position(models.Position{
+				SessionID: player.SessionID,
+				PlayerID:  player.ID,
+				MatchID:   gameState.matchID(),
+				X:         message.Position.X,
+				Y:         message.Position.Y,
+				At:        now,
+			})
+		}
+
+	case "shoot":
+		// older clients send one of these per bullet, same rules as the trigger
+		if fireWeapon(gameState, player, message.Rotation, now) {
+			gameState.recordInput(player, message)
+		}
+	case "trigger":
+		// held down and released. while it's down the tick loop fires automatic weapons,
+		// the client sends it again with held set whenever the aim moves
+		if !player.IsDead {
+			gameState.recordInput(player, message)
+			pullTrigger(gameState, player, message.Held, message.Rotation, now)
+		}
+	case "pickup_weapon":
+		if !player.IsDead {
+			gameState.recordInput(player, message)
+			pickUpWeapon(gameState, player, now)
+		}
+	case "switch_weapon":
+		if !player.IsDead {
+			gameState.recordInput(player, message)
+			switchWeapon(gameState, player, message.Slot, now)
+		}
+	case "drop_weapon":
+		if !player.IsDead {
+			gameState.recordInput(player, message)
+			dropWeapon(gameState, player, now)
+		}
+	case "scoreboard_request":
+		player.SendMessage(gameState.scoreboardMessage())
+	case "profile_update":
+		updateProfile(gameState, player, message.Profile, now)
+	case "chat":
+		gameState.handleChat(player, message, now)
+	case "spectate":
+		// while dead a player can watch someone else until they respawn
+		if player.IsDead {
+			player.SendMessage(Message{Type: "spectate_target", Target: gameState.followable(message.Target)})
+		}
+	case "teleport":
+		// teleportation power up usage
+		if !player.IsDead && player.TeleportAvailable && player.CooldownLeft(effectTeleport, now) == 0 && message.CursorPos != nil {
+			// calculates direction vector to cursor
+			dx := message.CursorPos.X - player.Position.X
+			dy := message.CursorPos.Y - player.Position.Y
+			angle := math.Atan2(dy, dx)
+
+			// teleports a fixed distance in said direction
+			offset := 100.0
+			newPos := game.Position{X: player.Position.X + math.Cos(angle)*offset, Y: player.Position.Y + math.Sin(angle)*offset}
+			if isValidPosition(newPos) {
+				gameState.recordInput(player, message)
+				from := player.Position
+				player.Position = newPos
+				gameState.logEvent(player, models.EventTeleport, models.TeleportDetails{FromX: from.X, FromY: from.Y}, now)
+				player.StartCooldown(effectTeleport, gameState.cfg.TeleportCooldown.Duration(), now)
+				gameState.broadcast(Message{
+					Type:     "teleport",
 					PlayerID: player.ID,
 					Position: player.Position,
-					Rotation: message.Rotation,
-					Speed:    props.Speed,
-					Lifetime: props.Lifetime,
-				}
-				gameState.bullets[bullet.ID] = bullet
-			}
-		case "teleport":
-			// teleportation power up usage
-			if !player.IsDead && player.TeleportAvailable && message.CursorPos != nil {
-				// calculates direction vector to cursor
-				dx := message.CursorPos.X - player.Position.X
-				dy := message.CursorPos.Y - player.Position.Y
-				angle := math.Atan2(dy, dx)
-
-				// teleports a fixed distance in said direction
-				offset := 100.0
-				newPos := game.Position{X: player.Position.X + math.Cos(angle)*offset, Y: player.Position.Y + math.Sin(angle)*offset}
-				if isValidPosition(newPos) {
-					player.Position = newPos
-					gameState.broadcast(Message{
-						Type:     "teleport",
-						PlayerID: player.ID,
-						Position: player.Position,
-					})
-				}
+					Cooldown: gameState.cfg.TeleportCooldown.Duration().Milliseconds(),
+				})
 			}
-
-			// case "join_match":
-			// 	if gameState.matchActive {
-			// 		player.SendMessage(Message{Type: "match_started", MatchStarted: true})
-			// 		log.Printf("player %s joined the active match", player.ID)
-			// 	} else {
-			// 		player.SendMessage(Message{Type: "lobby_update", LobbyUpdate: getConnectedPlayers(gameState)})
-			// 		log.Printf("player %s requested to join, but no match is active", player.ID)
-			// 	}
-			// case "start_match":
-			// 	if !gameState.matchActive {
-			// 		gameState.matchActive = true
-			// 		gameState.broadcast(Message{Type: "match_started", MatchStarted: true})
-			// 		log.Println("Match is now active!")
-			// 	}
 		}
-		gameState.mutex.Unlock()
 
+		// case "join_match":
+		// 	if gameState.matchActive {
+		// 		player.SendMessage(Message{Type: "match_started", MatchStarted: true})
+		// 		log.Printf("player %s joined the active match", player.ID)
+		// 	} else {
+		// 		player.SendMessage(Message{Type: "lobby_update", LobbyUpdate: getConnectedPlayers(gameState)})
+		// 		log.Printf("player %s requested to join, but no match is active", player.ID)
+		// 	}
+		// case "start_match":
+		// 	if !gameState.matchActive {
+		// 		gameState.matchActive = true
+		// 		gameState.broadcast(Message{Type: "match_started", MatchStarted: true})
+		// 		log.Println("Match is now active!")
+		// 	}
 	}
 }
 
-
-// Batch insert function
-func batchInsertPlayerPositions(gs *GameState, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			gs.mutex.Lock()
-			var values []string
-			for _, p := range gs.players {
-				values = append(values, fmt.Sprintf(
-					"('%s', '%s', %.2f, %.2f, NOW())",
-					p.SessionID, p.ID, p.Position.X, p.Position.Y,
-				))
-			}
-			if len(values) > 0 {
-				query := fmt.Sprintf("INSERT INTO player_positions (session_id, player_id, x, y, timestamp) VALUES %s", strings.Join(values, ","))
-				_, err := database.GetDB().Exec(query)
-				if err != nil {
-					log.Printf("Error batch inserting player positions: %v", err)
-				}
-			}
-			gs.mutex.Unlock()
+// saves where everyone is right now as their last known position, which is what the next pod
+// restores players from. positions are copied under the lock and written after letting go of it.
+func saveLastKnownPositions(gs *GameState) {
+	gs.mutex.RLock()
+	now := time.Now()
+	var batch []models.Position
+	for _, p := range gs.players {
+		if p.IsBot {
+			continue
 		}
+		batch = append(batch, models.Position{
+			SessionID: p.SessionID,
+			PlayerID:  p.ID,
+			X:         p.Position.X,
+			Y:         p.Position.Y,
+			At:        now,
+		})
+	}
+	gs.mutex.RUnlock()
+	if err := gs.store.UpdateLastKnownPositions(batch); err != nil {
+		logging.DB.Error("saving last known positions failed", "room", gs.id, "players", len(batch), "err", err)
 	}
 }
 
-
 // Resets their state and starts the respawn timer
-func handlePlayerDeath(gameState *GameState, p *game.Player, position game.Position) {
+// killerID is whoever fired the bullet, the victim's camera follows them until the respawn.
+func handlePlayerDeath(gameState *GameState, p *game.Player, position game.Position, killerID, weapon string) {
 	p.IsDead = true
 	p.Health = 0
 	p.DeathTime = time.Now()
 	p.Position = position
+	p.TriggerHeld = false
+	// logged before the effects go so the log shows what they died with
+	lost := make([]string, 0, len(p.Effects))
+	for name := range p.Effects {
+		lost = append(lost, name)
+	}
+	sort.Strings(lost)
+	gameState.logEvent(p, models.EventDeath, models.DeathDetails{KillerID: killerID, Weapon: weapon, Effects: lost}, p.DeathTime)
 	// take away all power ups on death (no keeping your goodies)
-	p.TeleportAvailable = false
-	p.ForceFieldActive = false
-	p.Shield = 0
-	p.HealthRegenActive = false
-	p.RegenExpiry = time.Time{}
-	log.Printf("Player %s died, setting death state", p.ID)
+	gameState.clearEffects(p, "death", p.DeathTime)
+	logging.Sim.Info("player died", "room", gameState.id, "player", p.ID, "position", position)
 
 	// Cancel any existing death timer
 	if p.DeathTimer != nil {
@@ -629,9 +751,7 @@ func handlePlayerDeath(gameState *GameState, p *game.Player, position game.Posit
 		p.DeathTimer = nil
 	}
 
-	p.DeathTimer = time.AfterFunc(3*time.Second, func() {
-		respawnPlayer(gameState, p)
-	})
+	scheduleRespawn(gameState, p)
 
 	// broadcast death
 	gameState.broadcast(Message{
@@ -639,8 +759,14 @@ func handlePlayerDeath(gameState *GameState, p *game.Player, position game.Posit
 		PlayerID:  p.ID,
 		Position:  p.Position,
 		DeathTime: p.DeathTime.Unix(),
+		KillerID:  killerID,
 	})
 
+	// killer cam
+	if target := gameState.followable(killerID); target != "" && target != p.ID {
+		p.SendMessage(Message{Type: "spectate_target", Target: target})
+	}
+
 }
 
 // Respawns the player after they've been dead for the required time
@@ -659,30 +785,24 @@ func respawnPlayer(gameState *GameState, p *game.Player) {
 		p.DeathTimer = nil
 	}
 
-	// Check connection before respawn ie if they disconnected while dead don't respawn them
-	if p.Conn == nil {
-		delete(gameState.players, p.ID)
-		gameState.broadcast(Message{
-			Type:     "player_disconnect",
-			PlayerID: p.ID,
-		})
+	// Check connection before respawn ie if they disconnected while dead don't respawn them.
+	// they stay dead until they resume (which restarts the timer) or the grace window removes them
+	if p.Conn == nil && !p.IsBot {
 		return
 	}
 	// Reset power ups
-	p.TeleportAvailable = false
-	p.ForceFieldActive = false
-	p.Shield = 0
-	p.HealthRegenActive = false
+	gameState.clearEffects(p, "respawn", time.Now())
 	// spawnPoint := getRandomSpawnPoint(gameState)
 
 	// Reset player state for new character
 	p.IsDead = false
-	p.Health = 100
-	p.Weapon = "pistol"
+	p.Health = gameState.cfg.MaxHealth
+	p.ResetInventory("pistol")
 	// p.PendingPosition = &spawnPoint
 	// p.deathTimer = nil
 	p.DeathTime = time.Time{}
 	p.Position = getRandomSpawnPoint(gameState)
+	gameState.logEvent(p, models.EventSpawn, models.SpawnDetails{Respawn: true, Health: p.Health, Weapon: p.Weapon}, time.Now())
 
 	// Pre-calculate spawn point
 
@@ -705,7 +825,8 @@ func respawnPlayer(gameState *GameState, p *game.Player) {
 }
 
 // Finds a safe spawn point away from other players
-// prevents spawning on top of each other
+// prevents spawning on top of each other. with more players than spawn points there may
+// not be a safe one, then it's the point furthest from everybody rather than trying forever.
 func getRandomSpawnPoint(gameState *GameState) game.Position {
 	spawnPoints := []game.Position{
 		{X: 100, Y: 100},
@@ -713,54 +834,51 @@ func getRandomSpawnPoint(gameState *GameState) game.Position {
 		{X: 400, Y: 300},
 	}
 
-	// keep trying until we find a safe spawn point
-	for {
-		point := spawnPoints[rand.Intn(len(spawnPoints))]
-		safe := true
-
-		// check if any living players are too close
+	// every point once, in random order
+	best, bestGap := spawnPoints[0], -1.0
+	for _, i := range gameState.rng.Perm(len(spawnPoints)) {
+		point := spawnPoints[i]
+		// distance to the closest living player
+		gap := math.Inf(1)
 		for _, p := range gameState.players {
 			if p.IsDead {
 				continue
 			}
 			dx := point.X - p.Position.X
 			dy := point.Y - p.Position.Y
-			if math.Sqrt(dx*dx+dy*dy) < 100 { // minimum safe distance
-				safe = false
-				break
-			}
+			gap = min(gap, math.Sqrt(dx*dx+dy*dy))
 		}
 
-		if safe {
+		if gap >= 100 { // minimum safe distance
 			return point
 		}
-		// if not safe then loop will try another point
+		if gap > bestGap {
+			best, bestGap = point, gap
+		}
 	}
+	return best
 }
 
 // generates a unique id for game entities
-func generateID() string {
+func generateID(rng *rand.Rand) string {
 	// Just a simple prefix + random string for now
 	// in a production game we'd use UUIDs
-	return "player-" + randomString(8)
+	return "player-" + randomString(rng, 8)
 }
 
 // generate random string of specified length
-func randomString(length int) string {
+func randomString(rng *rand.Rand, length int) string {
 	//just random letters
 	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	b := make([]byte, length)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		b[i] = letters[rng.Intn(len(letters))]
 	}
 	return string(b)
 }
 
-// remove a player after they've been disconnected for a some time
+// remove a player after they've been disconnected for the grace window (see scheduleRemoval)
 func (gs *GameState) removePlayer(id string) {
-	// this gives them a chance to reconnect
-	time.Sleep(5 * time.Second)
-
 	gs.mutex.Lock()
 	defer gs.mutex.Unlock()
 
@@ -769,493 +887,500 @@ func (gs *GameState) removePlayer(id string) {
 		// player either doesnt exist or has reconnected
 		return
 	}
+	gs.dropPlayer(player)
+}
 
-	// removes player from database to free up the session ID
-	if err := database.RemovePlayer(player.SessionID); err != nil {
-		log.Printf("Error removing player %s from DB: %v", player.SessionID, err)
+// takes a player out of the room for good. caller must hold the lock.
+func (gs *GameState) dropPlayer(player *game.Player) {
+	// removes player from database to free up the session ID. anything of theirs the
+	// telemetry writer still has queued is thrown away first, or it would write it back
+	if !player.IsBot {
+		gs.logEvent(player, models.EventDisconnect, models.DisconnectDetails{Reason: "removed"}, time.Now())
+		gs.telemetry.Forget(player.SessionID)
+		if err := gs.store.RemovePlayer(player.SessionID); err != nil {
+			logging.DB.Error("removing player failed", "player", player.ID, "err", err)
+		}
 	}
 
 	// clean up any active timers
 	if player.DeathTimer != nil {
 		player.DeathTimer.Stop()
 	}
+	if player.DisconnectTimer != nil {
+		player.DisconnectTimer.Stop()
+	}
 
 	// and lastly remove from game state and notify
-	delete(gs.players, id)
+	delete(gs.players, player.ID)
+	delete(gs.bots, player.ID)
+	gs.releaseFollowers(player.ID)
 	gs.broadcast(Message{
 		Type:     "player_disconnect",
-		PlayerID: id,
+		PlayerID: player.ID,
 	})
 }
 
-// initialize environment variables and configuration
-func init() {
-	wd, err := os.Getwd()
-	if err != nil {
-		log.Printf("Error getting working directory: %v", err)
-	}
-	// Go up two levels to reach the project root
-	envPath := filepath.Join(wd, "..", "..", ".env")
-	if err := godotenv.Load(envPath); err != nil {
-		// wont be found in production thus k8 default variables will be injected instead
-		log.Printf("No .env file found at %s, using kubernetes system environment variables", envPath)
-	} else {
-		log.Printf(".env loaded successfully from %s", envPath)
-	}
-	log.Printf("DB_USERNAME: %s", os.Getenv("DB_USERNAME"))
-}
-
-// orchestrates the game server
-func main() {
+// runs the simulation for one room at its tick rate until ctx is cancelled.
+// bullets, pickups, movement, collisions and regen all happen here under the room lock.
+func runTickLoop(ctx context.Context, gameState *GameState) {
+	interval := gameState.settings().TickInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// seconds per tick, everything that moves is scaled by this
+	dt := interval.Seconds()
 
-	if err := database.InitDB(); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	for {
+		select {
+		case <-ticker.C:
+			tickStart := time.Now()
+			gameState.mutex.Lock()
+			gameState.seq++
+			// the tick rate can be changed live from the admin api
+			if next := gameState.cfg.TickInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+				dt = interval.Seconds()
+			}
+			// a stopped match still ticks (seq keeps counting) but nothing moves
+			if gameState.matchActive {
+				stepSimulation(gameState, tickStart, dt)
+				gameState.recordTick(tickStart)
+			}
+			observeTick(gameState, tickStart)
+			gameState.mutex.Unlock()
+		case <-ctx.Done():
+			return
+		}
 	}
-	gameState := newGameState()
-	gameState.matchActive = true
-
-	  // Start the batch insertion goroutine for player positions.
-    // This will flush player position data every 500ms.
-    go batchInsertPlayerPositions(gameState, 500*time.Millisecond)
-
-	ticker := time.NewTicker(tickRate)
-	defer ticker.Stop()
+}
 
-	// channel for clean up
-	done := make(chan struct{})
-	defer close(done)
+// advances the room by one tick of dt seconds: bullets, pickups, movement, collisions and regen.
+// now stands in for the wall clock so the simulation can be stepped outside the ticker.
+// caller must hold the lock.
+func stepSimulation(gameState *GameState, now time.Time, dt float64) {
+	// bots decide first so their input lands this tick like a human's would
+	tickBots(gameState, now, dt)
 
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				gameState.mutex.Lock()
-				if !gameState.matchActive {
-					// skips the entire update if match not active
-					gameState.mutex.Unlock()
-					continue
-				}
+	// updates the bullets
+	for id, bullet := range gameState.bullets {
+		// moves them
+		bullet.Position.X += math.Cos(bullet.Rotation) * bullet.Speed * dt
+		bullet.Position.Y += math.Sin(bullet.Rotation) * bullet.Speed * dt
 
-				// updates the bullets
-				for id, bullet := range gameState.bullets {
-					// moves them
-					bullet.Position.X += math.Cos(bullet.Rotation) * bullet.Speed * float64(tickRate) / float64(time.Second)
-					bullet.Position.Y += math.Sin(bullet.Rotation) * bullet.Speed * float64(tickRate) / float64(time.Second)
-
-					// bullet collisions with players
-					for _, p := range gameState.players {
-						// will skip if this is the shooter or if the target is already dead
-						if p.ID == bullet.PlayerID || p.IsDead {
-							continue
-						}
-						if p.ID != bullet.PlayerID {
-							// super simple circle collision check
-							dx := bullet.Position.X - p.Position.X
-							dy := bullet.Position.Y - p.Position.Y
-							distance := math.Sqrt(dx*dx + dy*dy)
-							// In bullet collision check
-							if distance < 20 { // collision detected (20 is the radius of the player)
-								// then get the shooter (the player who fired the bullet)
-								shooter, exists := gameState.players[bullet.PlayerID]
-								if !exists {
-									// if for some reason the shooter is not found just skip damage calculation.
-									continue
-								}
-								// gets the weapon properties for the shooter’s current weapon.
-								props := WeaponProperties[shooter.Weapon]
-
-								if p.ForceFieldActive && p.Shield > 0 {
-									if p.Shield >= props.Damage {
-										p.Shield -= props.Damage
-									} else {
-										// Not enough shield to block full damage.
-										leftover := props.Damage - p.Shield
-										p.Shield = 0
-										p.ForceFieldActive = false
-										newHealth := p.Health - leftover
-										if newHealth < 0 {
-											newHealth = 0
-										}
-										p.Health = newHealth
-										if p.Health <= 0 {
-											handlePlayerDeath(gameState, p, p.LastKnownPosition)
-											// finds the shooter (the player who fired the bullet)
-											shooter, exists := gameState.players[bullet.PlayerID]
-											if exists {
-												// update killer stats: increment kills by 1.
-												if err := database.UpdatePlayerStats(shooter.SessionID, 1, 0); err != nil {
-													log.Printf("Error updating killer stats: %v", err)
-												}
-											}
-											// update victim stats: increment deaths by 1.
-											if err := database.UpdatePlayerStats(p.SessionID, 0, 1); err != nil {
-												log.Printf("Error updating victim stats: %v", err)
-											}
-										}
-									}
-								} else {
-									// no active shield thus apply damage directly.
-									newHealth := p.Health - props.Damage
-									if newHealth < 0 {
-										newHealth = 0
-									}
-									p.Health = newHealth
-									if p.Health <= 0 {
-										handlePlayerDeath(gameState, p, p.LastKnownPosition)
-										shooter, exists := gameState.players[bullet.PlayerID]
-										if exists {
-											if err := database.UpdatePlayerStats(shooter.SessionID, 1, 0); err != nil {
-												log.Printf("Error updating killer stats: %v", err)
-											}
-										}
-										if err := database.UpdatePlayerStats(p.SessionID, 0, 1); err != nil {
-											log.Printf("Error updating victim stats: %v", err)
-										}
-									}
-								}
-								gameState.broadcast(Message{
-									Type:     "health_update",
-									PlayerID: p.ID,
-									Health:   p.Health,
-								})
-
-								bulletDirX := math.Cos(bullet.Rotation)
-								bulletDirY := math.Sin(bullet.Rotation)
-
-								// Apply impulse
-								impulse := 100.0 // Adjust as needed
-								p.Velocity.X += bulletDirX * impulse
-								p.Velocity.Y += bulletDirY * impulse
-
-								delete(gameState.bullets, id)
-								break
-							}
-						}
-
-						// Applies velocity
-						p.Position.X += p.Velocity.X * float64(tickRate) / float64(time.Second)
-						p.Position.Y += p.Velocity.Y * float64(tickRate) / float64(time.Second)
-
-						// and damping
-						p.Velocity.X *= 0.9
-						p.Velocity.Y *= 0.9
-
-						// Clamp position if needed
-						if !isValidPosition(p.Position) {
-							p.Position = p.LastKnownPosition
-						}
+		// bullet collisions with players
+		for _, p := range gameState.players {
+			// will skip if this is the shooter or if the target is already dead
+			if p.ID == bullet.PlayerID || p.IsDead {
+				continue
+			}
+			if p.ID != bullet.PlayerID {
+				// super simple circle collision check
+				dx := bullet.Position.X - p.Position.X
+				dy := bullet.Position.Y - p.Position.Y
+				distance := math.Sqrt(dx*dx + dy*dy)
+				// In bullet collision check
+				if distance < 20 { // collision detected (20 is the radius of the player)
+					// then get the shooter (the player who fired the bullet)
+					shooter, exists := gameState.players[bullet.PlayerID]
+					if !exists {
+						// if for some reason the shooter is not found just skip damage calculation.
+						continue
 					}
-
-					bullet.Lifetime -= float64(tickRate) / float64(time.Second)
-					if bullet.Lifetime <= 0 {
-						delete(gameState.bullets, id)
+					// damage comes from the weapon that fired the bullet
+					weapon := bullet.Weapon
+					if weapon == "" {
+						weapon = shooter.Weapon
 					}
-				} // Right after bullet updates
-				for _, bullet := range gameState.bullets {
+					applyDamage(gameState, shooter, p, weapon, WeaponProperties[weapon].Damage, now)
 					gameState.broadcast(Message{
-						Type:     "bullet_update",
-						PlayerID: bullet.PlayerID,
-						Position: bullet.Position,
+						Type:     "health_update",
+						PlayerID: p.ID,
+						Health:   p.Health,
 					})
-				}
 
-				// after the bullet updates adds the weapon pickup check
-				for id, weapon := range gameState.weapons {
+					bulletDirX := math.Cos(bullet.Rotation)
+					bulletDirY := math.Sin(bullet.Rotation)
 
-					if time.Since(weapon.SpawnTime) > 30*time.Second { // 30 second despawn timer
-						delete(gameState.weapons, id)
-						gameState.broadcast(Message{
-							Type:     "weapon_despawn",
-							WeaponID: id,
-						})
-						continue
-					}
-					for playerID, p := range gameState.players {
-						dx := weapon.Position.X - p.Position.X
-						dy := weapon.Position.Y - p.Position.Y
-						if !isValidPosition(weapon.Position) {
-							delete(gameState.weapons, id) // removes invalid weapons
-							continue
-						}
-						distance := math.Sqrt(dx*dx + dy*dy)
-
-						if distance < 20 { // player radius + weapon radius
-							// don't pick up if it's the same weapon type
-							if p.Weapon == weapon.Type {
-								continue
-							}
-
-							// store old weapon ID if player has one
-							oldWeaponType := p.Weapon
-
-							// assign new weapon to player
-							p.Weapon = weapon.Type
-							delete(gameState.weapons, id)
-
-							gameState.broadcast(Message{
-								Type:     "weapon_pickup",
-								PlayerID: playerID,
-								WeaponID: id,
-								Weapon:   weapon.Type,
-							})
-
-							// only spawns old weapon if it was different
-							if oldWeaponType != "" && oldWeaponType != weapon.Type {
-								newWeapon := &Weapon{
-									ID:   generateID(),
-									Type: oldWeaponType,
-									Position: game.Position{
-										X: p.Position.X + 30,
-										Y: p.Position.Y,
-									},
-								}
-								gameState.weapons[newWeapon.ID] = newWeapon
-								gameState.broadcast(Message{
-									Type:     "weapon_spawn",
-									WeaponID: newWeapon.ID,
-									Position: newWeapon.Position,
-									Weapon:   newWeapon.Type,
-								})
-							}
-							break
-						}
-					}
-				}
+					// Apply impulse
+					impulse := 100.0 // Adjust as needed
+					p.Velocity.X += bulletDirX * impulse
+					p.Velocity.Y += bulletDirY * impulse
 
-				for id, powerup := range gameState.powerups {
-					// removes expired powerups (after 30 seconds)
-					if time.Since(powerup.SpawnTime) > 30*time.Second {
-						delete(gameState.powerups, id)
-						gameState.broadcast(Message{
-							Type:      "powerup_despawn",
-							PowerUpID: id,
-						})
-						continue
-					}
-					for playerID, p := range gameState.players {
-						dx := powerup.Position.X - p.Position.X
-						dy := powerup.Position.Y - p.Position.Y
-						if !isValidPosition(powerup.Position) {
-							delete(gameState.powerups, id)
-							continue
-						}
-						distance := math.Sqrt(dx*dx + dy*dy)
-						if distance < 20 { // collision detected
-							p.TeleportAvailable = false
-							p.ForceFieldActive = false
-							p.HealthRegenActive = false
-							switch powerup.Type {
-							case "teleportation":
-								if !p.TeleportAvailable { // prevents stacking
-									p.TeleportAvailable = true
-									gameState.broadcast(Message{
-										Type:      "powerup_pickup",
-										PlayerID:  playerID,
-										PowerUpID: id,
-										PowerUp:   powerup.Type,
-									})
-								}
-							case "force_field":
-								// activate force field.
-								p.ForceFieldActive = true
-								p.Shield = 100 // maximum shield value
-								gameState.broadcast(Message{
-									Type:      "powerup_pickup",
-									PlayerID:  playerID,
-									PowerUpID: id,
-									PowerUp:   powerup.Type,
-								})
-								//not working
-							case "health_regen":
-								p.HealthRegenActive = true
-								p.RegenExpiry = time.Now().Add(10 * time.Second)
-								p.HealthRegenAccumulator = 0 // reset accumulator
-								gameState.broadcast(Message{
-									Type:      "powerup_pickup",
-									PlayerID:  playerID,
-									PowerUpID: id,
-									PowerUp:   powerup.Type,
-								})
-							}
-							delete(gameState.powerups, id)
-							break
-						}
-					}
+					delete(gameState.bullets, id)
+					break
 				}
+			}
 
-				changed := false
-
-				for _, p := range gameState.players {
-					if p.PendingPosition != nil {
-						if isValidPosition(*p.PendingPosition) {
-							p.Position = *p.PendingPosition
-						} else {
-							p.Position = game.Position{X: 500, Y: 300} // reset to default
-						}
-						p.PendingPosition = nil
-						changed = true
-					}
-				}
-				for id1, player1 := range gameState.players {
-					for id2, player2 := range gameState.players {
-						if id1 == id2 {
-							continue
-						}
-						const pushForce = 1.5
-
-						collider1 := &game.CircleCollider{
-							X:      player1.Position.X,
-							Y:      player1.Position.Y,
-							Radius: 15,
-						}
-						collider2 := &game.CircleCollider{
-							X:      player2.Position.X,
-							Y:      player2.Position.Y,
-							Radius: 15,
-						}
-						// collision check
-						px1, py1, px2, py2 := game.ResolveCollision(collider1, collider2, pushForce)
-						if !math.IsNaN(px1) && !math.IsNaN(py1) && !math.IsNaN(px2) && !math.IsNaN(py2) {
-							player1.Position.X += px1
-							player1.Position.Y += py1
-							player2.Position.X += px2
-							player2.Position.Y += py2
-						}
-						// check for if players are too close
-						if px1 != 0 || py1 != 0 {
-							player1.Position.X += px1
-							player1.Position.Y += py1
-							player2.Position.X += px2
-							player2.Position.Y += py2
-							log.Printf("Collision resolved: Player %s at %+v, Player %s at %+v",
-								id1, player1.Position, id2, player2.Position)
-
-							// new positions
-							gameState.broadcast(Message{
-								Type:     "position_update",
-								PlayerID: id1,
-								Position: player1.Position,
-								Color:    player1.Color,
-							})
-							gameState.broadcast(Message{
-								Type:     "position_update",
-								PlayerID: id2,
-								Position: player2.Position,
-								Color:    player2.Color,
-							})
-						}
-					}
-				}
-				if changed {
-					for id, p := range gameState.players {
-						gameState.broadcast(Message{
-							Type:     "position_update",
-							PlayerID: id,
-							Position: p.Position,
-							Color:    p.Color,
-						})
-					}
-				}
-				// health regeneration logic
-				for _, p := range gameState.players {
-					if p.HealthRegenActive && p.Health < 100 {
-						if time.Now().After(p.RegenExpiry) {
-							p.HealthRegenActive = false
-							p.HealthRegenAccumulator = 0 // reset accumulator when a power up expires
-						} else {
-							regenPerTick := 10 * (float64(tickRate) / float64(time.Second)) // ≈ 0.16
-							p.HealthRegenAccumulator += regenPerTick
-							if p.HealthRegenAccumulator >= 1.0 {
-								increment := int(p.HealthRegenAccumulator) // whole number part
-								p.Health += increment
-								p.HealthRegenAccumulator -= float64(increment) // keeping the remainder
-								if p.Health > 100 {
-									p.Health = 100
-									p.HealthRegenAccumulator = 0 // resets if max health reached
-								}
-								log.Printf("Player %s health regenerated to %d", p.ID, p.Health)
-								gameState.broadcast(Message{
-									Type:     "health_update",
-									PlayerID: p.ID,
-									Health:   p.Health,
-								})
-							}
-							// logs every tick for debugging
-							log.Printf("health regen tick for player %s: health=%d, regenActive=%t, accumulator=%f",
-								p.ID, p.Health, p.HealthRegenActive, p.HealthRegenAccumulator)
-						}
-					}
-				}
+			// Applies velocity
+			p.Position.X += p.Velocity.X * dt
+			p.Position.Y += p.Velocity.Y * dt
 
-				gameState.mutex.Unlock()
-			case <-done:
-				return
+			// and damping
+			p.Velocity.X *= 0.9
+			p.Velocity.Y *= 0.9
+
+			// Clamp position if needed
+			if !isValidPosition(p.Position) {
+				p.Position = p.LastKnownPosition
 			}
 		}
-	}()
-	go func() {
-		for {
-			time.Sleep(1 * time.Second)
-			gameState.mutex.Lock()
-			if !gameState.matchActive {
-				// If the match isn’t active, skip spawning
-				gameState.mutex.Unlock()
+
+		bullet.Lifetime -= dt
+		if bullet.Lifetime <= 0 {
+			delete(gameState.bullets, id)
+		}
+	} // Right after bullet updates
+	for _, bullet := range gameState.bullets {
+		gameState.broadcast(Message{
+			Type:     "bullet_update",
+			BulletID: bullet.ID,
+			PlayerID: bullet.PlayerID,
+			Position: bullet.Position,
+		})
+	}
+
+	// weapons on the ground time out. picking them up is explicit, see pickup_weapon
+	for id, weapon := range gameState.weapons {
+
+		if now.Sub(weapon.SpawnTime) > gameState.cfg.WeaponDespawn.Duration() { // 30 second despawn timer by default
+			delete(gameState.weapons, id)
+			gameState.broadcast(Message{
+				Type:     "weapon_despawn",
+				WeaponID: id,
+			})
+			continue
+		}
+		if !isValidPosition(weapon.Position) {
+			delete(gameState.weapons, id) // removes invalid weapons
+		}
+	}
+
+	for id, powerup := range gameState.powerups {
+		// removes expired powerups (after 30 seconds)
+		if now.Sub(powerup.SpawnTime) > gameState.cfg.PowerUpDespawn.Duration() {
+			delete(gameState.powerups, id)
+			gameState.broadcast(Message{
+				Type:      "powerup_despawn",
+				PowerUpID: id,
+			})
+			continue
+		}
+		for playerID, p := range gameState.players {
+			dx := powerup.Position.X - p.Position.X
+			dy := powerup.Position.Y - p.Position.Y
+			if !isValidPosition(powerup.Position) {
+				delete(gameState.powerups, id)
 				continue
 			}
-
-			if len(gameState.weapons) < 5 { // Max 5 weapons at once
-				weapon := spawnWeapon()
-				gameState.weapons[weapon.ID] = weapon
-				log.Printf("Spawning weapon: %+v", weapon)
+			distance := math.Sqrt(dx*dx + dy*dy)
+			if distance < 20 && !p.IsDead { // collision detected
+				// each effect stacks by its own rules, picking one up doesn't cancel the others.
+				// if it doesn't take (cooling down, doesn't stack) it stays for someone else
+				if !gameState.applyEffect(p, powerup.Type, now) {
+					continue
+				}
+				gameState.logPickup(p, "powerup", id, powerup.Type, powerup.Position, powerup.SpawnTime, now)
+				gameState.logPowerUp(p, powerup.Type, now)
 				gameState.broadcast(Message{
-					Type:     "weapon_spawn",
-					WeaponID: weapon.ID,
-					Position: weapon.Position,
-					Weapon:   weapon.Type,
+					Type:      "powerup_pickup",
+					PlayerID:  playerID,
+					PowerUpID: id,
+					PowerUp:   powerup.Type,
 				})
+				delete(gameState.powerups, id)
+				break
 			}
-			gameState.mutex.Unlock()
 		}
-	}()
+	}
 
-	log.Println("Power-up spawning goroutine started")
-	// separate goroutine for power up spawning
-	go func() {
-		for {
-			delay := time.Duration(15+rand.Intn(30)) * time.Second
-			time.Sleep(delay)
-			gameState.mutex.Lock()
-			if !gameState.matchActive {
-				// if the match hasnt started yet skip spawning
-				gameState.mutex.Unlock()
+	changed := false
+
+	for _, p := range gameState.players {
+		if p.PendingPosition != nil {
+			if isValidPosition(*p.PendingPosition) {
+				p.Position = *p.PendingPosition
+			} else {
+				p.Position = game.Position{X: 500, Y: 300} // reset to default
+			}
+			p.PendingPosition = nil
+			changed = true
+		}
+	}
+	for id1, player1 := range gameState.players {
+		for id2, player2 := range gameState.players {
+			if id1 == id2 {
 				continue
 			}
-			// spawn only if there are less than 3 on the map
-			if len(gameState.powerups) < 3 {
-				powerup := spawnPowerUp()
-				gameState.powerups[powerup.ID] = powerup
-				log.Printf("Spawning powerup: %+v", powerup)
+			const pushForce = 1.5
+
+			collider1 := &game.CircleCollider{
+				X:      player1.Position.X,
+				Y:      player1.Position.Y,
+				Radius: 15,
+			}
+			collider2 := &game.CircleCollider{
+				X:      player2.Position.X,
+				Y:      player2.Position.Y,
+				Radius: 15,
+			}
+			// collision check
+			px1, py1, px2, py2 := game.ResolveCollision(collider1, collider2, pushForce)
+			if !math.IsNaN(px1) && !math.IsNaN(py1) && !math.IsNaN(px2) && !math.IsNaN(py2) {
+				player1.Position.X += px1
+				player1.Position.Y += py1
+				player2.Position.X += px2
+				player2.Position.Y += py2
+			}
+			// check for if players are too close
+			if px1 != 0 || py1 != 0 {
+				player1.Position.X += px1
+				player1.Position.Y += py1
+				player2.Position.X += px2
+				player2.Position.Y += py2
+				if tickSampler.Allow("collision:" + id1) {
+					logging.Sim.Debug("collision resolved", "room", gameState.id,
+						"player", id1, "position", player1.Position, "other", id2, "other_position", player2.Position)
+				}
+
+				// new positions
 				gameState.broadcast(Message{
-					Type:      "powerup_spawn",
-					PowerUpID: powerup.ID,
-					Position:  powerup.Position,
-					PowerUp:   powerup.Type,
+					Type:     "position_update",
+					PlayerID: id1,
+					Position: player1.Position,
+					Color:    player1.Color,
+				})
+				gameState.broadcast(Message{
+					Type:     "position_update",
+					PlayerID: id2,
+					Position: player2.Position,
+					Color:    player2.Color,
 				})
 			}
+		}
+	}
+	if changed {
+		for id, p := range gameState.players {
+			gameState.broadcast(Message{
+				Type:     "position_update",
+				PlayerID: id,
+				Position: p.Position,
+				Color:    p.Color,
+			})
+		}
+	}
+	// automatic fire and weapon cooling
+	tickWeapons(gameState, now, dt)
+	// regen and every other timed effect
+	tickEffects(gameState, now, dt)
+}
+
+// keeps the map stocked with weapons up to the room's limit
+func runWeaponSpawner(ctx context.Context, gameState *GameState) {
+	for {
+		select {
+		case <-time.After(gameState.settings().WeaponSpawnInterval.Duration()):
+		case <-ctx.Done():
+			return
+		}
+		gameState.mutex.Lock()
+		if !gameState.matchActive {
+			// If the match isn’t active, skip spawning
 			gameState.mutex.Unlock()
+			continue
 		}
-	}()
+
+		if len(gameState.weapons) < gameState.cfg.MaxWeapons { // Max 5 weapons at once by default
+			weapon := spawnWeapon(gameState.rng)
+			gameState.weapons[weapon.ID] = weapon
+			logging.Spawn.Info("weapon spawned", "room", gameState.id, "id", weapon.ID, "weapon", weapon.Type, "position", weapon.Position)
+			gameState.broadcast(Message{
+				Type:     "weapon_spawn",
+				WeaponID: weapon.ID,
+				Position: weapon.Position,
+				Weapon:   weapon.Type,
+			})
+		}
+		gameState.mutex.Unlock()
+	}
+}
+
+// anywhere between the min and max spawn interval (15-45s by default)
+func (gs *GameState) nextPowerUpDelay() time.Duration {
+	gs.mutex.Lock()
+	defer gs.mutex.Unlock()
+	spread := gs.cfg.PowerUpSpawnMax.Duration() - gs.cfg.PowerUpSpawnMin.Duration()
+	delay := gs.cfg.PowerUpSpawnMin.Duration()
+	if spread > 0 {
+		delay += time.Duration(gs.rng.Int63n(int64(spread)))
+	}
+	return delay
+}
+
+// separate loop for power up spawning, at a random interval so they're harder to camp
+func runPowerUpSpawner(ctx context.Context, gameState *GameState) {
+	for {
+		select {
+		case <-time.After(gameState.nextPowerUpDelay()):
+		case <-ctx.Done():
+			return
+		}
+		gameState.mutex.Lock()
+		if !gameState.matchActive {
+			// if the match hasnt started yet skip spawning
+			gameState.mutex.Unlock()
+			continue
+		}
+		// spawn only if there are less than the limit (3 by default) on the map
+		if len(gameState.powerups) < gameState.cfg.MaxPowerUps {
+			powerup := spawnPowerUp(gameState.rng)
+			gameState.powerups[powerup.ID] = powerup
+			logging.Spawn.Info("powerup spawned", "room", gameState.id, "id", powerup.ID, "type", powerup.Type, "position", powerup.Position)
+			gameState.broadcast(Message{
+				Type:      "powerup_spawn",
+				PowerUpID: powerup.ID,
+				Position:  powerup.Position,
+				PowerUp:   powerup.Type,
+			})
+		}
+		gameState.mutex.Unlock()
+	}
+}
+
+// every http endpoint the server has
+func routes(cfg *config.Config, rooms *RoomManager) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// prometheus scrapes this
+	mux.Handle("/metrics", metrics.Handler())
+
+	// levels can be turned up per subsystem without a restart
+	mux.Handle("/admin/log-levels", server.RequireToken(cfg.AdminToken, logging.LevelHandler()))
+
+	// operator api: inspect rooms, kick/ban, spawn pickups, end matches, tweak settings
+	mux.Handle("/admin/", server.RequireToken(cfg.AdminToken, adminHandler(rooms)))
+
+	// players' names, colors and sprites, also changeable from the game with profile_update
+	mux.HandleFunc("GET /profile", handleGetProfile(rooms.store))
+	mux.HandleFunc("PUT /profile", handlePutProfile(rooms))
+
+	// recorded matches, the viewer is a websocket like /ws
+	mux.HandleFunc("GET /replays", handleReplayList(cfg.ReplayDir))
+	mux.HandleFunc("GET /replays/{id}", handleReplayViewer(cfg.ReplayDir))
 
 	// serve static files
-	http.Handle("/", http.FileServer(http.Dir("web")))
+	mux.Handle("/", http.FileServer(http.Dir(cfg.StaticDir)))
 
-	// and webSocket endpoint
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handleWebSocket(gameState, w, r)
+	// and webSocket endpoint, ?room=name picks the room (default room otherwise)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		room, err := rooms.get(r.URL.Query().Get("room"))
+		if errors.Is(err, errTooManyRooms) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handleWebSocket(room, w, r)
 	})
+	return mux
+}
+
+// brings the schema up to date, or with apply off (cmd/migrate runs them instead) just warns
+// when the database is behind this build
+func migrate(ctx context.Context, store *database.SQLStore, apply bool) error {
+	migrator, err := store.Migrator()
+	if err != nil {
+		return err
+	}
+	if apply {
+		return migrator.Up(ctx)
+	}
+	version, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version < migrator.Latest() {
+		logging.DB.Warn("database schema is behind, run cmd/migrate up", "version", version, "latest", migrator.Latest())
+	}
+	return nil
+}
+
+// orchestrates the game server
+func main() {
+	// settings come from defaults, an optional config file, the environment and flags (in that order)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		logging.Main.Error("invalid configuration", "err", err)
+		os.Exit(1)
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevels); err != nil {
+		logging.Main.Error("invalid logging configuration", "err", err)
+		os.Exit(1)
+	}
+	if err := server.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logging.Main.Error("invalid trusted_proxies", "err", err)
+		os.Exit(1)
+	}
+
+	// SIGTERM is what kubernetes sends on a rollout, ctrl+c for local dev
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := database.Open(cfg.DBDriver, cfg.DBPath)
+	if err != nil {
+		logging.Main.Error("failed to initialize database", "err", err)
+		os.Exit(1)
+	}
+	if err := migrate(ctx, store, cfg.DBMigrate); err != nil {
+		logging.Main.Error("database schema isn't ready", "err", err)
+		os.Exit(1)
+	}
+
+	// every long running goroutine is tracked here and stops when ctx is cancelled
+	var loops sync.WaitGroup
+	positions := telemetry.NewWriter(store, telemetry.Options{
+		Buffer:        cfg.PositionBuffer,
+		BatchSize:     cfg.PositionBatchSize,
+		FlushInterval: cfg.PositionFlushInterval.Duration(),
+	})
+	rooms := newRoomManager(ctx, cfg, store, positions, &loops)
+	goWithContext(ctx, &loops, func(ctx context.Context) {
+		runRoomReaper(ctx, rooms)
+	})
+	if cfg.PositionRetention > 0 {
+		goWithContext(ctx, &loops, func(ctx context.Context) {
+			runRetention(ctx, store, cfg.PositionRetention.Duration())
+		})
+	}
+
+	// the default room and any room with its own config section are always up
+	for _, name := range append([]string{cfg.DefaultRoom}, cfg.RoomNames()...) {
+		if _, err := rooms.get(name); err != nil {
+			logging.Main.Error("failed to start room", "room", name, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	srv := &http.Server{Addr: cfg.Addr, Handler: routes(cfg, rooms)}
+	go func() {
+		logging.Main.Info("starting server", "addr", cfg.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Main.Error("server failed", "err", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	stop() // a second ctrl+c kills us straight away
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
+	logging.Main.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration())
+	defer cancel()
+	if err := shutdown(shutdownCtx, srv, rooms.all(), store, positions, &loops); err != nil {
+		logging.Main.Error("unclean shutdown", "err", err)
+		os.Exit(1)
 	}
+	logging.Main.Info("server stopped")
 }
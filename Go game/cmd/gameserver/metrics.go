@@ -0,0 +1,55 @@
+package main
+
+import (
+	"arena-tactics/internal/metrics"
+	"time"
+)
+
+// message types clients are allowed to send. anything else is counted as "unknown"
+// so a client sending garbage types can't blow up the metric's label set.
+var inboundTypes = map[string]bool{
+	"session_init":       true,
+	"position":           true,
+	"shoot":              true,
+	"teleport":           true,
+	"join_match":         true,
+	"start_match":        true,
+	"spectate":           true,
+	"scoreboard_request": true,
+	"pickup_weapon":      true,
+	"switch_weapon":      true,
+	"drop_weapon":        true,
+	"trigger":            true,
+	"profile_update":     true,
+	"chat":               true,
+}
+
+func inboundLabel(msgType string) string {
+	if inboundTypes[msgType] {
+		return msgType
+	}
+	return "unknown"
+}
+
+// lets game.Player.SendMessage count outgoing messages by type
+func (m Message) MessageType() string {
+	return m.Type
+}
+
+// records how long a tick took, whether it blew its budget and how many players are connected.
+// caller must hold the lock.
+func observeTick(gs *GameState, start time.Time) {
+	elapsed := time.Since(start)
+	metrics.TickDuration.WithLabelValues(gs.id).Observe(elapsed.Seconds())
+	if elapsed > gs.cfg.TickInterval() {
+		metrics.TickOverruns.WithLabelValues(gs.id).Inc()
+	}
+
+	connected := 0
+	for _, p := range gs.players {
+		if p.Conn != nil {
+			connected++
+		}
+	}
+	metrics.ConnectedPlayers.WithLabelValues(gs.id).Set(float64(connected))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/profile"
+	"arena-tactics/internal/server"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// what a player can change about how everyone else sees them, anything left out stays as it is
+type ProfileUpdate struct {
+	Name   *string `json:"name,omitempty"`
+	Color  *int    `json:"color,omitempty"`
+	Sprite *string `json:"sprite,omitempty"`
+}
+
+// every save is a database write, so one a second per player over the websocket
+const profileCooldown = time.Second
+
+// an update that breaks the rules, the message is meant for the player
+type invalidProfile struct{ error }
+
+// the session's saved profile, or the defaults when it hasn't saved one
+func loadProfile(store database.Store, sessionID string) (models.Profile, error) {
+	p, err := store.GetProfile(sessionID)
+	if err != nil {
+		return models.Profile{}, err
+	}
+	if p == nil {
+		return models.Profile{SessionID: sessionID, Color: profile.DefaultColor(sessionID), Sprite: profile.DefaultSprite}, nil
+	}
+	return *p, nil
+}
+
+// checks the update and puts it on top of prof
+func (u ProfileUpdate) apply(prof models.Profile) (models.Profile, error) {
+	if u.Name != nil {
+		name, err := profile.ValidateName(*u.Name)
+		if err != nil {
+			return prof, invalidProfile{err}
+		}
+		prof.DisplayName = name
+	}
+	if u.Color != nil {
+		if !profile.ValidColor(*u.Color) {
+			return prof, invalidProfile{errors.New("color must be an rgb value from 0 to 0xFFFFFF")}
+		}
+		prof.Color = *u.Color
+	}
+	if u.Sprite != nil {
+		if !profile.ValidSprite(*u.Sprite) {
+			return prof, invalidProfile{fmt.Errorf("sprite must be one of %s", strings.Join(profile.Sprites, ", "))}
+		}
+		prof.Sprite = *u.Sprite
+	}
+	return prof, nil
+}
+
+// loads the session's profile, applies the update and saves it. fails with invalidProfile or
+// database.ErrNameTaken when it's the player's fault, anything else is the store's.
+func saveProfile(store database.Store, sessionID string, u ProfileUpdate) (models.Profile, error) {
+	prof, err := loadProfile(store, sessionID)
+	if err != nil {
+		return prof, err
+	}
+	if prof, err = u.apply(prof); err != nil {
+		return prof, err
+	}
+	if err := store.SaveProfile(prof); err != nil {
+		return prof, err
+	}
+	logging.Main.Info("profile saved", "session", sessionID, "name", prof.DisplayName, "color", prof.Color, "sprite", prof.Sprite)
+	return prof, nil
+}
+
+// sends the error, unless it's the database's. that stays in the log since anyone can call these
+func writeProfileError(w http.ResponseWriter, err error) {
+	status := profileErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		logging.DB.Error("profile request failed", "err", err)
+		err = errors.New("couldn't load or save the profile, try again")
+	}
+	server.WriteError(w, status, err)
+}
+
+// the player's fault or ours
+func profileErrorStatus(err error) int {
+	var invalid invalidProfile
+	switch {
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	case errors.Is(err, database.ErrNameTaken):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// puts a profile on a player. without a chosen name they keep the username from their
+// players row. caller must hold the lock.
+func (gs *GameState) applyProfile(p *game.Player, prof models.Profile) {
+	if prof.DisplayName != "" {
+		p.Username = prof.DisplayName
+		if stats, ok := gs.matchStats[p.ID]; ok {
+			stats.Username = p.Username
+		}
+	}
+	p.Color = prof.Color
+	p.Sprite = prof.Sprite
+}
+
+// tells everyone how a player looks now. caller must hold the lock.
+func (gs *GameState) broadcastProfile(p *game.Player) {
+	gs.broadcast(Message{
+		Type:     "profile_update",
+		PlayerID: p.ID,
+		Username: p.Username,
+		Color:    p.Color,
+		Sprite:   p.Sprite,
+		Team:     p.Team,
+	})
+}
+
+// a profile_update from the player's own connection. the save happens off the lock so the
+// tick never waits on the database, the result is shown once it's done. whatever goes wrong
+// is only sent back to them. caller must hold the lock.
+func updateProfile(gs *GameState, p *game.Player, u *ProfileUpdate, now time.Time) {
+	if u == nil || p.IsBot {
+		return
+	}
+	// failed attempts count too, a taken name costs a query as well
+	if now.Sub(p.ProfileSavedAt) < profileCooldown {
+		p.SendMessage(Message{Type: "profile_error", Reason: "you're changing your profile too fast"})
+		return
+	}
+	p.ProfileSavedAt = now
+	go func(sessionID string, u ProfileUpdate) {
+		prof, err := saveProfile(gs.store, sessionID, u)
+		gs.mutex.Lock()
+		defer gs.mutex.Unlock()
+		// they may have left while it was saving, the profile's still theirs next time
+		if gs.players[p.ID] != p {
+			return
+		}
+		if err != nil {
+			reason := err.Error()
+			if profileErrorStatus(err) == http.StatusInternalServerError {
+				logging.DB.Error("saving profile failed", "player", p.ID, "err", err)
+				reason = "couldn't save your profile, try again"
+			}
+			p.SendMessage(Message{Type: "profile_error", Reason: reason})
+			return
+		}
+		gs.applyProfile(p, prof)
+		gs.broadcastProfile(p)
+	}(p.SessionID, *u)
+}
+
+type profileResponse struct {
+	models.Profile
+	Sprites []string `json:"sprites"` // what the sprite can be
+}
+
+// GET /profile?session_id=... is the session's profile, with the defaults when it hasn't
+// saved one. the session id is the only credential a player has, same as on /ws.
+func handleGetProfile(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.URL.Query().Get("session_id")
+		if sessionID == "" {
+			server.WriteError(w, http.StatusBadRequest, errors.New("session_id is required"))
+			return
+		}
+		prof, err := loadProfile(store, sessionID)
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		server.WriteJSON(w, http.StatusOK, profileResponse{prof, profile.Sprites})
+	}
+}
+
+// PUT /profile {"session_id": "...", "name": "...", "color": 16711680, "sprite": "mech"}
+//
+// saves the profile and shows it straight away if the session is playing in any room.
+// 400 for a bad name, color or sprite, 409 when the name is taken.
+func handlePutProfile(rooms *RoomManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SessionID string `json:"session_id"`
+			ProfileUpdate
+		}
+		if err := server.DecodeJSON(r, &req); err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.SessionID == "" {
+			server.WriteError(w, http.StatusBadRequest, errors.New("session_id is required"))
+			return
+		}
+		// banned sessions can't play, they don't get to dress up either
+		ban, err := rooms.store.FindBan(req.SessionID, server.ClientIP(r))
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		if ban != nil {
+			server.WriteError(w, http.StatusForbidden, errors.New("banned"))
+			return
+		}
+		prof, err := saveProfile(rooms.store, req.SessionID, req.ProfileUpdate)
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		for _, room := range rooms.all() {
+			room.mutex.Lock()
+			for _, p := range room.players {
+				if p.SessionID == req.SessionID && !p.IsBot {
+					room.applyProfile(p, prof)
+					room.broadcastProfile(p)
+				}
+			}
+			room.mutex.Unlock()
+		}
+		server.WriteJSON(w, http.StatusOK, profileResponse{prof, profile.Sprites})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/replay"
+	"arena-tactics/internal/server"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// starts recording a new match. every match gets its own seed so the header
+// has everything needed to reproduce the rng. caller must hold the lock.
+func (gs *GameState) startRecording() {
+	gs.seed = newSeed(gs.cfg)
+	gs.rng.Seed(gs.seed)
+	gs.recordingPart = 0
+	gs.openRecording(time.Now())
+}
+
+// opens the match's next replay file. a long match is split over several, each one opens
+// with the full state so it plays on its own. caller must hold the lock.
+func (gs *GameState) openRecording(now time.Time) {
+	if gs.replayDir == "" {
+		return
+	}
+	gs.recordingPart++
+	settings, _ := json.Marshal(gs.cfg)
+	rec, err := replay.Create(gs.replayDir, replay.Header{
+		Room:      gs.id,
+		Seed:      gs.seed,
+		Part:      gs.recordingPart,
+		TickRate:  gs.cfg.TickRate,
+		StartedAt: now,
+		Settings:  settings,
+	})
+	if err != nil {
+		logging.Main.Error("starting replay failed", "room", gs.id, "err", err)
+		return
+	}
+	gs.recorder = rec
+	// a replay always opens with the full state
+	gs.recorder.Keyframe(gs.keyframe())
+	logging.Main.Info("recording match", "room", gs.id, "seed", gs.seed, "part", gs.recordingPart, "file", rec.Path())
+}
+
+// finishes the current recording if there is one. the file is written out in the
+// background, shutdown waits for it. caller must hold the lock.
+func (gs *GameState) stopRecording() {
+	rec := gs.recorder
+	if rec == nil {
+		return
+	}
+	gs.recorder = nil
+	rec.Close()
+	gs.recordings.Add(1)
+	go func() {
+		defer gs.recordings.Done()
+		if err := rec.Wait(); err != nil {
+			logging.Main.Error("writing replay failed", "room", gs.id, "file", rec.Path(), "err", err)
+		} else {
+			logging.Main.Info("match recorded", "room", gs.id, "file", rec.Path(), "bytes", rec.Size())
+		}
+	}()
+}
+
+// the same snapshot a spectator joining right now would get
+func (gs *GameState) keyframe() Message {
+	return Message{
+		Type:     "state_sync",
+		Seq:      gs.seq,
+		Spectate: true,
+		State:    gs.snapshot(false),
+	}
+}
+
+// closes out the tick in the recording, with a keyframe about once a second for seeking.
+// nothing is kept while the room is empty, and a recording that's grown too big or too
+// long is closed and the match carries on in a new one. caller must hold the lock.
+func (gs *GameState) recordTick(now time.Time) {
+	if gs.recorder == nil {
+		return
+	}
+	if !gs.occupied() {
+		gs.recorder.Discard()
+		gs.recordingIdle = true
+		return
+	}
+	// the first tick back after being empty gets a keyframe, the frames in between are gone
+	if gs.recordingIdle || gs.seq%uint64(gs.cfg.TickRate) == 0 {
+		gs.recorder.Keyframe(gs.keyframe())
+		gs.recordingIdle = false
+	}
+	gs.recorder.EndTick(gs.seq, now)
+	if gs.recorder.Size() >= gs.replayMaxSize || now.Sub(gs.recorder.Started()) >= gs.replayMaxDuration {
+		gs.stopRecording()
+		gs.openRecording(now)
+	}
+}
+
+// logs an input the server acted on. caller must hold the lock.
+func (gs *GameState) recordInput(p *game.Player, message Message) {
+	if gs.recorder == nil {
+		return
+	}
+	message.PlayerID = p.ID
+	message.Seq = gs.seq
+	gs.recorder.Input(message)
+}
+
+// lists the recorded matches
+func handleReplayList(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		replays, err := replay.List(dir)
+		if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if replays == nil {
+			replays = []replay.Info{}
+		}
+		server.WriteJSON(w, http.StatusOK, replays)
+	}
+}
+
+// replays being watched right now
+var replayViewers = make(chan struct{}, 16)
+
+// streams a recorded match over a websocket. the client gets the same messages a
+// spectator got during the match and can send replay.Control messages to change
+// speed (0.5x to 4x), pause, play and seek.
+func handleReplayViewer(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := replay.Path(dir, r.PathValue("id"))
+		if err != nil {
+			server.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		// each viewer decompresses the file as it plays, so there's a limit on how many at once
+		select {
+		case replayViewers <- struct{}{}:
+			defer func() { <-replayViewers }()
+		default:
+			server.WriteError(w, http.StatusServiceUnavailable, errors.New("too many replay viewers, try again later"))
+			return
+		}
+		rp, err := replay.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			server.WriteError(w, http.StatusNotFound, errors.New("no such replay"))
+			return
+		} else if err != nil {
+			server.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logging.Net.Warn("websocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		defer conn.Close()
+
+		done := make(chan struct{})
+		defer close(done)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+			return nil
+		})
+		go pingLoop(conn, done)
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		// the reader only feeds controls to the player, the player goroutine is the only writer
+		controls := make(chan replay.Control)
+		go func() {
+			defer cancel()
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.SetReadDeadline(time.Now().Add(pongWait))
+				var c replay.Control
+				if err := json.Unmarshal(data, &c); err != nil {
+					continue
+				}
+				select {
+				case controls <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		send := func(data []byte) error {
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			return conn.WriteMessage(websocket.TextMessage, data)
+		}
+		logging.Net.Info("replay viewer connected", "replay", r.PathValue("id"), "remote", server.ClientIP(r))
+		err = replay.Play(ctx, rp, send, controls)
+		logging.Net.Info("replay viewer left", "replay", r.PathValue("id"), "err", err)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"time"
+)
+
+// everything a client needs to rebuild the match from scratch.
+// sent as a single state_sync message on join and on every reconnect so
+// there is exactly one code path for "catch the client up".
+type StateSync struct {
+	Resumed    bool              `json:"resumed"`
+	Players    []PlayerState     `json:"players"`
+	Bullets    []BulletState     `json:"bullets"`
+	Weapons    []PickupState     `json:"weapons"`
+	PowerUps   []PickupState     `json:"powerups"`
+	Scoreboard []ScoreboardEntry `json:"scoreboard"`
+	Chat       []Message         `json:"chat,omitempty"` // recent chat the client can see, oldest first
+}
+
+// authoritative view of a single player. timers are remaining milliseconds.
+type PlayerState struct {
+	PlayerID          string        `json:"player_id"`
+	Username          string        `json:"username"`
+	Color             int           `json:"color"`
+	Sprite            string        `json:"sprite"`
+	Team              string        `json:"team,omitempty"`
+	Position          game.Position `json:"position"`
+	Health            int           `json:"health"`
+	Weapon            string        `json:"weapon"`
+	Inventory         []string      `json:"inventory"`
+	Slot              int           `json:"slot"` // active slot, 1 or 2
+	IsDead            bool          `json:"is_dead"`
+	DeathTime         int64         `json:"death_time,omitempty"`
+	RespawnIn         int64         `json:"respawn_in,omitempty"`
+	Shield            int           `json:"shield,omitempty"`
+	TeleportAvailable bool          `json:"teleportAvailable,omitempty"`
+	ForceFieldActive  bool          `json:"forceFieldActive,omitempty"`
+	HealthRegenActive bool          `json:"healthRegenActive,omitempty"`
+	RegenRemaining    int64         `json:"regen_remaining,omitempty"`
+	Effects           []EffectState `json:"effects,omitempty"`
+}
+
+type BulletState struct {
+	BulletID string        `json:"bullet_id"`
+	PlayerID string        `json:"player_id"`
+	Position game.Position `json:"position"`
+	Rotation float64       `json:"rotation"`
+	Speed    float64       `json:"speed"`
+	Lifetime float64       `json:"lifetime"`
+}
+
+// weapons and powerups on the ground
+type PickupState struct {
+	ID        string        `json:"id"`
+	Type      string        `json:"type"`
+	Position  game.Position `json:"position"`
+	ExpiresIn int64         `json:"expires_in"`
+}
+
+// remaining time clamped at zero, in milliseconds
+func remainingMillis(d time.Duration) int64 {
+	if d < 0 {
+		return 0
+	}
+	return d.Milliseconds()
+}
+
+// builds the full state for a (re)connecting client. caller must hold the lock.
+func (gs *GameState) snapshot(resumed bool) *StateSync {
+	state := &StateSync{
+		Resumed:    resumed,
+		Players:    make([]PlayerState, 0, len(gs.players)),
+		Bullets:    make([]BulletState, 0, len(gs.bullets)),
+		Weapons:    make([]PickupState, 0, len(gs.weapons)),
+		PowerUps:   make([]PickupState, 0, len(gs.powerups)),
+		Scoreboard: gs.scoreboard(),
+	}
+	for _, p := range gs.players {
+		ps := PlayerState{
+			PlayerID:          p.ID,
+			Username:          p.Username,
+			Color:             p.Color,
+			Sprite:            p.Sprite,
+			Team:              p.Team,
+			Position:          p.Position,
+			Health:            p.Health,
+			Weapon:            p.Weapon,
+			Inventory:         inventoryOf(p),
+			Slot:              slotNumber(p.ActiveSlot),
+			IsDead:            p.IsDead,
+			Shield:            p.Shield,
+			TeleportAvailable: p.TeleportAvailable,
+			ForceFieldActive:  p.ForceFieldActive,
+			HealthRegenActive: p.HealthRegenActive,
+		}
+		if p.IsDead {
+			ps.DeathTime = p.DeathTime.Unix()
+			ps.RespawnIn = remainingMillis(gs.cfg.RespawnDelay.Duration() - time.Since(p.DeathTime))
+		}
+		if e, ok := p.Effects[effectRegen]; ok {
+			ps.RegenRemaining = e.Remaining(time.Now()).Milliseconds()
+		}
+		ps.Effects = effectStates(p, time.Now())
+		state.Players = append(state.Players, ps)
+	}
+	for _, b := range gs.bullets {
+		state.Bullets = append(state.Bullets, BulletState{
+			BulletID: b.ID,
+			PlayerID: b.PlayerID,
+			Position: b.Position,
+			Rotation: b.Rotation,
+			Speed:    b.Speed,
+			Lifetime: b.Lifetime,
+		})
+	}
+	for _, w := range gs.weapons {
+		state.Weapons = append(state.Weapons, PickupState{
+			ID:        w.ID,
+			Type:      w.Type,
+			Position:  w.Position,
+			ExpiresIn: remainingMillis(gs.cfg.WeaponDespawn.Duration() - time.Since(w.SpawnTime)),
+		})
+	}
+	for _, pu := range gs.powerups {
+		state.PowerUps = append(state.PowerUps, PickupState{
+			ID:        pu.ID,
+			Type:      pu.Type,
+			Position:  pu.Position,
+			ExpiresIn: remainingMillis(gs.cfg.PowerUpDespawn.Duration() - time.Since(pu.SpawnTime)),
+		})
+	}
+	return state
+}
+
+// (re)starts the respawn timer based on when the player died, so a reconnect
+// picks up exactly where the countdown left off. caller must hold the lock.
+func scheduleRespawn(gameState *GameState, p *game.Player) {
+	if p.DeathTimer != nil {
+		p.DeathTimer.Stop()
+	}
+	remaining := gameState.cfg.RespawnDelay.Duration() - time.Since(p.DeathTime)
+	if remaining < 0 {
+		remaining = 0
+	}
+	p.DeathTimer = time.AfterFunc(remaining, func() {
+		respawnPlayer(gameState, p)
+	})
+}
+
+// starts the grace window for a dropped player. if they come back before it
+// runs out resumeSession cancels it, otherwise removePlayer cleans them up.
+// caller must hold the lock.
+func (gs *GameState) scheduleRemoval(p *game.Player) {
+	if p.DisconnectTimer != nil {
+		p.DisconnectTimer.Stop()
+	}
+	id := p.ID
+	p.DisconnectTimer = time.AfterFunc(gs.cfg.ReconnectGrace.Duration(), func() {
+		gs.removePlayer(id)
+	})
+}
+
+// hands an existing player over to a new connection. caller must hold the lock.
+func (gs *GameState) resumeSession(p *game.Player, conn *game.Conn, lastSeq uint64) {
+	// close any existing connection can't have two connections for one player!
+	if p.Conn != nil {
+		p.Conn.Close()
+	}
+	if p.DisconnectTimer != nil {
+		p.DisconnectTimer.Stop()
+		p.DisconnectTimer = nil
+	}
+	p.Conn = conn
+
+	// Sanity check position
+	if !isValidPosition(p.Position) {
+		p.Position = game.Position{X: 500, Y: 300}
+	}
+	// the respawn countdown keeps running while they're gone
+	if p.IsDead {
+		scheduleRespawn(gs, p)
+	}
+	gs.logEvent(p, models.EventReconnect, models.ReconnectDetails{LastSeq: lastSeq, Dead: p.IsDead}, time.Now())
+	logging.Net.Info("session resumed", "room", gs.id, "player", p.ID,
+		"position", p.Position, "last_seq", lastSeq, "seq", gs.seq)
+}
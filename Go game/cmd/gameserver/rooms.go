@@ -0,0 +1,183 @@
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/metrics"
+	"arena-tactics/internal/telemetry"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errTooManyRooms = errors.New("too many rooms")
+
+// room names end up in urls and logs so keep them boring
+var validRoomName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// keeps track of every running room. each room is its own GameState with its own
+// settings (base config + that room's overrides) and its own set of loops.
+type RoomManager struct {
+	cfg       *config.Config
+	store     database.Store
+	telemetry *telemetry.Writer
+	ctx       context.Context
+	loops     *sync.WaitGroup
+	mu        sync.Mutex
+	rooms     map[string]*GameState
+}
+
+func newRoomManager(ctx context.Context, cfg *config.Config, store database.Store, telemetry *telemetry.Writer, loops *sync.WaitGroup) *RoomManager {
+	return &RoomManager{
+		cfg:       cfg,
+		store:     store,
+		telemetry: telemetry,
+		ctx:       ctx,
+		loops:     loops,
+		rooms:     make(map[string]*GameState),
+	}
+}
+
+// returns the room with that name, starting it first if it isn't running yet.
+// an empty name means the default room.
+func (rm *RoomManager) get(name string) (*GameState, error) {
+	if name == "" {
+		name = rm.cfg.DefaultRoom
+	}
+	if !validRoomName.MatchString(name) {
+		return nil, fmt.Errorf("invalid room name %q", name)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if room, ok := rm.rooms[name]; ok {
+		return room, nil
+	}
+	if len(rm.rooms) >= rm.cfg.MaxRooms {
+		return nil, errTooManyRooms
+	}
+
+	room := newGameState(name, rm.cfg.Room(name), rm.store)
+	room.permanent = rm.permanent(name)
+	room.replayDir = rm.cfg.ReplayDir
+	room.replayMaxSize = rm.cfg.ReplayMaxSize
+	room.replayMaxDuration = rm.cfg.ReplayMaxDuration.Duration()
+	room.telemetry = rm.telemetry
+	room.beginMatch()
+	rm.rooms[name] = room
+	metrics.Rooms.Set(float64(len(rm.rooms)))
+	rm.start(room)
+	logging.Main.Info("room started", "room", name, "tick_rate", room.cfg.TickRate)
+	return room, nil
+}
+
+// the room with that name if it's running, unlike get it never starts one
+func (rm *RoomManager) lookup(name string) *GameState {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.rooms[name]
+}
+
+// every room sorted by name
+func (rm *RoomManager) all() []*GameState {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rooms := make([]*GameState, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].id < rooms[j].id })
+	return rooms
+}
+
+// the default room and rooms with their own config section are never closed
+func (rm *RoomManager) permanent(name string) bool {
+	return name == rm.cfg.DefaultRoom || slices.Contains(rm.cfg.RoomNames(), name)
+}
+
+// kicks off all the loops a room needs, they stop when the server context is cancelled
+// or the room is closed
+func (rm *RoomManager) start(room *GameState) {
+	ctx, cancel := context.WithCancel(rm.ctx)
+	room.stop = cancel
+	goWithContext(ctx, rm.loops, func(ctx context.Context) {
+		runTickLoop(ctx, room)
+	})
+	goWithContext(ctx, rm.loops, func(ctx context.Context) {
+		runWeaponSpawner(ctx, room)
+	})
+	// scoreboard with pings goes out on its own timer
+	goWithContext(ctx, rm.loops, func(ctx context.Context) {
+		scoreboardLoop(ctx, room)
+	})
+	goWithContext(ctx, rm.loops, func(ctx context.Context) {
+		runPowerUpSpawner(ctx, room)
+	})
+}
+
+// anyone in the room apart from bots, the ones in their reconnect grace window count.
+// caller must hold the lock.
+func (gs *GameState) occupied() bool {
+	if len(gs.spectators) > 0 {
+		return true
+	}
+	for _, p := range gs.players {
+		if !p.IsBot {
+			return true
+		}
+	}
+	return false
+}
+
+// closes the rooms players made that have been empty for room_idle_timeout, otherwise
+// anyone could fill max_rooms with ?room=<anything> for good
+func (rm *RoomManager) reap(now time.Time) {
+	var closed []*GameState
+	rm.mu.Lock()
+	for name, room := range rm.rooms {
+		if room.permanent {
+			continue
+		}
+		// under both locks so nobody can join between the check and the room going away
+		room.mutex.Lock()
+		switch {
+		case room.occupied():
+			room.emptySince = time.Time{}
+		case room.emptySince.IsZero():
+			room.emptySince = now
+		case now.Sub(room.emptySince) >= rm.cfg.RoomIdleTimeout.Duration():
+			room.closed = true
+			delete(rm.rooms, name)
+			closed = append(closed, room)
+		}
+		room.mutex.Unlock()
+	}
+	metrics.Rooms.Set(float64(len(rm.rooms)))
+	rm.mu.Unlock()
+
+	for _, room := range closed {
+		room.stop()
+		room.endMatch()
+		logging.Main.Info("room closed", "room", room.id, "idle", now.Sub(room.emptySince))
+	}
+}
+
+// checks for idle rooms until the server shuts down
+func runRoomReaper(ctx context.Context, rm *RoomManager) {
+	ticker := time.NewTicker(min(time.Minute, rm.cfg.RoomIdleTimeout.Duration()/2))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			rm.reap(now)
+		}
+	}
+}
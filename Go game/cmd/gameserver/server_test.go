@@ -0,0 +1,645 @@
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/telemetry"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMain(m *testing.M) {
+	// the server logs every join and death, only show it when something is really wrong
+	logging.Setup(io.Discard, "text", "error")
+	os.Exit(m.Run())
+}
+
+// a whole server (routes, rooms and their loops) on an httptest listener, backed by the in-memory store
+type testServer struct {
+	srv       *httptest.Server
+	rooms     *RoomManager
+	store     *database.Memory
+	positions *telemetry.Writer
+	loops     *sync.WaitGroup
+	cancel    context.CancelFunc // what SIGTERM does in main
+}
+
+func newTestServer(t *testing.T, tweak func(*config.Game)) *testServer {
+	t.Helper()
+	return newTestServerWith(t, func(cfg *config.Config) {
+		if tweak != nil {
+			tweak(&cfg.Game)
+		}
+	})
+}
+
+// the same for tests that need the server wide settings too
+func newTestServerWith(t *testing.T, tweak func(*config.Config)) *testServer {
+	t.Helper()
+	cfg := config.Defaults()
+	cfg.ReplayDir = ""
+	cfg.StaticDir = t.TempDir()
+	// nothing on the map unless a test puts it there
+	cfg.Game.MaxWeapons = 0
+	cfg.Game.MaxPowerUps = 0
+	cfg.Game.Seed = 1
+	tweak(cfg)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var loops sync.WaitGroup
+	store := database.NewMemory()
+	positions := telemetry.NewWriter(store, telemetry.Options{Buffer: 1000, BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	ts := &testServer{rooms: newRoomManager(ctx, cfg, store, positions, &loops), store: store, positions: positions, loops: &loops, cancel: cancel}
+	ts.srv = httptest.NewServer(routes(cfg, ts.rooms))
+	t.Cleanup(func() {
+		ts.srv.CloseClientConnections()
+		ts.srv.Close()
+		cancel()
+		loops.Wait()
+		positions.Close(context.Background())
+	})
+	return ts
+}
+
+// the default room, started if nobody has joined yet
+func (ts *testServer) room(t *testing.T) *GameState {
+	t.Helper()
+	room, err := ts.rooms.get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return room
+}
+
+// runs f on a player under the room lock
+func (ts *testServer) withPlayer(t *testing.T, id string, f func(gs *GameState, p *game.Player)) {
+	t.Helper()
+	room := ts.room(t)
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+	p, ok := room.players[id]
+	if !ok {
+		t.Fatalf("player %s isn't in the room", id)
+	}
+	f(room, p)
+}
+
+type testClient struct {
+	t        *testing.T
+	conn     *websocket.Conn
+	playerID string
+	sync     Message
+}
+
+// connects with the given session id and waits for the state_sync
+func (ts *testServer) join(t *testing.T, sessionID string) *testClient {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.srv.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c := &testClient{t: t, conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	c.send(Message{Type: "session_init", SessionID: sessionID})
+	c.sync = c.expect("state_sync", nil)
+	c.playerID = c.sync.PlayerID
+	if c.playerID == "" {
+		t.Fatal("state_sync without a player id")
+	}
+	return c
+}
+
+func (c *testClient) send(m Message) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(m); err != nil {
+		c.t.Fatalf("send %s: %v", m.Type, err)
+	}
+}
+
+// reads until a message of that type (that match accepts, when given) shows up
+func (c *testClient) expect(msgType string, match func(Message) bool) Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		var m Message
+		if err := json.Unmarshal(data, &m); err != nil {
+			c.t.Fatalf("bad message %s: %v", data, err)
+		}
+		if m.Type == msgType && (match == nil || match(m)) {
+			return m
+		}
+	}
+}
+
+// puts a player somewhere with a known amount of health
+func place(p *game.Player, x, y float64, health int) {
+	p.Position = game.Position{X: x, Y: y}
+	p.LastKnownPosition = p.Position
+	p.Health = health
+}
+
+func TestJoin(t *testing.T) {
+	ts := newTestServer(t, nil)
+	alice := ts.join(t, "session-alice")
+	bob := ts.join(t, "session-bob")
+
+	if alice.playerID == bob.playerID {
+		t.Fatalf("both players got id %s", alice.playerID)
+	}
+	if bob.sync.State == nil || len(bob.sync.State.Players) != 2 {
+		t.Fatalf("second player should see both players in the state_sync, got %+v", bob.sync.State)
+	}
+	if bob.sync.State.Resumed {
+		t.Error("a first join shouldn't be a resume")
+	}
+
+	// both got a row, and the room knows them by the store's ids
+	p, _ := ts.store.GetOrCreatePlayer("session-alice")
+	if p.ID != alice.playerID {
+		t.Errorf("store has alice as %s, the room as %s", p.ID, alice.playerID)
+	}
+	ts.withPlayer(t, bob.playerID, func(_ *GameState, p *game.Player) {
+		if p.Weapon != "pistol" || p.Health != 100 {
+			t.Errorf("new players start with a pistol and full health, got %s and %d", p.Weapon, p.Health)
+		}
+	})
+}
+
+// keyframes are encoded on the recorder's goroutine, they mustn't share anything the tick
+// goes on to change. run with -race.
+func TestRecordWhileSwappingWeapons(t *testing.T) {
+	ts := newTestServerWith(t, func(cfg *config.Config) {
+		cfg.ReplayDir = t.TempDir()
+		cfg.Game.TickRate = 60
+	})
+	c := ts.join(t, "session-hoarder")
+	ts.withPlayer(t, c.playerID, func(gs *GameState, p *game.Player) {
+		gs.placeWeapon("shotgun", game.WeaponHeat{}, p.Position, time.Now())
+	})
+	// a keyframe a second, so this spans a couple of them
+	for deadline := time.Now().Add(1500 * time.Millisecond); time.Now().Before(deadline); {
+		c.send(Message{Type: "pickup_weapon"})
+		c.expect("weapon_pickup", nil)
+		c.send(Message{Type: "drop_weapon"})
+		c.expect("weapon_drop", nil)
+	}
+}
+
+func TestShootKillAndRespawn(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) {
+		g.RespawnDelay = config.Duration(200 * time.Millisecond)
+	})
+	shooter := ts.join(t, "session-shooter")
+	victim := ts.join(t, "session-victim")
+
+	// side by side, one pistol shot from dead
+	ts.withPlayer(t, shooter.playerID, func(_ *GameState, p *game.Player) { place(p, 200, 300, 100) })
+	ts.withPlayer(t, victim.playerID, func(_ *GameState, p *game.Player) { place(p, 300, 300, 10) })
+	shooter.send(Message{Type: "shoot", Rotation: 0})
+
+	hit := victim.expect("damage", func(m Message) bool { return m.PlayerID == victim.playerID })
+	if hit.AttackerID != shooter.playerID {
+		t.Errorf("damage credited to %s, want %s", hit.AttackerID, shooter.playerID)
+	}
+	death := victim.expect("player_death", func(m Message) bool { return m.PlayerID == victim.playerID })
+	if death.KillerID != shooter.playerID {
+		t.Errorf("death credited to %s, want %s", death.KillerID, shooter.playerID)
+	}
+
+	// the kill and death made it to the store, once the writer's flushed
+	var kills, deaths int
+	deadline := time.Now().Add(2 * time.Second)
+	for (kills != 1 || deaths != 1) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		p, _ := ts.store.GetOrCreatePlayer("session-shooter")
+		kills = p.Kills
+		p, _ = ts.store.GetOrCreatePlayer("session-victim")
+		deaths = p.Deaths
+	}
+	if kills != 1 || deaths != 1 {
+		t.Errorf("shooter has %d kills and victim %d deaths stored, want 1 and 1", kills, deaths)
+	}
+
+	respawn := victim.expect("player_respawn", func(m Message) bool { return m.PlayerID == victim.playerID })
+	if respawn.Health != 100 || respawn.Weapon != "pistol" {
+		t.Errorf("respawned with %d health and a %s", respawn.Health, respawn.Weapon)
+	}
+	ts.withPlayer(t, victim.playerID, func(_ *GameState, p *game.Player) {
+		if p.IsDead {
+			t.Error("victim is still dead after respawning")
+		}
+	})
+}
+
+func TestEventTimeline(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) {
+		g.RespawnDelay = config.Duration(100 * time.Millisecond)
+	})
+	shooter := ts.join(t, "session-shooter")
+	victim := ts.join(t, "session-victim")
+	ts.withPlayer(t, shooter.playerID, func(_ *GameState, p *game.Player) { place(p, 200, 300, 100) })
+	ts.withPlayer(t, victim.playerID, func(gs *GameState, p *game.Player) {
+		place(p, 300, 300, 10)
+		gs.powerups["pu-1"] = &PowerUp{ID: "pu-1", Type: effectSpeedBoost, Position: p.Position, SpawnTime: time.Now()}
+	})
+	victim.expect("powerup_pickup", func(m Message) bool { return m.PlayerID == victim.playerID })
+	shooter.send(Message{Type: "shoot", Rotation: 0})
+	victim.expect("player_respawn", func(m Message) bool { return m.PlayerID == victim.playerID })
+
+	// the writer flushes every 20ms
+	want := []models.EventType{models.EventSpawn, models.EventPickup, models.EventPowerUpUse, models.EventDeath, models.EventSpawn}
+	var events []models.Event
+	deadline := time.Now().Add(2 * time.Second)
+	for len(events) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		events, _ = ts.store.Timeline("session-victim", 0)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("victim has %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Errorf("event %d is %s, want %s", i, e.Type, want[i])
+		}
+	}
+	// the death remembers the powerup they lost with it
+	var death models.DeathDetails
+	if err := json.Unmarshal(events[3].Details, &death); err != nil {
+		t.Fatal(err)
+	}
+	if death.KillerID != shooter.playerID || len(death.Effects) != 1 || death.Effects[0] != effectSpeedBoost {
+		t.Errorf("death details are %+v", death)
+	}
+	if pickups := ts.store.Pickups(); len(pickups) != 1 || pickups[0].SessionID != "session-victim" {
+		t.Errorf("pickups are %+v, want the victim's one", pickups)
+	}
+	if kills, _ := ts.store.Timeline("session-shooter", 0); len(kills) != 2 || kills[1].Type != models.EventKill {
+		t.Errorf("shooter's timeline is %+v, want a spawn and a kill", kills)
+	}
+}
+
+func TestProfileUpdate(t *testing.T) {
+	ts := newTestServer(t, nil)
+	alice := ts.join(t, "session-alice")
+	bob := ts.join(t, "session-bob")
+
+	name, color, sprite := "Alice", 0x00FF00, "mech"
+	alice.send(Message{Type: "profile_update", Profile: &ProfileUpdate{Name: &name, Color: &color, Sprite: &sprite}})
+	seen := bob.expect("profile_update", func(m Message) bool { return m.PlayerID == alice.playerID })
+	if seen.Username != "Alice" || seen.Color != 0x00FF00 || seen.Sprite != "mech" {
+		t.Errorf("bob saw alice as %q %06x %q", seen.Username, seen.Color, seen.Sprite)
+	}
+
+	// names are unique whatever the case, and only the one asking hears about it
+	taken := "alice"
+	bob.send(Message{Type: "profile_update", Profile: &ProfileUpdate{Name: &taken}})
+	if m := bob.expect("profile_error", nil); !strings.Contains(m.Reason, "taken") {
+		t.Errorf("taking alice's name gave %q", m.Reason)
+	}
+
+	// the http endpoint does the same, and shows up in the game straight away
+	body := strings.NewReader(`{"session_id": "session-bob", "name": "Robert", "sprite": "ship"}`)
+	req, _ := http.NewRequest(http.MethodPut, ts.srv.URL+"/profile", body)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /profile gave %d", res.StatusCode)
+	}
+	alice.expect("profile_update", func(m Message) bool { return m.PlayerID == bob.playerID && m.Username == "Robert" })
+
+	req, _ = http.NewRequest(http.MethodPut, ts.srv.URL+"/profile", strings.NewReader(`{"session_id": "session-bob", "name": "ALICE"}`))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("taking a name over http gave %d, want 409", res.StatusCode)
+	}
+
+	// it's kept per session, so it's back on the next join
+	if p, _ := ts.store.GetProfile("session-alice"); p == nil || p.DisplayName != "Alice" || p.Sprite != "mech" {
+		t.Errorf("stored profile is %+v", p)
+	}
+}
+
+func TestChat(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) {
+		g.ChatBurst = 2
+		g.ChatInterval = config.Duration(time.Hour)
+		g.ChatBlockedWords = config.Words{"noob"}
+	})
+	alice := ts.join(t, "session-alice")
+	bob := ts.join(t, "session-bob")
+
+	alice.send(Message{Type: "chat", Text: "gg you noob"})
+	if m := bob.expect("chat", nil); m.Text != "gg you ****" || m.Scope != chatRoom || m.PlayerID != alice.playerID {
+		t.Errorf("bob got %+v", m)
+	}
+	alice.send(Message{Type: "chat", Scope: chatWhisper, Target: bob.playerID, Text: "psst"})
+	bob.expect("chat", func(m Message) bool { return m.Scope == chatWhisper && m.Text == "psst" })
+	alice.expect("chat", func(m Message) bool { return m.Scope == chatWhisper && m.Target == bob.playerID })
+
+	// the burst is used up and the allowance won't refill for an hour
+	alice.send(Message{Type: "chat", Text: "one more"})
+	if m := alice.expect("chat_error", nil); !strings.Contains(m.Reason, "too fast") {
+		t.Errorf("going over the rate limit gave %q", m.Reason)
+	}
+	bob.send(Message{Type: "chat", Scope: chatTeam, Text: "anyone?"})
+	if m := bob.expect("chat_error", nil); !strings.Contains(m.Reason, "no teams") {
+		t.Errorf("team chat without a team gave %q", m.Reason)
+	}
+
+	// late joiners get the room chat they missed but not other people's whispers.
+	// mutes are looked up on join
+	if err := ts.store.AddMute(&database.Mute{SessionID: "session-carol", Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	carol := ts.join(t, "session-carol")
+	if history := carol.sync.State.Chat; len(history) != 1 || history[0].Text != "gg you ****" {
+		t.Errorf("carol's history is %+v", history)
+	}
+	carol.send(Message{Type: "chat", Text: "hi"})
+	if m := carol.expect("chat_error", nil); m.Reason != "you're muted" {
+		t.Errorf("muted player got %q", m.Reason)
+	}
+
+	bob.send(Message{Type: "chat", QuickChat: "gg"})
+	carol.expect("chat", func(m Message) bool { return m.PlayerID == bob.playerID && m.Text == "Good game!" })
+}
+
+func TestTeamChat(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) {
+		g.Teams = config.Words{"red", "blue"}
+		g.ChatBurst = 10
+	})
+	alice := ts.join(t, "session-alice")
+	bob := ts.join(t, "session-bob")
+	carol := ts.join(t, "session-carol")
+	for _, c := range []struct {
+		client *testClient
+		team   string
+	}{{alice, "red"}, {bob, "blue"}, {carol, "red"}} {
+		ts.withPlayer(t, c.client.playerID, func(gs *GameState, p *game.Player) {
+			if p.Team != c.team {
+				t.Errorf("%s is on %q, want %q", p.SessionID, p.Team, c.team)
+			}
+		})
+	}
+
+	// bob's next chat has to be the room message, the team one would come first if it leaked
+	alice.send(Message{Type: "chat", Scope: chatTeam, Text: "flank left"})
+	alice.send(Message{Type: "chat", Text: "gl hf"})
+	if m := carol.expect("chat", nil); m.Text != "flank left" || m.Scope != chatTeam {
+		t.Errorf("carol got %+v", m)
+	}
+	if m := bob.expect("chat", nil); m.Text != "gl hf" {
+		t.Errorf("bob got %+v", m)
+	}
+
+	carol.send(Message{Type: "chat", Scope: chatTeam, Text: "on it"})
+	carol.send(Message{Type: "chat", Text: "gl"})
+	alice.expect("chat", func(m Message) bool { return m.Text == "on it" && m.Scope == chatTeam })
+	if m := bob.expect("chat", nil); m.Text != "gl" {
+		t.Errorf("bob got %+v", m)
+	}
+
+	// the history a late joiner gets only has their own team's messages
+	dave := ts.join(t, "session-dave")
+	for _, m := range dave.sync.State.Chat {
+		if m.Scope == chatTeam {
+			t.Errorf("dave, on blue, got red's %q", m.Text)
+		}
+	}
+}
+
+// ordinary words that have something blocked inside or across them go through either way
+func TestChatFilter(t *testing.T) {
+	for _, mode := range []string{config.ChatFilterMask, config.ChatFilterBlock} {
+		t.Run(mode, func(t *testing.T) {
+			ts := newTestServer(t, func(g *config.Game) {
+				g.ChatFilter = mode
+				g.ChatBurst = 10
+			})
+			alice := ts.join(t, "session-alice")
+			bob := ts.join(t, "session-bob")
+
+			for _, text := range []string{"Push It", "this hit was lucky", "who really won", "greetings from Nigeria", "swanky hat"} {
+				alice.send(Message{Type: "chat", Text: text})
+				if m := bob.expect("chat", nil); m.Text != text {
+					t.Errorf("%q came through as %q", text, m.Text)
+				}
+			}
+
+			alice.send(Message{Type: "chat", Text: "oh sh1t sorry"})
+			if mode == config.ChatFilterMask {
+				if m := bob.expect("chat", nil); m.Text != "oh **** sorry" {
+					t.Errorf("masked message came through as %q", m.Text)
+				}
+				return
+			}
+			if m := alice.expect("chat_error", nil); !strings.Contains(m.Reason, "isn't allowed") {
+				t.Errorf("blocked message gave %q", m.Reason)
+			}
+			// nothing reached bob, the next thing he hears is this
+			alice.send(Message{Type: "chat", Text: "sorry"})
+			if m := bob.expect("chat", nil); m.Text != "sorry" {
+				t.Errorf("bob got %q after the blocked message", m.Text)
+			}
+		})
+	}
+}
+
+// there are only three spawn points, the rest of the bots have to go somewhere
+func TestMoreBotsThanSpawnPoints(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) { g.BotFill = 6 })
+	room := ts.room(t)
+	bots := make(chan int)
+	go func() {
+		for {
+			room.mutex.RLock()
+			n := len(room.bots)
+			room.mutex.RUnlock()
+			if n == 6 {
+				bots <- n
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-bots:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the room never filled up with bots")
+	}
+}
+
+func TestEffectCooldown(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) {
+		g.InvisibilityCooldown = config.Duration(10 * time.Second)
+	})
+	c := ts.join(t, "session-sneaky")
+	ts.withPlayer(t, c.playerID, func(gs *GameState, p *game.Player) {
+		now := time.Now()
+		if !gs.applyEffect(p, effectInvisibility, now) {
+			t.Fatal("invisibility didn't take the first time")
+		}
+		// shooting gives them away, which starts the cooldown
+		gs.removeEffect(p, effectInvisibility, "attacked", now)
+		if gs.applyEffect(p, effectInvisibility, now.Add(9*time.Second)) {
+			t.Error("invisibility went back on during its cooldown")
+		}
+		if !gs.applyEffect(p, effectInvisibility, now.Add(10*time.Second)) {
+			t.Error("invisibility was still refused after its cooldown")
+		}
+	})
+}
+
+func TestShutdownEndsMatch(t *testing.T) {
+	ts := newTestServer(t, nil)
+	c := ts.join(t, "session-stayer")
+	ts.withPlayer(t, c.playerID, func(gs *GameState, p *game.Player) { gs.statsFor(p).ShotsFired = 3 })
+	room := ts.room(t)
+	// the match's row is written by the telemetry writer, it gets its id shortly after
+	var id int64
+	for deadline := time.Now().Add(2 * time.Second); id == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		room.mutex.RLock()
+		id = room.matchID()
+		room.mutex.RUnlock()
+	}
+	if id == 0 {
+		t.Fatal("the match never got an id")
+	}
+
+	ts.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx, ts.srv.Config, ts.rooms.all(), ts.store, ts.positions, ts.loops); err != nil {
+		t.Fatal(err)
+	}
+
+	if end := c.expect("match_end", nil); len(end.Stats) != 1 || end.Stats[0].ShotsFired != 3 {
+		t.Errorf("match_end stats are %+v", end.Stats)
+	}
+	c.expect("server_shutdown", nil)
+	if m := ts.store.Match(id); m == nil || m.EndedAt == nil {
+		t.Errorf("match %d is %+v after shutdown, want it ended", id, m)
+	}
+}
+
+func TestReconnectResumes(t *testing.T) {
+	ts := newTestServer(t, nil)
+	first := ts.join(t, "session-returning")
+	ts.withPlayer(t, first.playerID, func(_ *GameState, p *game.Player) {
+		place(p, 123, 456, 42)
+		p.Kills = 3
+	})
+	first.conn.Close()
+
+	// same session inside the grace window picks the same player back up
+	second := ts.join(t, "session-returning")
+	if second.playerID != first.playerID {
+		t.Fatalf("came back as %s, was %s", second.playerID, first.playerID)
+	}
+	if !second.sync.State.Resumed {
+		t.Error("state_sync should say the session was resumed")
+	}
+	var me *PlayerState
+	for i := range second.sync.State.Players {
+		if second.sync.State.Players[i].PlayerID == second.playerID {
+			me = &second.sync.State.Players[i]
+		}
+	}
+	if me == nil {
+		t.Fatal("resumed player missing from the state_sync")
+	}
+	if me.Health != 42 || me.Position != (game.Position{X: 123, Y: 456}) {
+		t.Errorf("resumed with %d health at %v, want 42 at {123 456}", me.Health, me.Position)
+	}
+}
+
+func TestDisconnectRemovesAfterGrace(t *testing.T) {
+	ts := newTestServer(t, func(g *config.Game) {
+		g.ReconnectGrace = config.Duration(100 * time.Millisecond)
+	})
+	c := ts.join(t, "session-leaving")
+	c.send(Message{Type: "position", Position: game.Position{X: 250, Y: 250}})
+	c.conn.Close()
+
+	room := ts.room(t)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		room.mutex.RLock()
+		_, still := room.players[c.playerID]
+		room.mutex.RUnlock()
+		if !still {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("player still in the room after the grace window")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// their rows went with them, so the same session starts over
+	if pos, _ := ts.store.GetLastKnownPosition("session-leaving"); pos != nil {
+		t.Errorf("last known position %v survived the removal", pos)
+	}
+	again := ts.join(t, "session-leaving")
+	if again.sync.State.Resumed {
+		t.Error("joining after the grace window shouldn't resume")
+	}
+}
+
+func TestIdleRoomsClose(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.room(t)
+	side, err := ts.rooms.get("side")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle := ts.rooms.cfg.RoomIdleTimeout.Duration()
+
+	// the first pass only notices the room is empty
+	now := time.Now()
+	ts.rooms.reap(now)
+	if ts.rooms.lookup("side") == nil {
+		t.Fatal("closed a room the moment it was empty")
+	}
+	ts.rooms.reap(now.Add(idle))
+	if ts.rooms.lookup("side") != nil {
+		t.Error("empty room still running after room_idle_timeout")
+	}
+	if ts.rooms.lookup(ts.rooms.cfg.DefaultRoom) == nil {
+		t.Error("the default room was closed")
+	}
+	side.mutex.RLock()
+	defer side.mutex.RUnlock()
+	if !side.closed || side.matchActive {
+		t.Error("closed room should be marked closed with its match over")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/telemetry"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// runs f in a goroutine tracked by wg so shutdown can wait for it
+func goWithContext(ctx context.Context, wg *sync.WaitGroup, f func(context.Context)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f(ctx)
+	}()
+}
+
+// waits for wg but gives up when ctx runs out
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tears the server down in order once the signal context is cancelled:
+//  1. stop accepting new http/websocket connections
+//  2. end the matches and tell everyone we're going away (the client reconnects to whichever
+//     pod is up next)
+//  3. wait for the game goroutines to stop
+//  4. drain every connection
+//  5. write out the queued telemetry, then everyone's last known position for the next pod
+//
+// everything is bounded by ctx so a stuck client or db can't hold up the rollout.
+func shutdown(ctx context.Context, srv *http.Server, rooms []*GameState, store database.Store, positions *telemetry.Writer, loops *sync.WaitGroup) error {
+	if err := srv.Shutdown(ctx); err != nil {
+		logging.Main.Error("http server shutdown failed", "err", err)
+	}
+
+	var conns []*game.Conn
+	for _, gameState := range rooms {
+		gameState.mutex.Lock()
+		// the final stats go out and the match's row gets its end before the writer's closed
+		if gameState.matchActive {
+			gameState.wrapUpMatch()
+		}
+		gameState.broadcast(Message{
+			Type:   "server_shutdown",
+			Reason: "server restarting",
+		})
+		for _, p := range gameState.players {
+			if p.Conn != nil {
+				conns = append(conns, p.Conn)
+			}
+		}
+		for _, s := range gameState.spectators {
+			conns = append(conns, s.Conn)
+		}
+		gameState.mutex.Unlock()
+	}
+
+	if err := waitContext(ctx, loops); err != nil {
+		return errors.New("game loops did not stop in time")
+	}
+
+	// with the tick loops gone nothing else writes to the replays
+	for _, gameState := range rooms {
+		gameState.mutex.Lock()
+		gameState.recorder.EndTick(gameState.seq, time.Now())
+		gameState.stopRecording()
+		gameState.mutex.Unlock()
+	}
+	for _, gameState := range rooms {
+		if err := waitContext(ctx, &gameState.recordings); err != nil {
+			return errors.New("replays were not written out in time")
+		}
+	}
+
+	// leave whatever time is left for the clients to get their last messages
+	drainTimeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		drainTimeout = time.Until(deadline)
+	}
+	var drained sync.WaitGroup
+	for _, c := range conns {
+		drained.Add(1)
+		go func(c *game.Conn) {
+			defer drained.Done()
+			c.Drain(websocket.CloseGoingAway, "server restarting", drainTimeout)
+		}(c)
+	}
+	if err := waitContext(ctx, &drained); err != nil {
+		return errors.New("connections did not drain in time")
+	}
+
+	// telemetry first, its queue is older than where players are now
+	if err := positions.Close(ctx); err != nil {
+		return errors.New("telemetry writer did not finish in time")
+	}
+	for _, gameState := range rooms {
+		saveLastKnownPositions(gameState)
+	}
+	return store.Close()
+}
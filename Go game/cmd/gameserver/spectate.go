@@ -0,0 +1,121 @@
+package main
+
+import (
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/metrics"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// someone watching a room without playing in it. they get every broadcast the
+// players get but never spawn, never show up on the scoreboard and don't take a slot.
+type Spectator struct {
+	ID     string
+	Conn   *game.Conn
+	Target string // player the camera follows, empty for a free camera
+}
+
+func (s *Spectator) send(msg Message) error {
+	metrics.MessagesOut.WithLabelValues(msg.Type).Inc()
+	return s.Conn.SendJSON(msg)
+}
+
+// spectator ids don't come from the room's rng, someone starting to watch mustn't change
+// what the simulation draws next or the match wouldn't replay the same from its seed
+func newSpectatorID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "spectator-" + hex.EncodeToString(b)
+}
+
+// target if there's a player by that id, otherwise "" (free camera). caller must hold the lock.
+func (gs *GameState) followable(target string) string {
+	if _, ok := gs.players[target]; ok {
+		return target
+	}
+	return ""
+}
+
+// anyone following a player who just left goes back to the free camera. caller must hold the lock.
+func (gs *GameState) releaseFollowers(playerID string) {
+	for _, s := range gs.spectators {
+		if s.Target == playerID {
+			s.Target = ""
+			s.send(Message{Type: "spectate_target", Seq: gs.seq})
+		}
+	}
+}
+
+// runs a spectator connection from the snapshot to the disconnect.
+// spectators can only send {"type":"spectate","target":"<player id>"} to switch who they follow
+// and {"type":"scoreboard_request"}.
+func handleSpectator(gameState *GameState, conn *websocket.Conn, client *game.Conn, target string, done <-chan struct{}) {
+	gameState.mutex.Lock()
+	if gameState.closed {
+		gameState.mutex.Unlock()
+		client.SendJSON(Message{Type: "room_closed", Reason: "the room was closed, join again"})
+		client.Drain(websocket.CloseTryAgainLater, "room closed", writeWait)
+		return
+	}
+	s := &Spectator{ID: newSpectatorID(), Conn: client}
+	s.Target = gameState.followable(target)
+	gameState.spectators[s.ID] = s
+	state := gameState.snapshot(false)
+	state.Chat = gameState.chatHistory(nil)
+	err := s.send(Message{
+		Type:     "state_sync",
+		Seq:      gameState.seq,
+		Spectate: true,
+		Target:   s.Target,
+		State:    state,
+	})
+	gameState.mutex.Unlock()
+
+	defer func() {
+		gameState.mutex.Lock()
+		delete(gameState.spectators, s.ID)
+		gameState.mutex.Unlock()
+	}()
+	if err != nil {
+		logging.Net.Warn("sending state_sync failed", "room", gameState.id, "spectator", s.ID, "err", err)
+		return
+	}
+	logging.Net.Info("spectator joined", "room", gameState.id, "spectator", s.ID, "target", s.Target)
+
+	// no rtt to track for spectators, pongs just keep the connection alive
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+	go pingLoop(conn, done)
+
+	for {
+		_, messageBytes, err := conn.ReadMessage()
+		if err != nil {
+			logging.Net.Info("spectator left", "room", gameState.id, "spectator", s.ID, "err", err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var message Message
+		if err := json.Unmarshal(messageBytes, &message); err != nil {
+			continue
+		}
+		metrics.MessagesIn.WithLabelValues(inboundLabel(message.Type)).Inc()
+		gameState.mutex.Lock()
+		switch message.Type {
+		case "spectate":
+			s.Target = gameState.followable(message.Target)
+			s.send(Message{Type: "spectate_target", Seq: gameState.seq, Target: s.Target})
+		case "scoreboard_request":
+			s.send(gameState.scoreboardMessage())
+		}
+		gameState.mutex.Unlock()
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+// the team with the fewest players so joins keep the sides even, ties go to the one
+// listed first. "" when the room is free for all. caller must hold the lock.
+func (gs *GameState) smallestTeam() string {
+	if len(gs.cfg.Teams) == 0 {
+		return ""
+	}
+	sizes := make(map[string]int, len(gs.cfg.Teams))
+	for _, p := range gs.players {
+		sizes[p.Team]++
+	}
+	best := gs.cfg.Teams[0]
+	for _, team := range gs.cfg.Teams[1:] {
+		if sizes[team] < sizes[best] {
+			best = team
+		}
+	}
+	return best
+}
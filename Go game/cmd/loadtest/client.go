@@ -0,0 +1,291 @@
+package main
+
+import (
+	"arena-tactics/internal/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	pingPeriod  = time.Second // how often we measure the round trip
+	writeWait   = 5 * time.Second
+	closeWait   = time.Second // how long we wait for the server to answer our close
+	moveSpeed   = 300.0       // px per second, same as the browser
+	echoHistory = 128         // positions we remember while waiting for the server to send them back
+)
+
+type position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// the handful of fields the load test sends or reads, the server ignores the rest
+type message struct {
+	Type      string   `json:"type"`
+	SessionID string   `json:"session_id,omitempty"`
+	PlayerID  string   `json:"player_id,omitempty"`
+	Position  position `json:"position,omitempty"`
+	Rotation  float64  `json:"rotation,omitempty"`
+	Seq       uint64   `json:"seq,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+// one simulated player. it wanders around the map sending positions at the browser's
+// rate and shooting in random directions, and keeps its own measurements so clients
+// never contend on a lock while the test runs.
+type client struct {
+	id        int
+	sessionID string
+	room      string
+	opts      options
+	rng       *rand.Rand
+
+	mu       sync.Mutex
+	playerID string
+	sent     map[position]time.Time // positions on their way to the server, for the echo latency
+
+	// only the read loop touches these until the run is over
+	lastSeq     uint64
+	lastSeqAt   time.Time
+	tickGaps    []float64 // ms between ticks as this client saw them
+	echoes      []float64 // ms from sending a position to it coming back in a position_update
+	rtts        []float64 // ms websocket ping round trips
+	received    int
+	missedTicks uint64 // seq numbers skipped, updates the server coalesced or dropped
+
+	connected bool
+	dialErr   error
+	dropped   string // why the server let go of us early, "" if it didn't, guarded by mu
+	sentCount atomic.Int64
+}
+
+func newClient(id int, run, room string, opts options) *client {
+	return &client{
+		id:        id,
+		sessionID: fmt.Sprintf("loadtest-%s-%05d", run, id),
+		room:      room,
+		opts:      opts,
+		rng:       rand.New(rand.NewSource(int64(id) + time.Now().UnixNano())),
+		sent:      make(map[position]time.Time),
+	}
+}
+
+// connects and plays until ctx is done or the server drops us
+func (c *client) run(ctx context.Context, connected *atomic.Int64) {
+	u, _ := url.Parse(c.opts.url)
+	q := u.Query()
+	q.Set("room", c.room)
+	u.RawQuery = q.Encode()
+
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	conn, _, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.dialErr = err
+			logging.Net.Warn("dial failed", "client", c.id, "err", err)
+		}
+		return
+	}
+	defer conn.Close()
+	c.connected = true
+	connected.Add(1)
+	defer connected.Add(-1)
+
+	// the reader is the only one who knows when the server is done with us
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		c.readLoop(ctx, conn)
+	}()
+
+	// writes all happen here so there's only ever one writer
+	var writeErr error
+	write := func(m message) {
+		if writeErr != nil {
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if writeErr = conn.WriteJSON(m); writeErr == nil {
+			c.sentCount.Add(1)
+		}
+	}
+	write(message{Type: "session_init", SessionID: c.sessionID})
+
+	move := time.NewTicker(time.Duration(float64(time.Second) / c.opts.moveRate))
+	defer move.Stop()
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	var shoot <-chan time.Time
+	if c.opts.shootRate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / c.opts.shootRate))
+		defer t.Stop()
+		shoot = t.C
+	}
+
+	pos := position{X: 50 + c.rng.Float64()*900, Y: 50 + c.rng.Float64()*500}
+	goal := pos
+	last := time.Now()
+	for writeErr == nil {
+		select {
+		case now := <-move.C:
+			// walk to a random spot, pick another when we get there
+			dx, dy := goal.X-pos.X, goal.Y-pos.Y
+			dist := math.Hypot(dx, dy)
+			if dist < 5 {
+				goal = position{X: 50 + c.rng.Float64()*900, Y: 50 + c.rng.Float64()*500}
+				continue
+			}
+			step := min(dist, moveSpeed*now.Sub(last).Seconds())
+			last = now
+			pos = position{X: pos.X + dx/dist*step, Y: pos.Y + dy/dist*step}
+			c.sentPosition(pos, now)
+			write(message{Type: "position", Position: pos})
+		case <-shoot:
+			write(message{Type: "shoot", Rotation: c.rng.Float64() * 2 * math.Pi})
+		case now := <-ping.C:
+			// the server answers pings from its read loop, so a slow room shows up here too
+			payload := []byte(strconv.FormatInt(now.UnixNano(), 10))
+			if err := conn.WriteControl(websocket.PingMessage, payload, now.Add(writeWait)); err != nil {
+				writeErr = err
+			}
+		case <-readDone:
+			return
+		case <-ctx.Done():
+			// a clean goodbye, so the server doesn't count us as a dropped connection
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "load test over"), time.Now().Add(writeWait))
+			conn.SetReadDeadline(time.Now().Add(closeWait))
+			<-readDone
+			return
+		}
+	}
+	if ctx.Err() == nil && c.drop("write failed") {
+		logging.Net.Warn("write failed", "client", c.id, "err", writeErr)
+	}
+	conn.Close()
+	<-readDone
+}
+
+func (c *client) readLoop(ctx context.Context, conn *websocket.Conn) {
+	conn.SetPongHandler(func(appData string) error {
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			c.rtts = append(c.rtts, ms(time.Since(time.Unix(0, sent))))
+		}
+		return nil
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if reason := dropReason(err); ctx.Err() == nil && c.drop(reason) {
+				logging.Net.Warn("dropped by server", "client", c.id, "reason", reason, "err", err)
+			}
+			return
+		}
+		now := time.Now()
+		c.received++
+
+		var m message
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		c.observeSeq(m.Seq, now)
+
+		switch m.Type {
+		case "state_sync":
+			c.mu.Lock()
+			c.playerID = m.PlayerID
+			c.mu.Unlock()
+		case "position_update":
+			c.observeEcho(m, now)
+		case "room_full", "banned":
+			c.drop(m.Type)
+		}
+	}
+}
+
+// remembers the first reason we were let go, false if there already was one
+func (c *client) drop(reason string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped != "" {
+		return false
+	}
+	c.dropped = reason
+	return true
+}
+
+// every tick's broadcasts carry its seq, the gap between the first message of one
+// tick and the first of the next is how steady the tick loop is from out here
+func (c *client) observeSeq(seq uint64, now time.Time) {
+	if seq == 0 || seq <= c.lastSeq {
+		return
+	}
+	if c.lastSeq != 0 {
+		ticks := seq - c.lastSeq
+		c.tickGaps = append(c.tickGaps, ms(now.Sub(c.lastSeqAt))/float64(ticks))
+		c.missedTicks += ticks - 1
+	}
+	c.lastSeq, c.lastSeqAt = seq, now
+}
+
+func (c *client) sentPosition(pos position, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.sent) >= echoHistory {
+		// the server coalesces updates, anything this old isn't coming back
+		for p, at := range c.sent {
+			if now.Sub(at) > time.Second {
+				delete(c.sent, p)
+			}
+		}
+	}
+	c.sent[pos] = now
+}
+
+// our own position coming back. collisions nudge players so not every one matches,
+// the ones that don't are skipped rather than guessed at.
+func (c *client) observeEcho(m message, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m.PlayerID == "" || m.PlayerID != c.playerID {
+		return
+	}
+	at, ok := c.sent[m.Position]
+	if !ok {
+		return
+	}
+	c.echoes = append(c.echoes, ms(now.Sub(at)))
+	// anything sent before this one was overwritten by it on the server
+	for p, t := range c.sent {
+		if !t.After(at) {
+			delete(c.sent, p)
+		}
+	}
+}
+
+// turns a read error into something short enough to count by
+func dropReason(err error) string {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		if ce.Text != "" {
+			return fmt.Sprintf("closed %d (%s)", ce.Code, ce.Text)
+		}
+		return fmt.Sprintf("closed %d", ce.Code)
+	}
+	return "connection lost"
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,183 @@
+// loadtest points a swarm of headless clients at a game server and reports how it held up:
+// how steady the tick rate stayed, how long inputs took to come back, how many clients got
+// dropped and how much cpu the server burned doing it. we use it to size the deployment
+// and to catch regressions in broadcast and the tick loop.
+//
+//	go run ./cmd/loadtest -url ws://localhost:8080/ws -clients 200 -rooms 4 -duration 2m
+package main
+
+import (
+	"arena-tactics/internal/logging"
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	url        string
+	metricsURL string
+	clients    int
+	rooms      int
+	ramp       time.Duration
+	duration   time.Duration
+	moveRate   float64
+	shootRate  float64
+	logLevels  string
+}
+
+func parseFlags(args []string) (options, error) {
+	var o options
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.StringVar(&o.url, "url", "ws://localhost:8080/ws", "game server websocket endpoint")
+	fs.StringVar(&o.metricsURL, "metrics-url", "", "server prometheus endpoint for cpu and tick timings (default: /metrics next to -url, \"off\" to skip)")
+	fs.IntVar(&o.clients, "clients", 50, "simulated clients")
+	fs.IntVar(&o.rooms, "rooms", 1, "spread the clients over this many rooms (load-0, load-1...)")
+	fs.DurationVar(&o.ramp, "ramp", 10*time.Second, "time over which the clients connect")
+	fs.DurationVar(&o.duration, "duration", time.Minute, "how long to run once everyone has connected")
+	fs.Float64Var(&o.moveRate, "move-rate", 60, "position messages per second per client (the browser sends one a frame)")
+	fs.Float64Var(&o.shootRate, "shoot-rate", 3, "shots per second per client")
+	fs.StringVar(&o.logLevels, "log-level", "info", "log levels, same syntax as the server's")
+	if err := fs.Parse(args); err != nil {
+		return o, err
+	}
+
+	if o.clients < 1 {
+		return o, fmt.Errorf("clients must be at least 1")
+	}
+	if o.rooms < 1 {
+		return o, fmt.Errorf("rooms must be at least 1")
+	}
+	if o.moveRate <= 0 || o.shootRate < 0 {
+		return o, fmt.Errorf("move-rate must be positive and shoot-rate non-negative")
+	}
+	if o.ramp < 0 || o.duration <= 0 {
+		return o, fmt.Errorf("ramp can't be negative and duration must be positive")
+	}
+	u, err := url.Parse(o.url)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return o, fmt.Errorf("url must be a ws:// or wss:// address")
+	}
+	if o.metricsURL == "" {
+		scheme := "http"
+		if u.Scheme == "wss" {
+			scheme = "https"
+		}
+		o.metricsURL = (&url.URL{Scheme: scheme, Host: u.Host, Path: "/metrics"}).String()
+	}
+	return o, nil
+}
+
+func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := logging.Setup(os.Stderr, "text", opts.logLevels); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// ctrl+c stops early but still prints the report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// every run gets its own session ids so it never resumes players from the last one
+	run := strconv.FormatInt(time.Now().Unix(), 36)
+
+	var server *scrape
+	if opts.metricsURL != "off" {
+		server, err = scrapeMetrics(ctx, opts.metricsURL)
+		if err != nil {
+			logging.Main.Warn("can't read server metrics, cpu and tick timings won't be reported", "url", opts.metricsURL, "err", err)
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.ramp+opts.duration)
+	defer cancel()
+
+	clients := make([]*client, opts.clients)
+	var connected atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	logging.Main.Info("starting load test", "url", opts.url, "clients", opts.clients, "rooms", opts.rooms,
+		"ramp", opts.ramp, "duration", opts.duration)
+
+	// clients come in evenly over the ramp so the server sees a realistic join rate
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range clients {
+			if runCtx.Err() != nil {
+				return
+			}
+			c := newClient(i, run, fmt.Sprintf("load-%d", i%opts.rooms), opts)
+			clients[i] = c
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.run(runCtx, &connected)
+			}()
+			if opts.clients > 1 {
+				select {
+				case <-time.After(opts.ramp / time.Duration(opts.clients-1)):
+				case <-runCtx.Done():
+				}
+			}
+		}
+	}()
+
+	// progress every few seconds so a long run isn't silent, the server's cpu over each
+	// interval gives the peak alongside the average
+	progress := time.NewTicker(5 * time.Second)
+	defer progress.Stop()
+	var peakCPU float64
+	last := server
+	for done := false; !done; {
+		select {
+		case <-progress.C:
+			args := []any{"elapsed", time.Since(start).Round(time.Second), "connected", connected.Load()}
+			if last != nil {
+				if now, err := scrapeMetrics(runCtx, opts.metricsURL); err == nil {
+					cpu := now.since(last).cpu
+					peakCPU = max(peakCPU, cpu)
+					args = append(args, "server_cpu", fmt.Sprintf("%.0f%%", cpu*100))
+					last = now
+				}
+			}
+			logging.Main.Info("running", args...)
+		case <-runCtx.Done():
+			done = true
+		}
+	}
+	// the run is over once the clock runs out, the clients hanging up isn't part of it
+	elapsed := time.Since(start)
+	var after *scrape
+	if server != nil {
+		// fresh context, the run's is already cancelled
+		after, err = scrapeMetrics(context.Background(), opts.metricsURL)
+		if err != nil {
+			logging.Main.Warn("can't read server metrics after the run", "url", opts.metricsURL, "err", err)
+		} else {
+			peakCPU = max(peakCPU, after.since(last).cpu)
+		}
+	}
+	wg.Wait()
+
+	r := buildReport(clients, elapsed)
+	if server != nil && after != nil {
+		r.server = after.since(server)
+		r.server.peakCPU = peakCPU
+	}
+	r.print(os.Stdout)
+}
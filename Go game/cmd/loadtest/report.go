@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// everything the clients measured, merged once the run is over
+type report struct {
+	elapsed     time.Duration
+	clients     int
+	connected   int
+	dialFailed  int
+	dropped     map[string]int // reason -> clients
+	sent        int64
+	received    int
+	missedTicks uint64
+	tickGaps    []float64
+	echoes      []float64
+	rtts        []float64
+	server      *serverStats // nil when the metrics endpoint couldn't be read
+}
+
+// only call once every client has returned
+func buildReport(clients []*client, elapsed time.Duration) *report {
+	r := &report{elapsed: elapsed, dropped: make(map[string]int)}
+	for _, c := range clients {
+		if c == nil {
+			// stopped before the ramp got to it
+			continue
+		}
+		r.clients++
+		if c.dialErr != nil {
+			r.dialFailed++
+		}
+		if !c.connected {
+			continue
+		}
+		r.connected++
+		if c.dropped != "" {
+			r.dropped[c.dropped]++
+		}
+		r.sent += c.sentCount.Load()
+		r.received += c.received
+		r.missedTicks += c.missedTicks
+		r.tickGaps = append(r.tickGaps, c.tickGaps...)
+		r.echoes = append(r.echoes, c.echoes...)
+		r.rtts = append(r.rtts, c.rtts...)
+	}
+	return r
+}
+
+func (r *report) print(w io.Writer) {
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "\nload test, %s\n", r.elapsed.Round(time.Second))
+	fmt.Fprintf(w, "  clients      %d started, %d connected, %d failed to connect\n", r.clients, r.connected, r.dialFailed)
+
+	droppedTotal := 0
+	reasons := make([]string, 0, len(r.dropped))
+	for reason, n := range r.dropped {
+		droppedTotal += n
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, n))
+	}
+	sort.Strings(reasons)
+	fmt.Fprintf(w, "  dropped      %d", droppedTotal)
+	if len(reasons) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(reasons, ", "))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  messages     %d sent (%.0f/s), %d received (%.0f/s)\n", r.sent, float64(r.sent)/secs, r.received, float64(r.received)/secs)
+
+	// ticks as the clients saw them arrive, jitter here is the tick loop or broadcast falling behind
+	gaps := summarize(r.tickGaps)
+	fmt.Fprintf(w, "\ntick interval (ms, client side)\n")
+	if gaps.n == 0 {
+		fmt.Fprintf(w, "  no ticks observed\n")
+	} else {
+		fmt.Fprintf(w, "  %s\n", gaps)
+		fmt.Fprintf(w, "  rate         %.1f ticks/s, stddev %.2fms, %d ticks skipped\n", 1000/gaps.mean, gaps.stddev, r.missedTicks)
+	}
+
+	fmt.Fprintf(w, "\nlatency (ms)\n")
+	fmt.Fprintf(w, "  input echo   %s\n", summarize(r.echoes))
+	fmt.Fprintf(w, "  ping rtt     %s\n", summarize(r.rtts))
+
+	fmt.Fprintf(w, "\nserver\n")
+	if s := r.server; s == nil {
+		fmt.Fprintf(w, "  metrics unavailable\n")
+	} else {
+		fmt.Fprintf(w, "  cpu          %.0f%% average, %.0f%% peak (100%% is one core)\n", s.cpu*100, s.peakCPU*100)
+		fmt.Fprintf(w, "  memory       %.0f MiB resident\n", s.memory/(1<<20))
+		fmt.Fprintf(w, "  ticks        %.0f run, %s average, %.0f over budget\n", s.ticks, s.tickMean.Round(time.Microsecond), s.overruns)
+		fmt.Fprintf(w, "  send queues  %.0f updates dropped, %.0f slow clients disconnected\n", s.droppedUpdates, s.slowDisconnects)
+	}
+}
+
+type summary struct {
+	n                          int
+	mean, stddev               float64
+	p50, p90, p99, p999, worst float64
+}
+
+func summarize(samples []float64) summary {
+	s := summary{n: len(samples)}
+	if s.n == 0 {
+		return s
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	s.mean = sum / float64(s.n)
+	var sq float64
+	for _, v := range sorted {
+		sq += (v - s.mean) * (v - s.mean)
+	}
+	s.stddev = math.Sqrt(sq / float64(s.n))
+	// nearest rank
+	at := func(p float64) float64 {
+		return sorted[min(s.n-1, int(math.Ceil(p*float64(s.n)))-1)]
+	}
+	s.p50, s.p90, s.p99, s.p999 = at(0.5), at(0.9), at(0.99), at(0.999)
+	s.worst = sorted[s.n-1]
+	return s
+}
+
+func (s summary) String() string {
+	if s.n == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %.2f  p90 %.2f  p99 %.2f  p99.9 %.2f  max %.2f  (mean %.2f, %d samples)",
+		s.p50, s.p90, s.p99, s.p999, s.worst, s.mean, s.n)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the server's prometheus endpoint at one moment, every metric summed over its labels.
+// rooms are labels, so tick numbers come out as totals across every room.
+type scrape struct {
+	at     time.Time
+	values map[string]float64
+}
+
+func scrapeMetrics(ctx context.Context, url string) (*scrape, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics returned %s", resp.Status)
+	}
+
+	s := &scrape{at: time.Now(), values: make(map[string]float64)}
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		line := lines.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// name{labels} value, the labels can have spaces in them so skip past the brace
+		name, rest := line, ""
+		if i := strings.IndexByte(line, '{'); i >= 0 {
+			name = line[:i]
+			if j := strings.LastIndexByte(line, '}'); j > i {
+				rest = line[j+1:]
+			}
+		} else if i := strings.IndexByte(line, ' '); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		s.values[name] += v
+	}
+	return s, lines.Err()
+}
+
+// what the server did between two scrapes
+type serverStats struct {
+	window          time.Duration
+	cpu             float64 // cores busy on average
+	peakCPU         float64 // busiest progress interval, 0 if the run was too short to have one
+	memory          float64 // resident bytes at the end
+	ticks           float64
+	tickMean        time.Duration
+	overruns        float64
+	droppedUpdates  float64
+	slowDisconnects float64
+}
+
+func (s *scrape) since(before *scrape) *serverStats {
+	delta := func(name string) float64 { return s.values[name] - before.values[name] }
+	out := &serverStats{
+		window:          s.at.Sub(before.at),
+		memory:          s.values["process_resident_memory_bytes"],
+		ticks:           delta("arena_tick_duration_seconds_count"),
+		overruns:        delta("arena_tick_overruns_total"),
+		droppedUpdates:  delta("arena_dropped_updates_total"),
+		slowDisconnects: delta("arena_slow_client_disconnects_total"),
+	}
+	if out.window > 0 {
+		out.cpu = delta("process_cpu_seconds_total") / out.window.Seconds()
+	}
+	if out.ticks > 0 {
+		out.tickMean = time.Duration(delta("arena_tick_duration_seconds_sum") / out.ticks * float64(time.Second))
+	}
+	return out
+}
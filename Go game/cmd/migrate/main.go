@@ -0,0 +1,138 @@
+// migrate moves the game server's database between schema versions. it reads the same
+// configuration as the server (config file, GAME_* env, DB_* credentials, flags), so whatever
+// database the server would use is the one it migrates.
+//
+//	go run ./cmd/migrate status -db-driver sqlite -db-path arena.db
+//	go run ./cmd/migrate up            apply every pending migration
+//	go run ./cmd/migrate down          roll back the newest one
+//	go run ./cmd/migrate to 3          apply or roll back until the database is at version 3
+//
+// servers apply pending migrations on startup by themselves. run this instead (with
+// -db-migrate=false on the servers) when a migration should go out before the code does,
+// or to roll one back.
+package main
+
+import (
+	"arena-tactics/internal/config"
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/logging"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+)
+
+const usage = `usage: migrate <command> [server flags]
+
+commands:
+  status       list migrations and which ones are applied
+  up           apply every pending migration
+  down         roll back the newest applied migration
+  to VERSION   apply or roll back until the database is at VERSION (0 undoes everything)
+
+the database comes from the server's settings (-db-driver, -db-path, the DB_* env vars...),
+"migrate status -h" lists them all.
+`
+
+func main() {
+	err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("no command given")
+	}
+	command, args := args[0], args[1:]
+	target := 0
+	switch command {
+	case "status", "up", "down":
+	case "to":
+		if len(args) == 0 {
+			return fmt.Errorf("to needs a version")
+		}
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 0 {
+			return fmt.Errorf("version must be a number, 0 or more, got %q", args[0])
+		}
+		target, args = v, args[1:]
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stderr, usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+	if err := logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevels); err != nil {
+		return err
+	}
+
+	// ctrl+c stops between migrations (and cancels the query in flight)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := database.Open(cfg.DBDriver, cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	migrator, err := store.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		err = migrator.To(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+	return printStatus(ctx, migrator)
+}
+
+func printStatus(ctx context.Context, migrator *database.Migrator) error {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	version, pending := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			version = s.Version
+		} else {
+			pending++
+		}
+		if s.Unknown {
+			applied += " (not in this build)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	w.Flush()
+	fmt.Printf("\ndatabase is at version %d, %d pending\n", version, pending)
+	return nil
+}
@@ -0,0 +1,623 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Duration is a time.Duration that reads and writes as "5s", "500ms" etc
+// in json and flags instead of a raw nanosecond count.
+type Duration time.Duration
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+// Duration converts back to a plain time.Duration
+func (d Duration) Duration() time.Duration { return time.Duration(d) }
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %v", err)
+	}
+	return d.Set(s)
+}
+
+// killstreak rewards a player can earn
+const (
+	RewardForceField = "force_field" // a full shield, same as the powerup
+	RewardTeleport   = "teleport"    // one teleport, same as the powerup
+	RewardRadar      = "radar"       // every enemy shows on the minimap for radar_duration
+)
+
+var rewards = map[string]bool{RewardForceField: true, RewardTeleport: true, RewardRadar: true}
+
+// BotSkill is how well a bot plays
+type BotSkill struct {
+	Reaction Duration `json:"reaction"` // how long after spotting someone before they start shooting
+	Spread   float64  `json:"spread"`   // worst aim error in radians, either side of the target
+	Range    float64  `json:"range"`    // how far away they notice enemies
+}
+
+// BotDifficulties are the presets bot_difficulty picks from
+var BotDifficulties = map[string]BotSkill{
+	"easy":   {Reaction: Duration(700 * time.Millisecond), Spread: 0.3, Range: 350},
+	"normal": {Reaction: Duration(400 * time.Millisecond), Spread: 0.15, Range: 450},
+	"hard":   {Reaction: Duration(200 * time.Millisecond), Spread: 0.06, Range: 600},
+}
+
+// BotSkill is the difficulty preset with any reaction or spread override applied
+func (g Game) BotSkill() BotSkill {
+	skill := BotDifficulties[g.BotDifficulty]
+	if g.BotReaction > 0 {
+		skill.Reaction = g.BotReaction
+	}
+	if g.BotSpread > 0 {
+		skill.Spread = g.BotSpread
+	}
+	return skill
+}
+
+// Killstreak grants Reward once a player gets Kills kills without dying
+type Killstreak struct {
+	Kills  int    `json:"kills"`
+	Reward string `json:"reward"`
+}
+
+// Killstreaks reads as "3=force_field,5=radar" on the command line and in the environment
+type Killstreaks []Killstreak
+
+func (k Killstreaks) String() string {
+	parts := make([]string, len(k))
+	for i, ks := range k {
+		parts[i] = fmt.Sprintf("%d=%s", ks.Kills, ks.Reward)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (k *Killstreaks) Set(s string) error {
+	var out Killstreaks
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kills, reward, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(kills)
+		if !ok || err != nil {
+			return fmt.Errorf("killstreak %q should look like 3=force_field", part)
+		}
+		out = append(out, Killstreak{Kills: n, Reward: reward})
+	}
+	*k = out
+	return nil
+}
+
+// rooms and live setting patches decode over a copy of the base settings, which shares
+// the base slice. always decode into a fresh one so an override can't rewrite the base.
+func (k *Killstreaks) UnmarshalJSON(b []byte) error {
+	var out []Killstreak
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	*k = out
+	return nil
+}
+
+// chat_filter settings
+const (
+	ChatFilterMask  = "mask"  // blocked words are starred out
+	ChatFilterBlock = "block" // messages with a blocked word aren't sent at all
+	ChatFilterOff   = "off"
+)
+
+// Words reads as "foo,bar" on the command line and in the environment
+type Words []string
+
+func (w Words) String() string {
+	return strings.Join(w, ",")
+}
+
+func (w *Words) Set(s string) error {
+	var out Words
+	for _, word := range strings.Split(s, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			out = append(out, word)
+		}
+	}
+	*w = out
+	return nil
+}
+
+// a fresh slice for the same reason as Killstreaks
+func (w *Words) UnmarshalJSON(b []byte) error {
+	var out []string
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	*w = out
+	return nil
+}
+
+// Game holds everything that tunes a single room's simulation.
+// every room starts from the base Game section and can override any of it.
+type Game struct {
+	TickRate             int         `json:"tick_rate"`             // simulation updates per second
+	MaxHealth            int         `json:"max_health"`            // spawn health and the regen cap
+	MaxPlayers           int         `json:"max_players"`           // combatants per room, spectators don't count
+	RespawnDelay         Duration    `json:"respawn_delay"`         // time spent dead before respawning
+	ReconnectGrace       Duration    `json:"reconnect_grace"`       // how long a dropped player is kept for a reconnect
+	MaxWeapons           int         `json:"max_weapons"`           // weapon pickups on the map at once
+	MaxPowerUps          int         `json:"max_powerups"`          // powerup pickups on the map at once
+	WeaponSpawnInterval  Duration    `json:"weapon_spawn_interval"` // how often we try to spawn a weapon
+	PowerUpSpawnMin      Duration    `json:"powerup_spawn_min"`     // powerups spawn at a random interval in [min, max)
+	PowerUpSpawnMax      Duration    `json:"powerup_spawn_max"`
+	WeaponDespawn        Duration    `json:"weapon_despawn"`       // unclaimed weapons disappear after this
+	WeaponSwitchDelay    Duration    `json:"weapon_switch_delay"`  // no firing for this long after switching weapons
+	PowerUpDespawn       Duration    `json:"powerup_despawn"`      // unclaimed powerups disappear after this
+	ShieldStrength       int         `json:"shield_strength"`      // damage the force field soaks up
+	ForceFieldCooldown   Duration    `json:"force_field_cooldown"` // no new force field for this long after one breaks
+	RegenRate            float64     `json:"regen_rate"`           // health per second while regen is active
+	RegenDuration        Duration    `json:"regen_duration"`       // how long the regen powerup lasts
+	RegenCooldown        Duration    `json:"regen_cooldown"`       // no new regen for this long after it runs out
+	TeleportCooldown     Duration    `json:"teleport_cooldown"`    // time between two teleports
+	SpeedBoostDuration   Duration    `json:"speed_boost_duration"`
+	SpeedBoostFactor     float64     `json:"speed_boost_factor"` // movement speed multiplier while boosted
+	SpeedBoostCooldown   Duration    `json:"speed_boost_cooldown"`
+	DamageAmpDuration    Duration    `json:"damage_amp_duration"`
+	DamageAmpFactor      float64     `json:"damage_amp_factor"` // damage multiplier per stack
+	DamageAmpMaxStacks   int         `json:"damage_amp_max_stacks"`
+	DamageAmpCooldown    Duration    `json:"damage_amp_cooldown"`
+	InvisibilityDuration Duration    `json:"invisibility_duration"` // ends early when they shoot
+	InvisibilityCooldown Duration    `json:"invisibility_cooldown"`
+	Seed                 int64       `json:"seed"`               // seeds the room's rng for every match, 0 picks a new one each time
+	Killstreaks          Killstreaks `json:"killstreaks"`        // rewards for kills without dying
+	RadarDuration        Duration    `json:"radar_duration"`     // how long the radar reward lasts
+	MultiKillWindow      Duration    `json:"multikill_window"`   // kills this close together chain into a multi kill
+	BotFill              int         `json:"bot_fill"`           // bots top the room up to this many players, 0 for no bots
+	BotDifficulty        string      `json:"bot_difficulty"`     // easy, normal or hard
+	BotReaction          Duration    `json:"bot_reaction"`       // overrides the difficulty's reaction time when set
+	BotSpread            float64     `json:"bot_spread"`         // overrides the difficulty's aim spread (radians) when set
+	ChatMaxLength        int         `json:"chat_max_length"`    // characters in one chat message
+	ChatBurst            int         `json:"chat_burst"`         // messages a player can send back to back
+	ChatInterval         Duration    `json:"chat_interval"`      // after a burst, one more message per interval
+	ChatHistory          int         `json:"chat_history"`       // messages kept for players who join late or reconnect, 0 keeps none
+	ChatFilter           string      `json:"chat_filter"`        // mask, block or off
+	ChatBlockedWords     Words       `json:"chat_blocked_words"` // blocked on top of the built in list
+	Teams                Words       `json:"teams"`              // team names, players are split evenly between them. empty for free for all
+}
+
+// TickInterval is the time between two simulation ticks
+func (g Game) TickInterval() time.Duration {
+	return time.Second / time.Duration(g.TickRate)
+}
+
+// Validate catches settings that would break the simulation
+func (g Game) Validate() error {
+	var errs []error
+	if g.TickRate < 1 || g.TickRate > 240 {
+		errs = append(errs, fmt.Errorf("tick_rate must be between 1 and 240, got %d", g.TickRate))
+	}
+	if g.MaxHealth < 1 {
+		errs = append(errs, fmt.Errorf("max_health must be positive, got %d", g.MaxHealth))
+	}
+	if g.MaxPlayers < 1 {
+		errs = append(errs, fmt.Errorf("max_players must be at least 1, got %d", g.MaxPlayers))
+	}
+	if g.MaxWeapons < 0 || g.MaxPowerUps < 0 {
+		errs = append(errs, errors.New("max_weapons and max_powerups can't be negative"))
+	}
+	if g.WeaponSpawnInterval <= 0 {
+		errs = append(errs, errors.New("weapon_spawn_interval must be positive"))
+	}
+	if g.PowerUpSpawnMin <= 0 || g.PowerUpSpawnMax < g.PowerUpSpawnMin {
+		errs = append(errs, errors.New("powerup_spawn_min must be positive and not above powerup_spawn_max"))
+	}
+	if g.RespawnDelay < 0 || g.ReconnectGrace < 0 || g.WeaponDespawn <= 0 || g.PowerUpDespawn <= 0 || g.RegenDuration < 0 || g.WeaponSwitchDelay < 0 {
+		errs = append(errs, errors.New("timers can't be negative and despawn timers must be positive"))
+	}
+	if g.ShieldStrength < 0 || g.RegenRate < 0 {
+		errs = append(errs, errors.New("shield_strength and regen_rate can't be negative"))
+	}
+	if g.TeleportCooldown < 0 || g.SpeedBoostDuration <= 0 || g.DamageAmpDuration <= 0 || g.InvisibilityDuration <= 0 {
+		errs = append(errs, errors.New("teleport_cooldown can't be negative and powerup durations must be positive"))
+	}
+	if g.ForceFieldCooldown < 0 || g.RegenCooldown < 0 || g.SpeedBoostCooldown < 0 || g.DamageAmpCooldown < 0 || g.InvisibilityCooldown < 0 {
+		errs = append(errs, errors.New("powerup cooldowns can't be negative"))
+	}
+	if g.SpeedBoostFactor < 1 || g.DamageAmpFactor < 1 || g.DamageAmpMaxStacks < 1 {
+		errs = append(errs, errors.New("speed_boost_factor and damage_amp_factor must be at least 1 and damage_amp_max_stacks at least 1"))
+	}
+	if g.BotFill < 0 || g.BotFill > g.MaxPlayers {
+		errs = append(errs, fmt.Errorf("bot_fill must be between 0 and max_players, got %d", g.BotFill))
+	}
+	if _, ok := BotDifficulties[g.BotDifficulty]; !ok {
+		errs = append(errs, fmt.Errorf("bot_difficulty must be easy, normal or hard, got %q", g.BotDifficulty))
+	}
+	if g.BotReaction < 0 || g.BotSpread < 0 {
+		errs = append(errs, errors.New("bot_reaction and bot_spread can't be negative"))
+	}
+	if g.ChatMaxLength < 1 || g.ChatBurst < 1 || g.ChatInterval <= 0 || g.ChatHistory < 0 {
+		errs = append(errs, errors.New("chat_max_length, chat_burst and chat_interval must be positive and chat_history can't be negative"))
+	}
+	if g.ChatFilter != ChatFilterMask && g.ChatFilter != ChatFilterBlock && g.ChatFilter != ChatFilterOff {
+		errs = append(errs, fmt.Errorf("chat_filter must be mask, block or off, got %q", g.ChatFilter))
+	}
+	if len(g.Teams) == 1 {
+		errs = append(errs, fmt.Errorf("teams needs at least two names, got %q", g.Teams[0]))
+	}
+	teams := make(map[string]bool, len(g.Teams))
+	for _, team := range g.Teams {
+		if team == "" || teams[team] {
+			errs = append(errs, fmt.Errorf("team names can't be empty or repeated, got %q", team))
+		}
+		teams[team] = true
+	}
+	if g.RadarDuration < 0 || g.MultiKillWindow < 0 {
+		errs = append(errs, errors.New("radar_duration and multikill_window can't be negative"))
+	}
+	seen := make(map[int]bool, len(g.Killstreaks))
+	for _, ks := range g.Killstreaks {
+		if ks.Kills < 1 {
+			errs = append(errs, fmt.Errorf("killstreak kills must be at least 1, got %d", ks.Kills))
+		}
+		if !rewards[ks.Reward] {
+			errs = append(errs, fmt.Errorf("unknown killstreak reward %q", ks.Reward))
+		}
+		if seen[ks.Kills] {
+			errs = append(errs, fmt.Errorf("more than one killstreak reward at %d kills", ks.Kills))
+		}
+		seen[ks.Kills] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Config is the whole server configuration.
+// precedence (lowest to highest): defaults, config file, environment, flags.
+type Config struct {
+	Addr                  string                     `json:"addr"`
+	EnvFile               string                     `json:"env_file"`   // optional .env with the DB_* credentials
+	StaticDir             string                     `json:"static_dir"` // where the web client is served from
+	ShutdownTimeout       Duration                   `json:"shutdown_timeout"`
+	PositionFlushInterval Duration                   `json:"position_flush_interval"` // longest a position waits before it's written
+	PositionBatchSize     int                        `json:"position_batch_size"`     // positions per insert
+	PositionBuffer        int                        `json:"position_buffer"`         // positions that can queue up before new ones are dropped
+	PositionRetention     Duration                   `json:"position_retention"`      // raw positions older than this are folded into heatmap cells, 0 keeps them
+	DefaultRoom           string                     `json:"default_room"`
+	MaxRooms              int                        `json:"max_rooms"`
+	RoomIdleTimeout       Duration                   `json:"room_idle_timeout"`   // rooms players made are closed after being empty this long
+	AdminToken            string                     `json:"admin_token"`         // bearer token for /admin, admin endpoints are off when empty
+	TrustedProxies        Words                      `json:"trusted_proxies"`     // ips or cidr ranges of the ingress, X-Forwarded-For is only believed from them
+	LogFormat             string                     `json:"log_format"`          // "text" or "json"
+	LogLevels             string                     `json:"log_levels"`          // "info" or per subsystem "info,sim=debug,db=warn"
+	ReplayDir             string                     `json:"replay_dir"`          // where match recordings go, recording is off when empty
+	ReplayMaxDuration     Duration                   `json:"replay_max_duration"` // a recording this long is closed and the match carries on in a new one
+	ReplayMaxSize         int64                      `json:"replay_max_size"`     // same for a recording this many bytes big
+	DBDriver              string                     `json:"db_driver"`           // "mysql" (credentials from the DB_* env vars) or "sqlite"
+	DBPath                string                     `json:"db_path"`             // the sqlite database file
+	DBMigrate             bool                       `json:"db_migrate"`          // apply pending migrations on startup, off when cmd/migrate does it
+	Game                  Game                       `json:"game"`
+	Rooms                 map[string]json.RawMessage `json:"rooms"` // per room overrides on top of Game
+
+	rooms map[string]Game // Game with each room's overrides applied, filled in by Load
+}
+
+// Defaults is what the server ran with back when all of this was hard coded
+func Defaults() *Config {
+	wd, _ := os.Getwd()
+	return &Config{
+		Addr:                  ":8080",
+		EnvFile:               filepath.Join(wd, "..", "..", ".env"), // project root when run from cmd/gameserver
+		StaticDir:             "web",
+		ShutdownTimeout:       Duration(10 * time.Second),
+		PositionFlushInterval: Duration(500 * time.Millisecond),
+		PositionBatchSize:     500,
+		PositionBuffer:        20000, // a few seconds of 60 updates a second from a full server
+		PositionRetention:     Duration(7 * 24 * time.Hour),
+		DefaultRoom:           "main",
+		MaxRooms:              16,
+		RoomIdleTimeout:       Duration(5 * time.Minute),
+		LogFormat:             "text",
+		LogLevels:             "info",
+		ReplayMaxDuration:     Duration(30 * time.Minute),
+		ReplayMaxSize:         64 << 20,
+		DBDriver:              "mysql",
+		DBPath:                "arena.db",
+		DBMigrate:             true,
+		Game: Game{
+			TickRate:             60,
+			MaxHealth:            100,
+			MaxPlayers:           32,
+			RespawnDelay:         Duration(3 * time.Second),
+			ReconnectGrace:       Duration(5 * time.Second),
+			MaxWeapons:           5,
+			MaxPowerUps:          3,
+			WeaponSpawnInterval:  Duration(1 * time.Second),
+			PowerUpSpawnMin:      Duration(15 * time.Second),
+			PowerUpSpawnMax:      Duration(45 * time.Second),
+			WeaponDespawn:        Duration(30 * time.Second),
+			WeaponSwitchDelay:    Duration(300 * time.Millisecond),
+			PowerUpDespawn:       Duration(30 * time.Second),
+			ShieldStrength:       100,
+			ForceFieldCooldown:   Duration(5 * time.Second),
+			RegenRate:            10,
+			RegenDuration:        Duration(10 * time.Second),
+			RegenCooldown:        Duration(5 * time.Second),
+			TeleportCooldown:     Duration(5 * time.Second),
+			SpeedBoostDuration:   Duration(8 * time.Second),
+			SpeedBoostFactor:     1.5,
+			SpeedBoostCooldown:   Duration(5 * time.Second),
+			DamageAmpDuration:    Duration(10 * time.Second),
+			DamageAmpFactor:      1.5,
+			DamageAmpMaxStacks:   2,
+			DamageAmpCooldown:    Duration(10 * time.Second),
+			InvisibilityDuration: Duration(8 * time.Second),
+			InvisibilityCooldown: Duration(10 * time.Second),
+			Killstreaks: Killstreaks{
+				{Kills: 3, Reward: RewardForceField},
+				{Kills: 5, Reward: RewardRadar},
+			},
+			RadarDuration:   Duration(15 * time.Second),
+			MultiKillWindow: Duration(3 * time.Second),
+			BotDifficulty:   "normal",
+			ChatMaxLength:   200,
+			ChatBurst:       5,
+			ChatInterval:    Duration(time.Second),
+			ChatHistory:     50,
+			ChatFilter:      ChatFilterMask,
+		},
+	}
+}
+
+// registers one flag per setting, all pointing into cfg.
+// the environment variable for a flag is GAME_ + the flag name upper cased with - as _
+// (so -reconnect-grace is GAME_RECONNECT_GRACE).
+func bind(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.StringVar(&cfg.EnvFile, "env-file", cfg.EnvFile, ".env file with database credentials")
+	fs.StringVar(&cfg.StaticDir, "static-dir", cfg.StaticDir, "directory the web client is served from")
+	fs.Var(&cfg.ShutdownTimeout, "shutdown-timeout", "how long a graceful shutdown may take")
+	fs.Var(&cfg.PositionFlushInterval, "position-flush-interval", "how often player positions are written to the database")
+	fs.IntVar(&cfg.PositionBatchSize, "position-batch-size", cfg.PositionBatchSize, "player positions written per insert")
+	fs.IntVar(&cfg.PositionBuffer, "position-buffer", cfg.PositionBuffer, "player positions queued for the database before new ones are dropped")
+	fs.Var(&cfg.PositionRetention, "position-retention", "how long raw player positions are kept before they're folded into heatmaps (0 keeps them forever)")
+	fs.StringVar(&cfg.DefaultRoom, "default-room", cfg.DefaultRoom, "room players join when they don't ask for one")
+	fs.IntVar(&cfg.MaxRooms, "max-rooms", cfg.MaxRooms, "maximum number of rooms running at once")
+	fs.Var(&cfg.RoomIdleTimeout, "room-idle-timeout", "how long a room players made can stay empty before it's closed")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token for the admin endpoints (disabled when empty)")
+	fs.Var(&cfg.TrustedProxies, "trusted-proxies", "ips or cidr ranges of proxies whose X-Forwarded-For is trusted, e.g. 10.0.0.0/8")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log output format: text or json")
+	fs.StringVar(&cfg.LogLevels, "log-levels", cfg.LogLevels, "log levels, e.g. info or info,sim=debug,db=warn")
+	fs.StringVar(&cfg.ReplayDir, "replay-dir", cfg.ReplayDir, "directory match replays are written to (empty, the default, disables recording)")
+	fs.Var(&cfg.ReplayMaxDuration, "replay-max-duration", "longest a single replay file runs before the match continues in a new one")
+	fs.Int64Var(&cfg.ReplayMaxSize, "replay-max-size", cfg.ReplayMaxSize, "biggest a single replay file gets in bytes before the match continues in a new one")
+	fs.StringVar(&cfg.DBDriver, "db-driver", cfg.DBDriver, "database to use: mysql or sqlite")
+	fs.StringVar(&cfg.DBPath, "db-path", cfg.DBPath, "sqlite database file (only used with -db-driver sqlite)")
+	fs.BoolVar(&cfg.DBMigrate, "db-migrate", cfg.DBMigrate, "apply pending database migrations on startup")
+
+	g := &cfg.Game
+	fs.IntVar(&g.TickRate, "tick-rate", g.TickRate, "simulation ticks per second")
+	fs.IntVar(&g.MaxHealth, "max-health", g.MaxHealth, "player health on spawn")
+	fs.IntVar(&g.MaxPlayers, "max-players", g.MaxPlayers, "players per room (spectators don't count)")
+	fs.Var(&g.RespawnDelay, "respawn-delay", "time before a dead player respawns")
+	fs.Var(&g.ReconnectGrace, "reconnect-grace", "how long a disconnected player is kept for a reconnect")
+	fs.IntVar(&g.MaxWeapons, "max-weapons", g.MaxWeapons, "weapon pickups on the map at once")
+	fs.IntVar(&g.MaxPowerUps, "max-powerups", g.MaxPowerUps, "powerup pickups on the map at once")
+	fs.Var(&g.WeaponSpawnInterval, "weapon-spawn-interval", "how often a weapon spawn is attempted")
+	fs.Var(&g.PowerUpSpawnMin, "powerup-spawn-min", "shortest time between powerup spawns")
+	fs.Var(&g.PowerUpSpawnMax, "powerup-spawn-max", "longest time between powerup spawns")
+	fs.Var(&g.WeaponDespawn, "weapon-despawn", "how long a weapon pickup stays on the map")
+	fs.Var(&g.WeaponSwitchDelay, "weapon-switch-delay", "how long switching weapons blocks firing")
+	fs.Var(&g.PowerUpDespawn, "powerup-despawn", "how long a powerup stays on the map")
+	fs.IntVar(&g.ShieldStrength, "shield-strength", g.ShieldStrength, "damage absorbed by the force field")
+	fs.Var(&g.ForceFieldCooldown, "force-field-cooldown", "how long after a force field breaks before another can be picked up")
+	fs.Float64Var(&g.RegenRate, "regen-rate", g.RegenRate, "health regenerated per second")
+	fs.Var(&g.RegenDuration, "regen-duration", "how long health regen lasts")
+	fs.Var(&g.RegenCooldown, "regen-cooldown", "how long after health regen ends before it can be picked up again")
+	fs.Var(&g.TeleportCooldown, "teleport-cooldown", "time between two teleports")
+	fs.Var(&g.SpeedBoostDuration, "speed-boost-duration", "how long the speed boost lasts")
+	fs.Float64Var(&g.SpeedBoostFactor, "speed-boost-factor", g.SpeedBoostFactor, "movement speed multiplier while boosted")
+	fs.Var(&g.SpeedBoostCooldown, "speed-boost-cooldown", "how long after a speed boost ends before it can be picked up again")
+	fs.Var(&g.DamageAmpDuration, "damage-amp-duration", "how long the damage amp lasts")
+	fs.Float64Var(&g.DamageAmpFactor, "damage-amp-factor", g.DamageAmpFactor, "damage multiplier per damage amp stack")
+	fs.IntVar(&g.DamageAmpMaxStacks, "damage-amp-max-stacks", g.DamageAmpMaxStacks, "how many damage amps can stack")
+	fs.Var(&g.DamageAmpCooldown, "damage-amp-cooldown", "how long after the damage amp ends before it can be picked up again")
+	fs.Var(&g.InvisibilityDuration, "invisibility-duration", "how long invisibility lasts (shooting ends it)")
+	fs.Var(&g.InvisibilityCooldown, "invisibility-cooldown", "how long after invisibility ends before it can be picked up again")
+	fs.Int64Var(&g.Seed, "seed", g.Seed, "rng seed for every match (0 for a random one per match)")
+	fs.Var(&g.Killstreaks, "killstreaks", "killstreak rewards, e.g. 3=force_field,5=radar (rewards: force_field, teleport, radar)")
+	fs.Var(&g.RadarDuration, "radar-duration", "how long the radar killstreak reward lasts")
+	fs.Var(&g.MultiKillWindow, "multikill-window", "kills within this long of each other count as a multi kill")
+	fs.IntVar(&g.BotFill, "bot-fill", g.BotFill, "fill the room with bots up to this many players (0 disables bots)")
+	fs.StringVar(&g.BotDifficulty, "bot-difficulty", g.BotDifficulty, "bot skill: easy, normal or hard")
+	fs.Var(&g.BotReaction, "bot-reaction", "bot reaction time, overrides the difficulty")
+	fs.Float64Var(&g.BotSpread, "bot-spread", g.BotSpread, "bot aim spread in radians, overrides the difficulty")
+	fs.IntVar(&g.ChatMaxLength, "chat-max-length", g.ChatMaxLength, "longest chat message in characters")
+	fs.IntVar(&g.ChatBurst, "chat-burst", g.ChatBurst, "chat messages a player can send back to back")
+	fs.Var(&g.ChatInterval, "chat-interval", "time per chat message once a player has used up the burst")
+	fs.IntVar(&g.ChatHistory, "chat-history", g.ChatHistory, "chat messages sent to players who join late or reconnect")
+	fs.StringVar(&g.ChatFilter, "chat-filter", g.ChatFilter, "what to do with blocked words in chat: mask, block or off")
+	fs.Var(&g.ChatBlockedWords, "chat-blocked-words", "extra words to block in chat, e.g. foo,bar")
+	fs.Var(&g.Teams, "teams", "split players between these teams, e.g. red,blue (empty for free for all)")
+}
+
+func envName(flagName string) string {
+	return "GAME_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the config from defaults, an optional json file (-config or GAME_CONFIG),
+// the .env file, GAME_* environment variables and finally command line flags.
+func Load(args []string) (*Config, error) {
+	// first pass over the flags just to find the config file
+	configPath := os.Getenv("GAME_CONFIG")
+	first := flag.NewFlagSet("gameserver", flag.ContinueOnError)
+	first.StringVar(&configPath, "config", configPath, "path to a json config file")
+	bind(first, Defaults())
+	if err := first.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Defaults()
+	if configPath != "" {
+		raw, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %v", err)
+		}
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %v", configPath, err)
+		}
+	}
+
+	fs := flag.NewFlagSet("gameserver", flag.ContinueOnError)
+	bind(fs, cfg)
+
+	// the .env file can itself be moved by env or flag, so settle that before loading it
+	if v := os.Getenv(envName("env-file")); v != "" {
+		cfg.EnvFile = v
+	}
+	if isSet(first, "env-file") {
+		cfg.EnvFile = first.Lookup("env-file").Value.String()
+	}
+	if err := godotenv.Load(cfg.EnvFile); err != nil {
+		// wont be found in production thus k8 default variables will be injected instead
+		log.Printf("No .env file found at %s, using kubernetes system environment variables", cfg.EnvFile)
+	} else {
+		log.Printf(".env loaded successfully from %s", cfg.EnvFile)
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", envName(f.Name), err))
+			}
+		}
+	})
+	first.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if err := fs.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %v", f.Name, err))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Validate checks the server settings, the base game settings and every room override.
+// it also resolves the room overrides so Room is just a lookup afterwards.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr is required"))
+	}
+	if c.DefaultRoom == "" {
+		errs = append(errs, errors.New("default_room is required"))
+	}
+	if c.MaxRooms < 1 {
+		errs = append(errs, fmt.Errorf("max_rooms must be at least 1, got %d", c.MaxRooms))
+	}
+	if c.RoomIdleTimeout <= 0 {
+		errs = append(errs, errors.New("room_idle_timeout must be positive"))
+	}
+	if c.ReplayMaxDuration <= 0 || c.ReplayMaxSize <= 0 {
+		errs = append(errs, errors.New("replay_max_duration and replay_max_size must be positive"))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log_format must be text or json, got %q", c.LogFormat))
+	}
+	if c.DBDriver != "mysql" && c.DBDriver != "sqlite" {
+		errs = append(errs, fmt.Errorf("db_driver must be mysql or sqlite, got %q", c.DBDriver))
+	}
+	if c.DBDriver == "sqlite" && c.DBPath == "" {
+		errs = append(errs, errors.New("db_path is required for sqlite"))
+	}
+	if c.ShutdownTimeout <= 0 || c.PositionFlushInterval <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout and position_flush_interval must be positive"))
+	}
+	// 6 placeholders a row, sqlite allows 32766 in one statement
+	if c.PositionBatchSize < 1 || c.PositionBatchSize > 5000 {
+		errs = append(errs, fmt.Errorf("position_batch_size must be between 1 and 5000, got %d", c.PositionBatchSize))
+	}
+	if c.PositionRetention < 0 {
+		errs = append(errs, errors.New("position_retention can't be negative"))
+	}
+	if c.PositionBuffer < c.PositionBatchSize {
+		errs = append(errs, fmt.Errorf("position_buffer must hold at least one batch (%d), got %d", c.PositionBatchSize, c.PositionBuffer))
+	}
+	if err := c.Game.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("game: %w", err))
+	}
+
+	c.rooms = make(map[string]Game, len(c.Rooms))
+	for name, raw := range c.Rooms {
+		// start from the base settings so a room only has to list what it changes
+		g := c.Game
+		if err := json.Unmarshal(raw, &g); err != nil {
+			errs = append(errs, fmt.Errorf("rooms.%s: %v", name, err))
+			continue
+		}
+		if err := g.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rooms.%s: %w", name, err))
+			continue
+		}
+		c.rooms[name] = g
+	}
+	return errors.Join(errs...)
+}
+
+// Room returns the game settings for a room, its overrides applied when it has any
+func (c *Config) Room(name string) Game {
+	if g, ok := c.rooms[name]; ok {
+		return g
+	}
+	return c.Game
+}
+
+// RoomNames lists the rooms that have their own section in the config
+func (c *Config) RoomNames() []string {
+	names := make([]string, 0, len(c.rooms))
+	for name := range c.rooms {
+		names = append(names, name)
+	}
+	return names
+}
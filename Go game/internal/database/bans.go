@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// a ban matches on session id, ip, or both. ExpiresAt nil means permanent.
+type Ban struct {
+	ID        int64      `json:"id"`
+	SessionID string     `json:"session_id,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// empty strings are stored as NULL so a session ban never matches an empty ip and vice versa
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// same for ids, 0 is no id
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// stores a new ban and fills in its id
+func (s *SQLStore) AddBan(ban *Ban) (err error) {
+	defer observe("add_ban", time.Now(), &err)
+	if ban.CreatedAt.IsZero() {
+		ban.CreatedAt = time.Now()
+	}
+	res, err := s.db.Exec(
+		"insert into bans (session_id, ip, reason, created_at, expires_at) values (?, ?, ?, ?, ?)",
+		nullString(ban.SessionID), nullString(ban.IP), ban.Reason, ban.CreatedAt.UTC(), nullTime(ban.ExpiresAt),
+	)
+	if err != nil {
+		return err
+	}
+	ban.ID, err = res.LastInsertId()
+	return err
+}
+
+// lifts a ban, reports whether there was one with that id
+func (s *SQLStore) RemoveBan(id int64) (_ bool, err error) {
+	defer observe("remove_ban", time.Now(), &err)
+	res, err := s.db.Exec("delete from bans where id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// every ban that hasn't expired yet, newest first
+func (s *SQLStore) ListBans() (_ []Ban, err error) {
+	defer observe("list_bans", time.Now(), &err)
+	rows, err := s.db.Query(`
+		select id, session_id, ip, reason, created_at, expires_at from bans
+		where expires_at is null or expires_at > ?
+		order by id desc
+	`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, *ban)
+	}
+	return bans, rows.Err()
+}
+
+// looks for an active ban on either the session or the ip, nil when they're allowed in.
+// checked on every connect so it has to stay a cheap indexed lookup.
+func (s *SQLStore) FindBan(sessionID, ip string) (_ *Ban, err error) {
+	defer observe("find_ban", time.Now(), &err)
+	row := s.db.QueryRow(`
+		select id, session_id, ip, reason, created_at, expires_at from bans
+		where (session_id = ? or ip = ?) and (expires_at is null or expires_at > ?)
+		order by id desc limit 1
+	`, nullString(sessionID), nullString(ip), time.Now().UTC())
+	ban, err := scanBan(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return ban, err
+}
+
+// times are always written in utc. sqlite keeps them as text and compares them as
+// strings, which only orders correctly when they all share one offset.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBan(row scanner) (*Ban, error) {
+	var ban Ban
+	var sessionID, ip sql.NullString
+	var expires sql.NullTime
+	if err := row.Scan(&ban.ID, &sessionID, &ip, &ban.Reason, &ban.CreatedAt, &expires); err != nil {
+		return nil, err
+	}
+	ban.SessionID = sessionID.String
+	ban.IP = ip.String
+	if expires.Valid {
+		ban.ExpiresAt = &expires.Time
+	}
+	return &ban, nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dialect is everything the SQL store needs to know about the database it's talking to.
+// the queries in sql.go are plain enough to run on both, the upserts aren't.
+// the schema lives in migrations/<driver>.
+type dialect struct {
+	driver string // for sql.Open, and the directory its migrations are in
+
+	// insert or update a session's last position, args are session_id, player_id, x, y
+	upsertLastPosition string
+
+	// add to a heatmap cell's hits, args are match_id, room, kind, cell_x, cell_y, hits
+	upsertHeatmapCell string
+
+	// appended to a select whose rows the transaction is about to delete, so two servers
+	// pruning at the same time can't both count them
+	forUpdate string
+
+	// held for a whole migration run so two servers starting at once don't both apply the
+	// same migration. lockMigrations takes the lock name and how long to wait in seconds and
+	// returns 1 once it's held. empty when the database doesn't need it.
+	lockMigrations   string
+	unlockMigrations string
+
+	// whether an insert or update failed on a unique key
+	duplicate func(error) bool
+}
+
+var mysqlDialect = dialect{
+	driver: "mysql",
+	upsertLastPosition: `
+		insert into player_last_positions (session_id, player_id, x, y)
+		values (?, ?, ?, ?)
+		on duplicate key update
+		x = values(x), y = values(y), updated_at = current_timestamp
+	`,
+	upsertHeatmapCell: `
+		insert into heatmap_cells (match_id, room, kind, cell_x, cell_y, hits)
+		values (?, ?, ?, ?, ?, ?)
+		on duplicate key update hits = hits + values(hits)
+	`,
+	forUpdate: " for update",
+	// named locks belong to the connection, so one that crashes mid migration lets go by itself
+	lockMigrations:   "select get_lock(?, ?)",
+	unlockMigrations: "select release_lock(?)",
+	duplicate: func(err error) bool {
+		var e *mysql.MySQLError
+		return errors.As(err, &e) && e.Number == 1062 // ER_DUP_ENTRY
+	},
+}
+
+// sqlite has no "on update" so the queries set updated_at themselves. it doesn't need a
+// migration lock or for update either, every transaction takes the write lock on the file
+// (_txlock in Open).
+var sqliteDialect = dialect{
+	driver: "sqlite",
+	upsertLastPosition: `
+		insert into player_last_positions (session_id, player_id, x, y)
+		values (?, ?, ?, ?)
+		on conflict (session_id) do update set
+		x = excluded.x, y = excluded.y, updated_at = current_timestamp
+	`,
+	upsertHeatmapCell: `
+		insert into heatmap_cells (match_id, room, kind, cell_x, cell_y, hits)
+		values (?, ?, ?, ?, ?, ?)
+		on conflict (match_id, kind, cell_x, cell_y) do update set hits = hits + excluded.hits
+	`,
+	duplicate: func(err error) bool {
+		var e *sqlite.Error
+		return errors.As(err, &e) && (e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || e.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+	},
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"arena-tactics/internal/database/models"
+)
+
+// appends the events to player_events with multi row inserts
+func (s *SQLStore) InsertEvents(batch []models.Event) (err error) {
+	if len(batch) == 0 {
+		return nil
+	}
+	defer observe("insert_events", time.Now(), &err)
+	return s.insertRows("insert into player_events (session_id, player_id, match_id, room, event_type, event_time, x, y, details)", len(batch), 9, func(i int) []any {
+		e := batch[i]
+		var details any // null rather than an empty string when there aren't any
+		if len(e.Details) > 0 {
+			details = string(e.Details)
+		}
+		return []any{e.SessionID, e.PlayerID, nullID(e.MatchID), e.Room, string(e.Type), e.At.UTC(), e.X, e.Y, details}
+	})
+}
+
+// records picked up weapons and powerups with multi row inserts
+func (s *SQLStore) InsertPickups(batch []models.Pickup) (err error) {
+	if len(batch) == 0 {
+		return nil
+	}
+	defer observe("insert_pickups", time.Now(), &err)
+	return s.insertRows("insert into pickups (pickup_id, kind, pickup_type, match_id, room, x, y, spawned_at, picked_by_session_id, picked_at)", len(batch), 10, func(i int) []any {
+		p := batch[i]
+		return []any{p.PickupID, p.Kind, p.Type, nullID(p.MatchID), p.Room, p.X, p.Y, p.SpawnedAt.UTC(), p.SessionID, p.PickedAt.UTC()}
+	})
+}
+
+// a session's events oldest first, in one match or all of them. events that happened in the
+// same millisecond come out in the order they were logged.
+func (s *SQLStore) Timeline(sessionID string, matchID int64) (_ []models.Event, err error) {
+	defer observe("timeline", time.Now(), &err)
+	query := `
+		select id, session_id, player_id, match_id, room, event_type, event_time, x, y, details
+		from player_events where session_id = ?`
+	args := []any{sessionID}
+	if matchID != 0 {
+		query += " and match_id = ?"
+		args = append(args, matchID)
+	}
+	rows, err := s.db.Query(query+" order by event_time, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []models.Event
+	for rows.Next() {
+		var e models.Event
+		var matchID sql.NullInt64
+		var details sql.NullString
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.PlayerID, &matchID, &e.Room, &e.Type, &e.At, &e.X, &e.Y, &details); err != nil {
+			return nil, err
+		}
+		e.MatchID = matchID.Int64
+		if details.Valid && details.String != "" {
+			e.Details = []byte(details.String)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
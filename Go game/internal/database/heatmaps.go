@@ -0,0 +1,155 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/heatmap"
+)
+
+// adds the hits to their cells in one transaction, cells that don't exist yet are created
+func (s *SQLStore) AddHeatmapCells(cells []models.HeatmapCell) (err error) {
+	if len(cells) == 0 {
+		return nil
+	}
+	defer observe("add_heatmap_cells", time.Now(), &err)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+	if err := addHeatmapCells(tx, s.dialect, cells); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addHeatmapCells(tx *sql.Tx, d dialect, cells []models.HeatmapCell) error {
+	stmt, err := tx.Prepare(d.upsertHeatmapCell)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range cells {
+		if _, err := stmt.Exec(c.MatchID, c.Room, c.Kind, c.X, c.Y, c.Hits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// folds the oldest raw positions from before the cutoff into their match's position heatmap
+// and deletes them, all in one transaction so a crash can't count a row twice.
+// positions from outside a match have nowhere to go and are just deleted.
+func (s *SQLStore) DownsamplePositions(before time.Time, limit int) (n int, err error) {
+	defer observe("downsample_positions", time.Now(), &err)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	rows, err := tx.Query(`
+		select p.id, p.match_id, m.room, p.x, p.y
+		from player_positions p left join game_sessions m on m.id = p.match_id
+		where p.timestamp < ?
+		order by p.id
+		limit ?`+s.dialect.forUpdate, before.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+	cells := make(map[models.HeatmapCell]int) // hits left at 0 in the key
+	var lastID int64
+	for rows.Next() {
+		var matchID sql.NullInt64
+		var room sql.NullString
+		var x, y float64
+		if err := rows.Scan(&lastID, &matchID, &room, &x, &y); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		n++
+		if !matchID.Valid || !room.Valid {
+			continue
+		}
+		col, row := heatmap.CellOf(x, y)
+		cells[models.HeatmapCell{MatchID: matchID.Int64, Room: room.String, Kind: heatmap.Positions, X: col, Y: row}]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	batch := make([]models.HeatmapCell, 0, len(cells))
+	for c, hits := range cells {
+		c.Hits = hits
+		batch = append(batch, c)
+	}
+	if err := addHeatmapCells(tx, s.dialect, batch); err != nil {
+		return 0, err
+	}
+	// everything before the cutoff up to the last id we read is exactly what we just counted,
+	// rows that came in since are newer than the cutoff
+	if _, err := tx.Exec("delete from player_positions where timestamp < ? and id <= ?", before.UTC(), lastID); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// the heatmap of a room, or of one match in it. position heatmaps add the raw positions that
+// haven't been downsampled yet to the cells.
+func (s *SQLStore) Heatmap(room, kind string, matchID int64) (_ *heatmap.Grid, err error) {
+	defer observe("heatmap", time.Now(), &err)
+	grid := heatmap.NewGrid(room, kind, matchID)
+
+	query := "select cell_x, cell_y, sum(hits) from heatmap_cells where room = ? and kind = ?"
+	args := []any{room, kind}
+	if matchID != 0 {
+		query += " and match_id = ?"
+		args = append(args, matchID)
+	}
+	rows, err := s.db.Query(query+" group by cell_x, cell_y", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var col, row, hits int
+		if err := rows.Scan(&col, &row, &hits); err != nil {
+			return nil, err
+		}
+		grid.Add(col, row, hits)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close() // sqlite has the one connection, the next query needs it back
+	if kind != heatmap.Positions {
+		return grid, nil
+	}
+
+	// streamed, only the grid is kept in memory however many rows there are
+	query = "select p.x, p.y from player_positions p join game_sessions m on m.id = p.match_id where m.room = ?"
+	args = []any{room}
+	if matchID != 0 {
+		query += " and p.match_id = ?"
+		args = append(args, matchID)
+	}
+	raw, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	for raw.Next() {
+		var x, y float64
+		if err := raw.Scan(&x, &y); err != nil {
+			return nil, err
+		}
+		grid.AddPoint(x, y)
+	}
+	return grid, raw.Err()
+}
@@ -0,0 +1,376 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/heatmap"
+)
+
+// Memory is a Store that keeps everything in maps. it behaves like SQLStore as far as
+// the server can tell, which is what lets the server be tested without a database.
+type Memory struct {
+	mu            sync.Mutex
+	nextID        int64
+	players       map[string]*models.Player // by session id
+	positions     []models.Position
+	lastPositions map[string]game.Position // by session id
+	matches       map[int64]*models.Match
+	cells         map[models.HeatmapCell]int // by the cell with its hits left at 0
+	events        []models.Event
+	profiles      map[string]models.Profile // by session id
+	pickups       []models.Pickup
+	bans          map[int64]Ban
+	mutes         map[int64]Mute
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		players:       make(map[string]*models.Player),
+		lastPositions: make(map[string]game.Position),
+		matches:       make(map[int64]*models.Match),
+		cells:         make(map[models.HeatmapCell]int),
+		profiles:      make(map[string]models.Profile),
+		bans:          make(map[int64]Ban),
+		mutes:         make(map[int64]Mute),
+	}
+}
+
+// stands in for auto increment, caller must hold the lock
+func (m *Memory) id() int64 {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *Memory) GetOrCreatePlayer(sessionID string) (*models.Player, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.players[sessionID]
+	if !ok {
+		p = &models.Player{
+			ID:        strconv.FormatInt(m.id(), 10),
+			SessionID: sessionID,
+			Username:  defaultUsername(sessionID),
+		}
+		m.players[sessionID] = p
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (m *Memory) RemovePlayer(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.players, sessionID)
+	delete(m.lastPositions, sessionID)
+	return nil
+}
+
+func (m *Memory) UpdatePlayerStats(sessionID string, kills, deaths int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// like an update that matches no rows, not an error
+	if p, ok := m.players[sessionID]; ok {
+		p.Kills += kills
+		p.Deaths += deaths
+	}
+	return nil
+}
+
+func (m *Memory) InsertPlayerPosition(sessionID, playerID string, pos game.Position) error {
+	return m.InsertPlayerPositions([]models.Position{{SessionID: sessionID, PlayerID: playerID, X: pos.X, Y: pos.Y, At: time.Now()}})
+}
+
+func (m *Memory) InsertPlayerPositions(batch []models.Position) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.positions = append(m.positions, batch...)
+	return nil
+}
+
+func (m *Memory) UpdateLastKnownPosition(sessionID, playerID string, pos game.Position) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastPositions[sessionID] = pos
+	return nil
+}
+
+func (m *Memory) UpdateLastKnownPositions(batch []models.Position) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range batch {
+		m.lastPositions[p.SessionID] = game.Position{X: p.X, Y: p.Y}
+	}
+	return nil
+}
+
+func (m *Memory) GetLastKnownPosition(sessionID string) (*game.Position, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pos, ok := m.lastPositions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	return &pos, nil
+}
+
+func (m *Memory) RemoveLastKnownPosition(sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.lastPositions, sessionID)
+	return nil
+}
+
+func (m *Memory) StartMatch(match *models.Match) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	match.ID = m.id()
+	copied := *match
+	m.matches[match.ID] = &copied
+	return nil
+}
+
+func (m *Memory) EndMatch(match *models.Match) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.matches[match.ID]; ok {
+		stored.EndedAt = match.EndedAt
+		stored.WinnerSessionID = match.WinnerSessionID
+	}
+	return nil
+}
+
+func (m *Memory) AddHeatmapCells(cells []models.HeatmapCell) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range cells {
+		hits := c.Hits
+		c.Hits = 0
+		m.cells[c] += hits
+	}
+	return nil
+}
+
+func (m *Memory) DownsamplePositions(before time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	kept := m.positions[:0]
+	for _, p := range m.positions {
+		if n == limit || !p.At.Before(before) {
+			kept = append(kept, p)
+			continue
+		}
+		n++
+		if match, ok := m.matches[p.MatchID]; ok && match.Room != "" {
+			col, row := heatmap.CellOf(p.X, p.Y)
+			m.cells[models.HeatmapCell{MatchID: p.MatchID, Room: match.Room, Kind: heatmap.Positions, X: col, Y: row}]++
+		}
+	}
+	m.positions = kept
+	return n, nil
+}
+
+func (m *Memory) Heatmap(room, kind string, matchID int64) (*heatmap.Grid, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grid := heatmap.NewGrid(room, kind, matchID)
+	for c, hits := range m.cells {
+		if c.Room == room && c.Kind == kind && (matchID == 0 || c.MatchID == matchID) {
+			grid.Add(c.X, c.Y, hits)
+		}
+	}
+	if kind == heatmap.Positions {
+		for _, p := range m.positions {
+			match, ok := m.matches[p.MatchID]
+			if ok && match.Room == room && (matchID == 0 || p.MatchID == matchID) {
+				grid.AddPoint(p.X, p.Y)
+			}
+		}
+	}
+	return grid, nil
+}
+
+func (m *Memory) InsertEvents(batch []models.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range batch {
+		e.ID = m.id()
+		m.events = append(m.events, e)
+	}
+	return nil
+}
+
+func (m *Memory) InsertPickups(batch []models.Pickup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pickups = append(m.pickups, batch...)
+	return nil
+}
+
+func (m *Memory) Timeline(sessionID string, matchID int64) ([]models.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []models.Event
+	for _, e := range m.events {
+		if e.SessionID == sessionID && (matchID == 0 || e.MatchID == matchID) {
+			events = append(events, e)
+		}
+	}
+	// ids go up in insert order, same tie break as the sql one
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+	return events, nil
+}
+
+func (m *Memory) GetProfile(sessionID string) (*models.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (m *Memory) SaveProfile(p models.Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, other := range m.profiles {
+		if id != p.SessionID && p.DisplayName != "" && strings.EqualFold(other.DisplayName, p.DisplayName) {
+			return ErrNameTaken
+		}
+	}
+	m.profiles[p.SessionID] = p
+	return nil
+}
+
+func (m *Memory) AddBan(ban *Ban) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ban.CreatedAt.IsZero() {
+		ban.CreatedAt = time.Now()
+	}
+	ban.ID = m.id()
+	m.bans[ban.ID] = *ban
+	return nil
+}
+
+func (m *Memory) RemoveBan(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.bans[id]
+	delete(m.bans, id)
+	return ok, nil
+}
+
+// every ban that hasn't expired yet, newest first
+func (m *Memory) ListBans() ([]Ban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var bans []Ban
+	for _, ban := range m.bans {
+		if ban.ExpiresAt == nil || ban.ExpiresAt.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].ID > bans[j].ID })
+	return bans, nil
+}
+
+// the newest active ban on either the session or the ip. empty strings never match,
+// the same as the NULLs SQLStore writes for them.
+func (m *Memory) FindBan(sessionID, ip string) (*Ban, error) {
+	bans, _ := m.ListBans()
+	for _, ban := range bans {
+		if (sessionID != "" && ban.SessionID == sessionID) || (ip != "" && ban.IP == ip) {
+			return &ban, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) AddMute(mute *Mute) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mute.CreatedAt.IsZero() {
+		mute.CreatedAt = time.Now()
+	}
+	mute.ID = m.id()
+	m.mutes[mute.ID] = *mute
+	return nil
+}
+
+func (m *Memory) RemoveMute(id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.mutes[id]
+	delete(m.mutes, id)
+	return ok, nil
+}
+
+// every mute that hasn't expired yet, newest first
+func (m *Memory) ListMutes() ([]Mute, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var mutes []Mute
+	for _, mute := range m.mutes {
+		if mute.ExpiresAt == nil || mute.ExpiresAt.After(now) {
+			mutes = append(mutes, mute)
+		}
+	}
+	sort.Slice(mutes, func(i, j int) bool { return mutes[i].ID > mutes[j].ID })
+	return mutes, nil
+}
+
+func (m *Memory) FindMute(sessionID string) (*Mute, error) {
+	mutes, _ := m.ListMutes()
+	for _, mute := range mutes {
+		if mute.SessionID == sessionID {
+			return &mute, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Positions is every position logged so far, for tests to look at
+func (m *Memory) Positions() []models.Position {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.Position(nil), m.positions...)
+}
+
+// Pickups is every pickup recorded so far, for tests to look at
+func (m *Memory) Pickups() []models.Pickup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.Pickup(nil), m.pickups...)
+}
+
+// Match is the stored copy of a match, nil if there isn't one with that id
+func (m *Memory) Match(id int64) *models.Match {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	match, ok := m.matches[id]
+	if !ok {
+		return nil
+	}
+	copied := *match
+	return &copied
+}
+
+// compile time check that both stores are complete
+var (
+	_ Store = (*SQLStore)(nil)
+	_ Store = (*Memory)(nil)
+)
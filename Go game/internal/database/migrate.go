@@ -0,0 +1,353 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"arena-tactics/internal/logging"
+)
+
+// one directory per dialect, each migration is NNNN_name.up.sql plus NNNN_name.down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// which migrations have been applied. it's created by the migrator rather than by a migration
+// so it exists before anything runs, and the sql is plain enough for both dialects.
+const migrationsTable = `create table if not exists schema_migrations (
+	version integer primary key,
+	name varchar(255) not null,
+	applied_at datetime not null
+)`
+
+const (
+	migrationLock     = "arena_tactics_migrations"
+	migrationLockWait = 5 * time.Minute // a migration rewriting a big table can take a while
+)
+
+// Migration is one numbered schema change and the script that undoes it
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// MigrationStatus is a migration and when it was applied, AppliedAt is nil while it's pending.
+// a version the database has but this build doesn't know about shows up with only what
+// schema_migrations remembers.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+// Migrator moves a database between schema versions. the version is the highest one in
+// schema_migrations and migrations are applied and rolled back strictly in order, one
+// transaction each.
+//
+// mysql commits ddl as it goes, so a migration that fails halfway there is left half applied
+// and has to be cleaned up by hand before it's retried. keep mysql migrations to one change
+// each. sqlite rolls the whole migration back.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration // sorted by version
+}
+
+// Migrator reads this build's migrations for the store's database
+func (s *SQLStore) Migrator() (*Migrator, error) {
+	return newMigrator(s.db, s.dialect, migrationFiles)
+}
+
+func newMigrator(db *sql.DB, d dialect, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys, path.Join("migrations", d.driver))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %v", err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if version < 1 {
+			return nil, fmt.Errorf("migration %s: versions start at 1", e.Name())
+		}
+		script, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %v", e.Name(), err)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is called both %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.up = string(script)
+		} else {
+			mig.down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if strings.TrimSpace(mig.up) == "" || strings.TrimSpace(mig.down) == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down script", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splits a script into statements, since the mysql driver only runs one per Exec.
+// a statement ends with a ; at the end of a line, lines starting with -- are comments.
+func statements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			stmts = append(stmts, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
+
+// Latest is the newest version this build has a migration for, 0 when it has none
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// Version is the version the database is at, 0 when nothing has been applied
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if _, err := m.db.ExecContext(ctx, migrationsTable); err != nil {
+		return 0, fmt.Errorf("creating schema_migrations: %v", err)
+	}
+	return currentVersion(ctx, m.db)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func currentVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "select coalesce(max(version), 0) from schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// Status lists every migration this build has and every one the database has, oldest first
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if _, err := m.db.ExecContext(ctx, migrationsTable); err != nil {
+		return nil, fmt.Errorf("creating schema_migrations: %v", err)
+	}
+	rows, err := m.db.QueryContext(ctx, "select version, name, applied_at from schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		var at time.Time
+		if err := rows.Scan(&s.Version, &s.Name, &at); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %v", err)
+		}
+		s.AppliedAt = &at
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %v", err)
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			s.AppliedAt = a.AppliedAt
+			delete(applied, mig.Version)
+		}
+		status = append(status, s)
+	}
+	for _, a := range applied {
+		a.Unknown = true
+		status = append(status, a)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down rolls back the newest applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil || current == 0 {
+			return err
+		}
+		target := 0
+		for _, mig := range m.migrations {
+			if mig.Version < current {
+				target = mig.Version
+			}
+		}
+		return m.migrate(ctx, conn, target)
+	})
+}
+
+// To applies or rolls back migrations until the database is at version, 0 undoes all of them
+func (m *Migrator) To(ctx context.Context, version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("there's no migration %d, the latest is %d", version, m.Latest())
+	}
+	return m.locked(ctx, func(conn *sql.Conn) error {
+		return m.migrate(ctx, conn, version)
+	})
+}
+
+// runs f on one connection while holding the migration lock
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.lockMigrations != "" {
+		var got sql.NullInt64
+		err := conn.QueryRowContext(ctx, m.dialect.lockMigrations, migrationLock, int(migrationLockWait.Seconds())).Scan(&got)
+		if err != nil {
+			return fmt.Errorf("taking the migration lock: %v", err)
+		}
+		if got.Int64 != 1 {
+			return fmt.Errorf("another migration held the lock for over %v", migrationLockWait)
+		}
+		defer func() {
+			// the lock goes with the connection anyway, this just hands it over sooner
+			var released sql.NullInt64
+			conn.QueryRowContext(context.Background(), m.dialect.unlockMigrations, migrationLock).Scan(&released)
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+		return fmt.Errorf("creating schema_migrations: %v", err)
+	}
+	return f(conn)
+}
+
+// steps towards target one migration at a time. each step checks the version again inside its
+// own transaction, so if somebody else got there first it just finds less to do.
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, target int) error {
+	for {
+		done, err := m.step(ctx, conn, target)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func (m *Migrator) step(ctx context.Context, conn *sql.Conn, target int) (done bool, err error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	current, err := currentVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if current == target {
+		return true, tx.Commit()
+	}
+
+	var mig *Migration
+	var script, direction string
+	if current < target {
+		for i := range m.migrations {
+			if m.migrations[i].Version > current {
+				mig = &m.migrations[i]
+				break
+			}
+		}
+		script, direction = mig.up, "up"
+	} else {
+		if mig = m.find(current); mig == nil {
+			return false, fmt.Errorf("the database is at version %d, which this build doesn't have a migration for", current)
+		}
+		script, direction = mig.down, "down"
+	}
+
+	start := time.Now()
+	for _, stmt := range statements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return false, fmt.Errorf("migration %d_%s %s: %v; query: %s", mig.Version, mig.Name, direction, err, stmt)
+		}
+	}
+	if direction == "up" {
+		_, err = tx.ExecContext(ctx, "insert into schema_migrations (version, name, applied_at) values (?, ?, ?)",
+			mig.Version, mig.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, "delete from schema_migrations where version = ?", mig.Version)
+	}
+	if err != nil {
+		return false, fmt.Errorf("recording migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("committing migration %d_%s: %v", mig.Version, mig.Name, err)
+	}
+	logging.DB.Info("migrated", "version", mig.Version, "name", mig.Name, "direction", direction, "took", time.Since(start))
+	return false, nil
+}
@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// three migrations on a throwaway table, each one leaves a trace we can look for
+var testMigrations = fstest.MapFS{
+	"migrations/sqlite/0001_widgets.up.sql":       {Data: []byte("create table widgets (id integer primary key);\n")},
+	"migrations/sqlite/0001_widgets.down.sql":     {Data: []byte("drop table widgets;\n")},
+	"migrations/sqlite/0002_widget_name.up.sql":   {Data: []byte("-- a column change, what create table if not exists could never do\nalter table widgets add column name text;\n")},
+	"migrations/sqlite/0002_widget_name.down.sql": {Data: []byte("alter table widgets drop column name;\n")},
+	"migrations/sqlite/0010_gadgets.up.sql":       {Data: []byte("create table gadgets (id integer primary key);\ncreate index gadgets_id on gadgets (id);\n")},
+	"migrations/sqlite/0010_gadgets.down.sql":     {Data: []byte("drop table gadgets;\n")},
+}
+
+func openSQLite(t *testing.T, path string) *SQLStore {
+	t.Helper()
+	s, err := Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testMigrator(t *testing.T, s *SQLStore) *Migrator {
+	t.Helper()
+	m, err := newMigrator(s.db, s.dialect, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func hasTable(t *testing.T, s *SQLStore, name string) bool {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = ?", name).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n == 1
+}
+
+func wantVersion(t *testing.T, m *Migrator, want int) {
+	t.Helper()
+	if v, err := m.Version(context.Background()); err != nil || v != want {
+		t.Fatalf("database is at version %d (%v), want %d", v, err, want)
+	}
+}
+
+func TestMigrateUpDownTo(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	m := testMigrator(t, s)
+	wantVersion(t, m, 0)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wantVersion(t, m, 10)
+	if !hasTable(t, s, "gadgets") {
+		t.Error("up didn't create gadgets")
+	}
+	if _, err := s.db.Exec("insert into widgets (name) values ('sprocket')"); err != nil {
+		t.Errorf("migration 2 didn't add the name column: %v", err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Errorf("up with nothing pending: %v", err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 || status[1].Name != "widget_name" || status[2].AppliedAt == nil {
+		t.Errorf("status after up: %+v", status)
+	}
+
+	if err := m.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	wantVersion(t, m, 2)
+	if hasTable(t, s, "gadgets") {
+		t.Error("down left gadgets behind")
+	}
+
+	if err := m.To(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	wantVersion(t, m, 0)
+	if hasTable(t, s, "widgets") {
+		t.Error("to 0 left widgets behind")
+	}
+	if err := m.Down(ctx); err != nil {
+		t.Errorf("down with nothing applied: %v", err)
+	}
+
+	if err := m.To(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	wantVersion(t, m, 1)
+	if err := m.To(ctx, 5); err == nil {
+		t.Error("migrating to a version that doesn't exist should fail")
+	}
+}
+
+func TestMigrateFailureRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	broken := fstest.MapFS{
+		"migrations/sqlite/0001_ok.up.sql":     {Data: []byte("create table ok (id integer);\n")},
+		"migrations/sqlite/0001_ok.down.sql":   {Data: []byte("drop table ok;\n")},
+		"migrations/sqlite/0002_half.up.sql":   {Data: []byte("create table half (id integer);\nthis is not sql;\n")},
+		"migrations/sqlite/0002_half.down.sql": {Data: []byte("drop table half;\n")},
+	}
+	m, err := newMigrator(s.db, s.dialect, broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err == nil {
+		t.Fatal("a broken migration should fail")
+	}
+	// the good one stays, the broken one is undone as a whole
+	wantVersion(t, m, 1)
+	if hasTable(t, s, "half") {
+		t.Error("the failed migration's first statement wasn't rolled back")
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	// a few servers starting on the same file at once, they all apply "everything" without tripping up
+	path := filepath.Join(t.TempDir(), "test.db")
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		m := testMigrator(t, openSQLite(t, path))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.Up(context.Background())
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("concurrent up: %v", err)
+		}
+	}
+	s := openSQLite(t, path)
+	var applied int
+	s.db.QueryRow("select count(*) from schema_migrations").Scan(&applied)
+	if applied != 3 {
+		t.Errorf("%d migrations recorded, want 3", applied)
+	}
+}
+
+func TestMigrateAdoptsExistingDatabase(t *testing.T) {
+	// a database set up before migrations existed already has the tables, 0001 has to take it over
+	s := openSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	if _, err := s.db.Exec("create table players (id integer primary key autoincrement, session_id text unique not null, username text, kills integer default 0, deaths integer default 0, score integer default 0, last_active datetime, created_at datetime, updated_at datetime)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("insert into players (session_id, username) values ('session-old', 'veteran')"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := s.GetOrCreatePlayer("session-old"); p == nil || p.Username != "veteran" {
+		t.Errorf("existing player lost in the migration, got %+v", p)
+	}
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	// the same versions with the same names on both, or a deployment switching drivers
+	// ends up at a version the other build has never heard of
+	mysql, err := loadMigrations(migrationFiles, "migrations/mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := loadMigrations(migrationFiles, "migrations/sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mysql) != len(sqlite) {
+		t.Fatalf("%d mysql migrations and %d sqlite ones", len(mysql), len(sqlite))
+	}
+	for i := range mysql {
+		if mysql[i].Version != sqlite[i].Version || mysql[i].Name != sqlite[i].Name {
+			t.Errorf("mysql has %d_%s where sqlite has %d_%s", mysql[i].Version, mysql[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsBadFiles(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":     {"m/first.up.sql": {Data: []byte("select 1;")}},
+		"missing down": {"m/0001_a.up.sql": {Data: []byte("select 1;")}},
+		"two names": {
+			"m/0001_a.up.sql":   {Data: []byte("select 1;")},
+			"m/0001_b.down.sql": {Data: []byte("select 1;")},
+		},
+		"version zero": {
+			"m/0000_a.up.sql":   {Data: []byte("select 1;")},
+			"m/0000_a.down.sql": {Data: []byte("select 1;")},
+		},
+	} {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: loaded without an error", name)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := "-- comment\ncreate table a (\n\tid int\n);\n\ninsert into a values (1); \nselect 1"
+	got := statements(script)
+	if len(got) != 3 || got[0] != "create table a (\n\tid int\n);" || got[2] != "select 1" {
+		t.Errorf("statements = %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+-- the tables as they were before migrations. "if not exists" so databases that were set up
+-- by the old startup code pick this up as already done instead of failing on it.
+create table if not exists players (
+	id int auto_increment primary key,
+	session_id varchar(255) unique not null,
+	username varchar(255),
+	kills int default 0,
+	deaths int default 0,
+	score int default 0,
+	last_active datetime default current_timestamp,
+	created_at datetime default current_timestamp,
+	updated_at datetime default current_timestamp on update current_timestamp
+);
+
+create table if not exists game_sessions (
+	id int auto_increment primary key,
+	session_id varchar(255) unique not null,
+	start_time datetime not null,
+	end_time datetime,
+	winner_session_id varchar(255),
+	created_at datetime default current_timestamp
+);
+
+create table if not exists pickups (
+	id int auto_increment primary key,
+	pickup_type varchar(50),
+	spawned_at datetime default current_timestamp,
+	picked_by_session_id varchar(255),
+	picked_at datetime
+);
+
+create table if not exists player_events (
+	id int auto_increment primary key,
+	session_id varchar(255) not null,
+	event_type varchar(50) not null,
+	event_time datetime default current_timestamp,
+	details text
+);
+
+create table if not exists player_positions (
+	id bigint auto_increment primary key,
+	session_id varchar(255) not null,
+	player_id varchar(255) not null,
+	x float not null,
+	y float not null,
+	timestamp timestamp default current_timestamp,
+	index (player_id, timestamp)
+);
+
+create table if not exists player_last_positions (
+	session_id varchar(255) primary key,
+	player_id varchar(255) not null,
+	x float not null,
+	y float not null,
+	updated_at timestamp default current_timestamp on update current_timestamp
+);
+
+create table if not exists bans (
+	id int auto_increment primary key,
+	session_id varchar(255),
+	ip varchar(64),
+	reason varchar(255) not null default '',
+	created_at datetime default current_timestamp,
+	expires_at datetime,
+	index (session_id),
+	index (ip)
+);
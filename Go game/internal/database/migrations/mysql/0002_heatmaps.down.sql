@@ -0,0 +1,4 @@
+drop table heatmap_cells;
+alter table game_sessions drop column room;
+drop index player_positions_time on player_positions;
+alter table player_positions drop column match_id;
@@ -0,0 +1,16 @@
+-- positions now outlive the player (RemovePlayer leaves them) and get folded into heatmap
+-- cells once they're old, so they need their match and an index to find the old ones
+alter table player_positions add column match_id bigint null;
+create index player_positions_time on player_positions (timestamp);
+alter table game_sessions add column room varchar(64);
+
+create table heatmap_cells (
+	match_id bigint not null,
+	room varchar(64) not null,
+	kind varchar(16) not null,
+	cell_x int not null,
+	cell_y int not null,
+	hits int not null default 0,
+	primary key (match_id, kind, cell_x, cell_y),
+	index (room, kind)
+);
@@ -0,0 +1,15 @@
+drop index pickups_match on pickups;
+alter table pickups drop column y;
+alter table pickups drop column x;
+alter table pickups drop column room;
+alter table pickups drop column match_id;
+alter table pickups drop column kind;
+alter table pickups drop column pickup_id;
+
+drop index player_events_session on player_events;
+alter table player_events modify event_time datetime default current_timestamp;
+alter table player_events drop column y;
+alter table player_events drop column x;
+alter table player_events drop column room;
+alter table player_events drop column match_id;
+alter table player_events drop column player_id;
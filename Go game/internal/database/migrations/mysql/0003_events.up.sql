@@ -0,0 +1,18 @@
+-- the event log: player_events gets where and in which match each event happened, and
+-- milliseconds so events from the same second stay in order. pickups records who grabbed
+-- what, and where.
+alter table player_events add column player_id varchar(255) not null default '';
+alter table player_events add column match_id bigint null;
+alter table player_events add column room varchar(64) not null default '';
+alter table player_events add column x float not null default 0;
+alter table player_events add column y float not null default 0;
+alter table player_events modify event_time datetime(3) not null default current_timestamp(3);
+create index player_events_session on player_events (session_id, match_id, event_time);
+
+alter table pickups add column pickup_id varchar(64) not null default '';
+alter table pickups add column kind varchar(16) not null default '';
+alter table pickups add column match_id bigint null;
+alter table pickups add column room varchar(64) not null default '';
+alter table pickups add column x float not null default 0;
+alter table pickups add column y float not null default 0;
+create index pickups_match on pickups (match_id);
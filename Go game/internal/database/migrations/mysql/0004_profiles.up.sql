@@ -0,0 +1,12 @@
+-- chosen names, colors and sprites. kept apart from players because that row is deleted
+-- when the session leaves and a profile is for good. the default collation is case
+-- insensitive, so the unique key stops "Bob" and "bob" both being taken.
+create table player_profiles (
+	session_id varchar(255) primary key,
+	display_name varchar(32) null,
+	color int not null,
+	sprite varchar(32) not null,
+	created_at datetime default current_timestamp,
+	updated_at datetime default current_timestamp on update current_timestamp,
+	unique key player_profiles_name (display_name)
+);
@@ -0,0 +1,9 @@
+-- sessions that can't chat. like bans, expires_at null means for good.
+create table mutes (
+	id int auto_increment primary key,
+	session_id varchar(255) not null,
+	reason varchar(255) not null default '',
+	created_at datetime default current_timestamp,
+	expires_at datetime,
+	index (session_id)
+);
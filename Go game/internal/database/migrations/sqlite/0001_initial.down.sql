@@ -0,0 +1,7 @@
+drop table if exists bans;
+drop table if exists player_last_positions;
+drop table if exists player_positions;
+drop table if exists player_events;
+drop table if exists pickups;
+drop table if exists game_sessions;
+drop table if exists players;
@@ -0,0 +1,67 @@
+-- same tables as mysql. sqlite has no "on update" so the queries set updated_at
+-- themselves, and indexes have to be created on their own.
+create table if not exists players (
+	id integer primary key autoincrement,
+	session_id text unique not null,
+	username text,
+	kills integer default 0,
+	deaths integer default 0,
+	score integer default 0,
+	last_active datetime default current_timestamp,
+	created_at datetime default current_timestamp,
+	updated_at datetime default current_timestamp
+);
+
+create table if not exists game_sessions (
+	id integer primary key autoincrement,
+	session_id text unique not null,
+	start_time datetime not null,
+	end_time datetime,
+	winner_session_id text,
+	created_at datetime default current_timestamp
+);
+
+create table if not exists pickups (
+	id integer primary key autoincrement,
+	pickup_type text,
+	spawned_at datetime default current_timestamp,
+	picked_by_session_id text,
+	picked_at datetime
+);
+
+create table if not exists player_events (
+	id integer primary key autoincrement,
+	session_id text not null,
+	event_type text not null,
+	event_time datetime default current_timestamp,
+	details text
+);
+
+create table if not exists player_positions (
+	id integer primary key autoincrement,
+	session_id text not null,
+	player_id text not null,
+	x real not null,
+	y real not null,
+	timestamp datetime default current_timestamp
+);
+create index if not exists player_positions_player_time on player_positions (player_id, timestamp);
+
+create table if not exists player_last_positions (
+	session_id text primary key,
+	player_id text not null,
+	x real not null,
+	y real not null,
+	updated_at datetime default current_timestamp
+);
+
+create table if not exists bans (
+	id integer primary key autoincrement,
+	session_id text,
+	ip text,
+	reason text not null default '',
+	created_at datetime default current_timestamp,
+	expires_at datetime
+);
+create index if not exists bans_session on bans (session_id);
+create index if not exists bans_ip on bans (ip);
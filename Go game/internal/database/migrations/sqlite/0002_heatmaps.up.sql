@@ -0,0 +1,16 @@
+-- positions now outlive the player (RemovePlayer leaves them) and get folded into heatmap
+-- cells once they're old, so they need their match and an index to find the old ones
+alter table player_positions add column match_id integer;
+create index player_positions_time on player_positions (timestamp);
+alter table game_sessions add column room text;
+
+create table heatmap_cells (
+	match_id integer not null,
+	room text not null,
+	kind text not null,
+	cell_x integer not null,
+	cell_y integer not null,
+	hits integer not null default 0,
+	primary key (match_id, kind, cell_x, cell_y)
+);
+create index heatmap_cells_room on heatmap_cells (room, kind);
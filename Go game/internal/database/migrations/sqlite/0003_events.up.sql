@@ -0,0 +1,16 @@
+-- the event log: player_events gets where and in which match each event happened. pickups
+-- records who grabbed what, and where. sqlite keeps whatever precision event_time is given.
+alter table player_events add column player_id text not null default '';
+alter table player_events add column match_id integer;
+alter table player_events add column room text not null default '';
+alter table player_events add column x real not null default 0;
+alter table player_events add column y real not null default 0;
+create index player_events_session on player_events (session_id, match_id, event_time);
+
+alter table pickups add column pickup_id text not null default '';
+alter table pickups add column kind text not null default '';
+alter table pickups add column match_id integer;
+alter table pickups add column room text not null default '';
+alter table pickups add column x real not null default 0;
+alter table pickups add column y real not null default 0;
+create index pickups_match on pickups (match_id);
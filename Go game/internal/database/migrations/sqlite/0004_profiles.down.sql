@@ -0,0 +1 @@
+drop table player_profiles;
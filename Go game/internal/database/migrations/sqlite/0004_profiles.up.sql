@@ -0,0 +1,11 @@
+-- chosen names, colors and sprites. kept apart from players because that row is deleted
+-- when the session leaves and a profile is for good. nocase so "Bob" and "bob" can't
+-- both be taken.
+create table player_profiles (
+	session_id text primary key,
+	display_name text collate nocase unique,
+	color integer not null,
+	sprite text not null,
+	created_at datetime default current_timestamp,
+	updated_at datetime default current_timestamp
+);
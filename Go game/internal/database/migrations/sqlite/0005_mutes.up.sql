@@ -0,0 +1,9 @@
+-- sessions that can't chat. like bans, expires_at null means for good.
+create table mutes (
+	id integer primary key autoincrement,
+	session_id text not null,
+	reason text not null default '',
+	created_at datetime default current_timestamp,
+	expires_at datetime
+);
+create index mutes_session on mutes (session_id);
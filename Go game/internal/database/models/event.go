@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventType is what happened to a player, one of the Event constants
+type EventType string
+
+const (
+	EventSpawn      EventType = "spawn"       // SpawnDetails
+	EventDeath      EventType = "death"       // DeathDetails
+	EventKill       EventType = "kill"        // KillDetails
+	EventPickup     EventType = "pickup"      // PickupDetails
+	EventPowerUpUse EventType = "powerup_use" // PowerUpDetails
+	EventTeleport   EventType = "teleport"    // TeleportDetails
+	EventDisconnect EventType = "disconnect"  // DisconnectDetails
+	EventReconnect  EventType = "reconnect"   // ReconnectDetails
+	EventChat       EventType = "chat"        // ChatDetails
+)
+
+// Event is one entry in a player's event log, X and Y are where they were at the time.
+// Details is the JSON of the struct that goes with the type.
+type Event struct {
+	ID        int64           `json:"id"`
+	SessionID string          `json:"session_id"`
+	PlayerID  string          `json:"player_id"`
+	MatchID   int64           `json:"match_id,omitempty"` // 0 when it wasn't during a match
+	Room      string          `json:"room"`
+	Type      EventType       `json:"type"`
+	At        time.Time       `json:"at"`
+	X         float64         `json:"x"`
+	Y         float64         `json:"y"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+type SpawnDetails struct {
+	Respawn  bool   `json:"respawn"`            // false for the first spawn after joining
+	Restored bool   `json:"restored,omitempty"` // put back at their last known position
+	Health   int    `json:"health"`
+	Weapon   string `json:"weapon"`
+}
+
+type DeathDetails struct {
+	KillerID string   `json:"killer_id,omitempty"`
+	Weapon   string   `json:"weapon,omitempty"`
+	Effects  []string `json:"effects,omitempty"` // what they had active and lost
+}
+
+type KillDetails struct {
+	VictimID string   `json:"victim_id"`
+	Weapon   string   `json:"weapon"`
+	Assists  []string `json:"assists,omitempty"`
+	Streak   int      `json:"streak"`
+}
+
+type PickupDetails struct {
+	Kind     string `json:"kind"` // "weapon" or "powerup"
+	Item     string `json:"item"`
+	PickupID string `json:"pickup_id"`
+}
+
+type PowerUpDetails struct {
+	PowerUp  string `json:"powerup"`
+	Stacks   int    `json:"stacks"`
+	Duration int64  `json:"duration_ms"` // 0 for ones that last until they're used up
+}
+
+type TeleportDetails struct {
+	FromX float64 `json:"from_x"`
+	FromY float64 `json:"from_y"`
+}
+
+type DisconnectDetails struct {
+	Reason string `json:"reason"` // "timeout", "closed" or "removed" once the grace window is over
+}
+
+type ReconnectDetails struct {
+	LastSeq uint64 `json:"last_seq"`
+	Dead    bool   `json:"dead"`
+}
+
+type ChatDetails struct {
+	Scope     string `json:"scope"`            // "room", "team" or "whisper"
+	Target    string `json:"target,omitempty"` // who a whisper went to
+	Text      string `json:"text"`             // as the others saw it, after the filter
+	QuickChat string `json:"quick_chat,omitempty"`
+}
+
+// Pickup is a weapon or powerup someone picked up
+type Pickup struct {
+	PickupID  string
+	Kind      string // "weapon" or "powerup"
+	Type      string
+	MatchID   int64
+	Room      string
+	X, Y      float64
+	SpawnedAt time.Time
+	SessionID string
+	PickedAt  time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+// HeatmapCell is how often something (a kill, a death, a player being there) happened in
+// one cell of a match's heatmap grid
+type HeatmapCell struct {
+	MatchID int64
+	Room    string
+	Kind    string
+	X, Y    int // column and row
+	Hits    int
+}
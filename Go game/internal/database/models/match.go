@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Match is one round in a room from start to end. Key tells matches apart,
+// it's the room name and the start time.
+type Match struct {
+	ID              int64
+	Key             string
+	Room            string
+	StartedAt       time.Time
+	EndedAt         *time.Time // nil while it's still being played
+	WinnerSessionID string     // most kills, empty when nobody scored
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Player is a player's row, the part of them that outlives a connection
+type Player struct {
+	ID        string
+	SessionID string
+	Username  string
+	Stats
+}
+
+// Position is one sample of where a player was
+type Position struct {
+	SessionID string
+	PlayerID  string
+	MatchID   int64 // 0 when it wasn't during a match
+	X, Y      float64
+	At        time.Time
+}
+
+// Profile is how a player shows up to everyone else, chosen by them and kept per session
+// for good (unlike the Player row, which goes when the session does).
+type Profile struct {
+	SessionID   string `json:"-"`
+	DisplayName string `json:"name,omitempty"` // empty until they pick one, they go by their username
+	Color       int    `json:"color"`
+	Sprite      string `json:"sprite"`
+}
@@ -1 +1,8 @@
-package models
\ No newline at end of file
+package models
+
+// Stats are a player's running totals across matches
+type Stats struct {
+	Kills  int
+	Deaths int
+	Score  int
+}
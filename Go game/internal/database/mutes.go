@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// a muted session can still play, it just can't chat. ExpiresAt nil means permanent.
+type Mute struct {
+	ID        int64      `json:"id"`
+	SessionID string     `json:"session_id"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// stores a new mute and fills in its id
+func (s *SQLStore) AddMute(mute *Mute) (err error) {
+	defer observe("add_mute", time.Now(), &err)
+	if mute.CreatedAt.IsZero() {
+		mute.CreatedAt = time.Now()
+	}
+	res, err := s.db.Exec(
+		"insert into mutes (session_id, reason, created_at, expires_at) values (?, ?, ?, ?)",
+		mute.SessionID, mute.Reason, mute.CreatedAt.UTC(), nullTime(mute.ExpiresAt),
+	)
+	if err != nil {
+		return err
+	}
+	mute.ID, err = res.LastInsertId()
+	return err
+}
+
+// lifts a mute, reports whether there was one with that id
+func (s *SQLStore) RemoveMute(id int64) (_ bool, err error) {
+	defer observe("remove_mute", time.Now(), &err)
+	res, err := s.db.Exec("delete from mutes where id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// every mute that hasn't expired yet, newest first
+func (s *SQLStore) ListMutes() (_ []Mute, err error) {
+	defer observe("list_mutes", time.Now(), &err)
+	rows, err := s.db.Query(`
+		select id, session_id, reason, created_at, expires_at from mutes
+		where expires_at is null or expires_at > ?
+		order by id desc
+	`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mutes []Mute
+	for rows.Next() {
+		mute, err := scanMute(rows)
+		if err != nil {
+			return nil, err
+		}
+		mutes = append(mutes, *mute)
+	}
+	return mutes, rows.Err()
+}
+
+// the session's active mute, nil when it can chat. looked up on join, not on every message.
+func (s *SQLStore) FindMute(sessionID string) (_ *Mute, err error) {
+	defer observe("find_mute", time.Now(), &err)
+	row := s.db.QueryRow(`
+		select id, session_id, reason, created_at, expires_at from mutes
+		where session_id = ? and (expires_at is null or expires_at > ?)
+		order by id desc limit 1
+	`, sessionID, time.Now().UTC())
+	mute, err := scanMute(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return mute, err
+}
+
+func scanMute(row scanner) (*Mute, error) {
+	var mute Mute
+	var expires sql.NullTime
+	if err := row.Scan(&mute.ID, &mute.SessionID, &mute.Reason, &mute.CreatedAt, &expires); err != nil {
+		return nil, err
+	}
+	if expires.Valid {
+		mute.ExpiresAt = &expires.Time
+	}
+	return &mute, nil
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"arena-tactics/internal/database/models"
+)
+
+func (s *SQLStore) GetProfile(sessionID string) (_ *models.Profile, err error) {
+	defer observe("get_profile", time.Now(), &err)
+	p := models.Profile{SessionID: sessionID}
+	var name sql.NullString
+	err = s.db.QueryRow("select display_name, color, sprite from player_profiles where session_id = ?", sessionID).
+		Scan(&name, &p.Color, &p.Sprite)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.DisplayName = name.String
+	return &p, nil
+}
+
+// an update or an insert rather than an upsert, mysql's upsert fires on any unique key and
+// would overwrite whoever already has the name
+func (s *SQLStore) SaveProfile(p models.Profile) (err error) {
+	defer observe("save_profile", time.Now(), &err)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+
+	var exists int
+	err = tx.QueryRow("select 1 from player_profiles where session_id = ?"+s.dialect.forUpdate, p.SessionID).Scan(&exists)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(
+			"insert into player_profiles (session_id, display_name, color, sprite) values (?, ?, ?, ?)",
+			p.SessionID, nullString(p.DisplayName), p.Color, p.Sprite,
+		)
+	case err == nil:
+		_, err = tx.Exec(
+			"update player_profiles set display_name = ?, color = ?, sprite = ?, updated_at = current_timestamp where session_id = ?",
+			nullString(p.DisplayName), p.Color, p.Sprite, p.SessionID,
+		)
+	}
+	if err != nil {
+		if s.dialect.duplicate(err) {
+			return ErrNameTaken
+		}
+		return err
+	}
+	return tx.Commit()
+}
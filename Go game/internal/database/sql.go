@@ -0,0 +1,267 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/metrics"
+	_ "github.com/go-sql-driver/mysql" //u sing the blank import pattern imports the driver but doesn't use it directly
+	_ "modernc.org/sqlite"             // pure go, no cgo needed in the container
+)
+
+// SQLStore is the Store backed by a real database, MySQL in production or SQLite
+// for local development and single node deployments. the queries are shared,
+// whatever the two disagree on lives in the dialect.
+type SQLStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// Open connects to the database. driver is "mysql", which takes its credentials from
+// env variables to keep them out of the codebase, or "sqlite", which only needs the path
+// of its file. the tables come from the migrations, see Migrator.
+func Open(driver, path string) (*SQLStore, error) {
+	var d dialect
+	var dsn string
+	switch driver {
+	case "mysql":
+		d = mysqlDialect
+		// now portable across different environments (ie for containerization)
+		// parseTime so datetime columns (ban expiry) scan straight into time.Time
+		dsn = fmt.Sprintf("%s:%s@tcp(%s:3306)/%s?parseTime=true",
+			os.Getenv("DB_USERNAME"),
+			os.Getenv("DB_PASSWORD"),
+			os.Getenv("DB_HOST"),
+			os.Getenv("DB_DATABASE"))
+	case "sqlite":
+		d = sqliteDialect
+		// wait on a busy database instead of failing, wal so reads don't block the writer.
+		// transactions take the write lock up front, which is also what keeps two processes
+		// from running the same migration
+		dsn = "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+
+	db, err := sql.Open(d.driver, dsn) // doesnt actually connect until the first query
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if driver == "sqlite" {
+		// sqlite only has one writer, queueing here beats "database is locked" errors
+		// (and keeps a :memory: database on the one connection that has it)
+		db.SetMaxOpenConns(1)
+	}
+	// always ping the db to verify connection
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	return &SQLStore{db: db, dialect: d}, nil
+}
+
+// records latency and errors for a db call, meant to be deferred with a named error:
+// defer observe("op", time.Now(), &err)
+func observe(op string, start time.Time, err *error) {
+	metrics.ObserveDB(op, start, *err)
+}
+
+// closes the connection pool, used on shutdown
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// deletes a player from the database using their unique session id.
+// for cleaning up when players disconnect or sessions expire. by design players are ephemeral.
+func (s *SQLStore) RemovePlayer(sessionID string) (err error) {
+	defer observe("remove_player", time.Now(), &err)
+	queries := []string{
+		"delete from players where session_id = ?",
+		"delete from player_last_positions where session_id = ?",
+	}
+	// their positions stay, they belong to the match's heatmap now and go when they're
+	// downsampled (see DownsamplePositions)
+	for _, query := range queries {
+		if _, err := s.db.Exec(query, sessionID); err != nil {
+			return fmt.Errorf("error executing query: %v; query: %s", err, query)
+		}
+	}
+	return nil
+}
+
+// Retrieves an existing player or it will create a new one if not found.
+// first try to find the player
+// only create a new record if they dont exist yet.
+func (s *SQLStore) GetOrCreatePlayer(sessionID string) (_ *models.Player, err error) {
+	defer observe("get_or_create_player", time.Now(), &err)
+	var player models.Player
+	query := "select id, session_id, username, kills, deaths, score from players where session_id = ?"
+	err = s.db.QueryRow(query, sessionID).Scan(&player.ID, &player.SessionID, &player.Username, &player.Kills, &player.Deaths, &player.Score)
+	if err == sql.ErrNoRows {
+		// player doesn't exist yet so create one
+		username := defaultUsername(sessionID)
+		insertQuery := "insert into players (session_id, username) values (?, ?)"
+		res, err := s.db.Exec(insertQuery, sessionID, username)
+		if err != nil {
+			return nil, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		player = models.Player{
+			ID:        strconv.FormatInt(id, 10),
+			SessionID: sessionID,
+			Username:  username,
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return &player, nil
+}
+
+// increments the kills and deaths counters for a player.
+func (s *SQLStore) UpdatePlayerStats(sessionID string, kills, deaths int) (err error) {
+	defer observe("update_player_stats", time.Now(), &err)
+	query := "update players set kills = kills + ?, deaths = deaths + ?, updated_at = current_timestamp where session_id = ?"
+	_, err = s.db.Exec(query, kills, deaths, sessionID)
+	return err
+}
+
+// logs every movement into the player positions table
+func (s *SQLStore) InsertPlayerPosition(sessionID, playerID string, pos game.Position) (err error) {
+	defer observe("insert_player_position", time.Now(), &err)
+	query := `
+		insert into player_positions (session_id, player_id, x, y)
+		values (?, ?, ?, ?)
+	`
+	_, err = s.db.Exec(query, sessionID, playerID, pos.X, pos.Y)
+	return err
+}
+
+// writes a whole batch of positions in as few inserts as it takes
+func (s *SQLStore) InsertPlayerPositions(batch []models.Position) (err error) {
+	if len(batch) == 0 {
+		return nil
+	}
+	defer observe("batch_insert_positions", time.Now(), &err)
+	return s.insertRows("insert into player_positions (session_id, player_id, match_id, x, y, timestamp)", len(batch), 6, func(i int) []any {
+		p := batch[i]
+		return []any{p.SessionID, p.PlayerID, nullID(p.MatchID), p.X, p.Y, p.At.UTC()}
+	})
+}
+
+// sqlite takes at most 32766 parameters in one statement (mysql 65535). a var so the
+// tests can split batches without having to write tens of thousands of rows.
+var maxParams = 32766
+
+// inserts n rows of cols values each with multi row inserts, as many rows to an insert as
+// the parameter limit allows. values gives the i'th row. a batch that takes more than one
+// insert goes in one transaction so it's still all or nothing.
+func (s *SQLStore) insertRows(insert string, n, cols int, values func(i int) []any) error {
+	perInsert := maxParams / cols
+	row := "(" + strings.Repeat("?, ", cols-1) + "?)"
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+	for start := 0; start < n; start += perInsert {
+		end := min(n, start+perInsert)
+		rows := make([]string, 0, end-start)
+		args := make([]any, 0, (end-start)*cols)
+		for i := start; i < end; i++ {
+			rows = append(rows, row)
+			args = append(args, values(i)...)
+		}
+		if _, err := tx.Exec(insert+" values "+strings.Join(rows, ","), args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updates players last position
+func (s *SQLStore) UpdateLastKnownPosition(sessionID, playerID string, pos game.Position) (err error) {
+	defer observe("update_last_position", time.Now(), &err)
+	_, err = s.db.Exec(s.dialect.upsertLastPosition, sessionID, playerID, pos.X, pos.Y)
+	return err
+}
+
+// updates the last position of every session in the batch, in one transaction.
+// when a session shows up more than once the last one wins.
+func (s *SQLStore) UpdateLastKnownPositions(batch []models.Position) (err error) {
+	if len(batch) == 0 {
+		return nil
+	}
+	defer observe("batch_update_last_positions", time.Now(), &err)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op once committed
+	stmt, err := tx.Prepare(s.dialect.upsertLastPosition)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range batch {
+		if _, err := stmt.Exec(p.SessionID, p.PlayerID, p.X, p.Y); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Retrieves last known position on reconnections
+func (s *SQLStore) GetLastKnownPosition(sessionID string) (_ *game.Position, err error) {
+	defer observe("get_last_position", time.Now(), &err)
+	query := `
+		select x, y from player_last_positions where session_id = ?
+	`
+	var pos game.Position
+	err = s.db.QueryRow(query, sessionID).Scan(&pos.X, &pos.Y)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+// drops a session's last position and nothing else, unlike RemovePlayer
+func (s *SQLStore) RemoveLastKnownPosition(sessionID string) (err error) {
+	defer observe("remove_last_position", time.Now(), &err)
+	_, err = s.db.Exec("delete from player_last_positions where session_id = ?", sessionID)
+	return err
+}
+
+// records a match starting, matches live in the game_sessions table
+func (s *SQLStore) StartMatch(m *models.Match) (err error) {
+	defer observe("start_match", time.Now(), &err)
+	res, err := s.db.Exec("insert into game_sessions (session_id, room, start_time) values (?, ?, ?)", m.Key, nullString(m.Room), m.StartedAt.UTC())
+	if err != nil {
+		return err
+	}
+	m.ID, err = res.LastInsertId()
+	return err
+}
+
+// records how a match ended
+func (s *SQLStore) EndMatch(m *models.Match) (err error) {
+	defer observe("end_match", time.Now(), &err)
+	var ended any
+	if m.EndedAt != nil {
+		ended = m.EndedAt.UTC()
+	}
+	_, err = s.db.Exec("update game_sessions set end_time = ?, winner_session_id = ? where id = ?",
+		ended, nullString(m.WinnerSessionID), m.ID)
+	return err
+}
@@ -0,0 +1,433 @@
+package database
+
+import (
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/heatmap"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// every Store has to behave the same, so the same checks run against each one.
+// mysql needs a server so it isn't in here, it shares all its queries with sqlite
+// apart from the dialect.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	migrator, err := sqlite.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrating sqlite: %v", err)
+	}
+	return map[string]Store{
+		"memory": NewMemory(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestPlayers(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			p, err := s.GetOrCreatePlayer("session-one")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Username != "Player_one" {
+				t.Errorf("new player got username %q", p.Username)
+			}
+			// session ids shorter than a default name used to panic
+			if short, err := s.GetOrCreatePlayer("abc"); err != nil || short.Username != "Player_abc" {
+				t.Errorf("short session got %v, %v", short, err)
+			}
+			again, _ := s.GetOrCreatePlayer("session-one")
+			if again.ID != p.ID {
+				t.Errorf("same session got a new id, %s then %s", p.ID, again.ID)
+			}
+
+			if err := s.UpdatePlayerStats("session-one", 2, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.UpdatePlayerStats("session-one", 1, 0); err != nil {
+				t.Fatal(err)
+			}
+			p, _ = s.GetOrCreatePlayer("session-one")
+			if p.Kills != 3 || p.Deaths != 1 {
+				t.Errorf("stats are %d/%d, want 3/1", p.Kills, p.Deaths)
+			}
+
+			if err := s.RemovePlayer("session-one"); err != nil {
+				t.Fatal(err)
+			}
+			p, _ = s.GetOrCreatePlayer("session-one")
+			if p.Kills != 0 {
+				t.Errorf("removed player came back with %d kills", p.Kills)
+			}
+		})
+	}
+}
+
+func TestPositions(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if pos, err := s.GetLastKnownPosition("session-one"); err != nil || pos != nil {
+				t.Fatalf("no position yet, got %v, %v", pos, err)
+			}
+			for _, pos := range []game.Position{{X: 1, Y: 2}, {X: 30, Y: 40}} {
+				if err := s.UpdateLastKnownPosition("session-one", "7", pos); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pos, err := s.GetLastKnownPosition("session-one")
+			if err != nil || pos == nil || *pos != (game.Position{X: 30, Y: 40}) {
+				t.Errorf("last position is %v, %v, want {30 40}", pos, err)
+			}
+
+			if err := s.InsertPlayerPosition("session-one", "7", game.Position{X: 5, Y: 5}); err != nil {
+				t.Fatal(err)
+			}
+			batch := []models.Position{
+				{SessionID: "session-one", PlayerID: "7", X: 6, Y: 6, At: time.Now()},
+				{SessionID: "session-two", PlayerID: "8", X: 7, Y: 7, At: time.Now()},
+			}
+			if err := s.InsertPlayerPositions(batch); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.InsertPlayerPositions(nil); err != nil {
+				t.Errorf("an empty batch should be a no-op, got %v", err)
+			}
+			if err := s.UpdateLastKnownPositions(batch); err != nil {
+				t.Fatal(err)
+			}
+			if pos, _ := s.GetLastKnownPosition("session-two"); pos == nil || *pos != (game.Position{X: 7, Y: 7}) {
+				t.Errorf("batch didn't update session-two's last position, got %v", pos)
+			}
+			if err := s.RemoveLastKnownPosition("session-two"); err != nil {
+				t.Fatal(err)
+			}
+			if pos, _ := s.GetLastKnownPosition("session-two"); pos != nil {
+				t.Errorf("session-two's last position %v survived RemoveLastKnownPosition", pos)
+			}
+
+			if err := s.RemovePlayer("session-one"); err != nil {
+				t.Fatal(err)
+			}
+			if pos, _ := s.GetLastKnownPosition("session-one"); pos != nil {
+				t.Errorf("last position %v survived RemovePlayer", pos)
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			m := &models.Match{Key: "main-1", StartedAt: time.Now()}
+			if err := s.StartMatch(m); err != nil {
+				t.Fatal(err)
+			}
+			if m.ID == 0 {
+				t.Fatal("StartMatch didn't fill in the id")
+			}
+			ended := time.Now()
+			m.EndedAt = &ended
+			m.WinnerSessionID = "session-one"
+			if err := s.EndMatch(m); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestHeatmaps(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			m := &models.Match{Key: "main-1", Room: "main", StartedAt: time.Now()}
+			if err := s.StartMatch(m); err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-48 * time.Hour)
+			batch := []models.Position{
+				{SessionID: "a", PlayerID: "1", MatchID: m.ID, X: 10, Y: 10, At: old},
+				{SessionID: "a", PlayerID: "1", MatchID: m.ID, X: 12, Y: 12, At: old},
+				{SessionID: "a", PlayerID: "1", MatchID: m.ID, X: 990, Y: 590, At: old},
+				{SessionID: "b", PlayerID: "2", X: 10, Y: 10, At: old}, // not in a match
+				{SessionID: "a", PlayerID: "1", MatchID: m.ID, X: 10, Y: 10, At: time.Now()},
+			}
+			if err := s.InsertPlayerPositions(batch); err != nil {
+				t.Fatal(err)
+			}
+			cells := []models.HeatmapCell{
+				{MatchID: m.ID, Room: "main", Kind: heatmap.Deaths, X: 3, Y: 4, Hits: 2},
+				{MatchID: m.ID, Room: "main", Kind: heatmap.Deaths, X: 3, Y: 4, Hits: 1},
+			}
+			if err := s.AddHeatmapCells(cells); err != nil {
+				t.Fatal(err)
+			}
+
+			before, err := s.Heatmap("main", heatmap.Positions, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before.Total != 4 || before.Cells[0][0] != 3 || before.Cells[heatmap.Rows-1][heatmap.Columns-1] != 1 {
+				t.Fatalf("raw positions heatmap is off, total %d", before.Total)
+			}
+
+			// two at a time, the old rows take two passes and the third finds nothing
+			cutoff := time.Now().Add(-time.Hour)
+			for i, want := range []int{2, 2, 0} {
+				n, err := s.DownsamplePositions(cutoff, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != want {
+					t.Errorf("pass %d downsampled %d rows, want %d", i, n, want)
+				}
+			}
+			after, err := s.Heatmap("main", heatmap.Positions, m.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Total != before.Total || after.Cells[0][0] != 3 {
+				t.Errorf("downsampling changed the heatmap, total %d then %d", before.Total, after.Total)
+			}
+
+			deaths, err := s.Heatmap("main", heatmap.Deaths, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deaths.Total != 3 || deaths.Cells[4][3] != 3 {
+				t.Errorf("death hits didn't add up, total %d", deaths.Total)
+			}
+			if other, _ := s.Heatmap("other", heatmap.Deaths, 0); other == nil || other.Total != 0 {
+				t.Errorf("another room got main's hits")
+			}
+		})
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			at := time.Now().Truncate(time.Millisecond)
+			event := func(typ models.EventType, matchID int64, after time.Duration, details string) models.Event {
+				e := models.Event{SessionID: "a", PlayerID: "1", MatchID: matchID, Room: "main", Type: typ, At: at.Add(after), X: 1, Y: 2}
+				if details != "" {
+					e.Details = []byte(details)
+				}
+				return e
+			}
+			// logged out of order, and the last two in the same millisecond
+			batch := []models.Event{
+				event(models.EventDeath, 1, 2*time.Second, `{"effects":["speed_boost"]}`),
+				event(models.EventSpawn, 1, 0, ""),
+				event(models.EventSpawn, 2, time.Minute, ""),
+				event(models.EventDisconnect, 2, time.Minute, `{"reason":"closed"}`),
+			}
+			if err := s.InsertEvents(batch); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.InsertEvents([]models.Event{{SessionID: "b", Type: models.EventSpawn, At: at}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.InsertPickups([]models.Pickup{{PickupID: "w1", Kind: "weapon", Type: "shotgun", MatchID: 1, Room: "main", SpawnedAt: at, SessionID: "a", PickedAt: at}}); err != nil {
+				t.Fatal(err)
+			}
+
+			all, err := s.Timeline("a", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []models.EventType{models.EventSpawn, models.EventDeath, models.EventSpawn, models.EventDisconnect}
+			if len(all) != len(want) {
+				t.Fatalf("%d events, want %d", len(all), len(want))
+			}
+			for i, e := range all {
+				if e.Type != want[i] {
+					t.Errorf("event %d is %s, want %s", i, e.Type, want[i])
+				}
+			}
+			if !all[1].At.Equal(at.Add(2*time.Second)) || all[1].Room != "main" || all[1].X != 1 {
+				t.Errorf("death came back as %+v", all[1])
+			}
+			if string(all[1].Details) != `{"effects":["speed_boost"]}` || all[0].Details != nil {
+				t.Errorf("details came back as %q and %q", all[1].Details, all[0].Details)
+			}
+
+			first, err := s.Timeline("a", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(first) != 2 || first[0].MatchID != 1 {
+				t.Errorf("match 1 has %d events, want 2", len(first))
+			}
+		})
+	}
+}
+
+// the telemetry writer's batches go up to 5000 rows, more parameters than sqlite takes in
+// one statement. the limit's lowered so a small batch needs splitting too.
+func TestLargeBatches(t *testing.T) {
+	defer func(limit int) { maxParams = limit }(maxParams)
+	maxParams = 100
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			const n = 25 // three inserts for the 9 column events, four for the 10 column pickups
+			at := time.Now()
+			events := make([]models.Event, n)
+			pickups := make([]models.Pickup, n)
+			for i := range n {
+				events[i] = models.Event{SessionID: "big", PlayerID: "1", Room: "main", Type: models.EventSpawn, At: at}
+				pickups[i] = models.Pickup{PickupID: "w1", Kind: "weapon", Type: "shotgun", Room: "main", SpawnedAt: at, SessionID: "big", PickedAt: at}
+			}
+			if err := s.InsertEvents(events); err != nil {
+				t.Fatalf("inserting %d events: %v", n, err)
+			}
+			if err := s.InsertPickups(pickups); err != nil {
+				t.Fatalf("inserting %d pickups: %v", n, err)
+			}
+			if all, err := s.Timeline("big", 0); err != nil || len(all) != n {
+				t.Errorf("%d events came back, want %d (%v)", len(all), n, err)
+			}
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if p, err := s.GetProfile("a"); err != nil || p != nil {
+				t.Fatalf("no profile yet, got %v, %v", p, err)
+			}
+			bob := models.Profile{SessionID: "a", DisplayName: "Bob", Color: 0xFF0000, Sprite: "mech"}
+			if err := s.SaveProfile(bob); err != nil {
+				t.Fatal(err)
+			}
+			if p, _ := s.GetProfile("a"); p == nil || *p != bob {
+				t.Errorf("got back %+v, want %+v", p, bob)
+			}
+			// saving again is an update, keeping your own name is fine
+			bob.Color = 0
+			if err := s.SaveProfile(bob); err != nil {
+				t.Fatal(err)
+			}
+			if p, _ := s.GetProfile("a"); p == nil || p.Color != 0 {
+				t.Errorf("update didn't stick, got %+v", p)
+			}
+
+			// names are unique whatever the case, not having one isn't a name
+			if err := s.SaveProfile(models.Profile{SessionID: "b", DisplayName: "bOB", Sprite: "ship"}); !errors.Is(err, ErrNameTaken) {
+				t.Errorf("taking someone's name gave %v, want ErrNameTaken", err)
+			}
+			for _, id := range []string{"b", "c"} {
+				if err := s.SaveProfile(models.Profile{SessionID: id, Sprite: "ship"}); err != nil {
+					t.Errorf("profile without a name: %v", err)
+				}
+			}
+			if p, _ := s.GetProfile("a"); p == nil || p.DisplayName != "Bob" {
+				t.Errorf("a failed save changed the name's owner, got %+v", p)
+			}
+			// profiles outlive the player
+			s.GetOrCreatePlayer("a")
+			s.RemovePlayer("a")
+			if p, _ := s.GetProfile("a"); p == nil {
+				t.Error("RemovePlayer took the profile with it")
+			}
+		})
+	}
+}
+
+func TestBans(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			past := time.Now().Add(-time.Hour)
+			future := time.Now().Add(time.Hour)
+			bans := []*Ban{
+				{SessionID: "session-one", Reason: "cheating"},
+				{IP: "10.0.0.1", Reason: "spam", ExpiresAt: &future},
+				{SessionID: "session-two", Reason: "served", ExpiresAt: &past},
+			}
+			for _, b := range bans {
+				if err := s.AddBan(b); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			active, err := s.ListBans()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(active) != 2 || active[0].ID != bans[1].ID {
+				t.Errorf("want the two active bans newest first, got %+v", active)
+			}
+
+			if b, _ := s.FindBan("session-one", "192.168.0.1"); b == nil || b.Reason != "cheating" {
+				t.Errorf("session ban not found, got %+v", b)
+			}
+			if b, _ := s.FindBan("someone-else", "10.0.0.1"); b == nil || b.Reason != "spam" {
+				t.Errorf("ip ban not found, got %+v", b)
+			}
+			if b, _ := s.FindBan("session-two", ""); b != nil {
+				t.Errorf("expired ban still applies: %+v", b)
+			}
+			if b, _ := s.FindBan("", ""); b != nil {
+				t.Errorf("empty session and ip matched %+v", b)
+			}
+
+			if found, _ := s.RemoveBan(bans[0].ID); !found {
+				t.Error("RemoveBan didn't find the ban")
+			}
+			if found, _ := s.RemoveBan(bans[0].ID); found {
+				t.Error("RemoveBan found a ban that was already lifted")
+			}
+		})
+	}
+}
+
+func TestMutes(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			past := time.Now().Add(-time.Hour)
+			future := time.Now().Add(time.Hour)
+			mutes := []*Mute{
+				{SessionID: "session-one", Reason: "spam"},
+				{SessionID: "session-two", Reason: "served", ExpiresAt: &past},
+				{SessionID: "session-three", Reason: "insults", ExpiresAt: &future},
+			}
+			for _, m := range mutes {
+				if err := s.AddMute(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			active, err := s.ListMutes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(active) != 2 || active[0].ID != mutes[2].ID {
+				t.Errorf("want the two active mutes newest first, got %+v", active)
+			}
+			if m, _ := s.FindMute("session-one"); m == nil || m.Reason != "spam" {
+				t.Errorf("mute not found, got %+v", m)
+			}
+			if m, _ := s.FindMute("session-two"); m != nil {
+				t.Errorf("expired mute still applies: %+v", m)
+			}
+
+			if found, _ := s.RemoveMute(mutes[0].ID); !found {
+				t.Error("RemoveMute didn't find the mute")
+			}
+			if m, _ := s.FindMute("session-one"); m != nil {
+				t.Errorf("lifted mute still applies: %+v", m)
+			}
+		})
+	}
+}
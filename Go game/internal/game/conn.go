@@ -0,0 +1,189 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"arena-tactics/internal/metrics"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	sendQueueSize     = 256              // messages waiting to be written before we start dropping
+	maxDroppedUpdates = 512              // dropped updates since the last full drain before we give up on the client
+	writeTimeout      = 5 * time.Second // a single write taking longer than this means the client is gone
+)
+
+var ErrConnClosed = errors.New("connection closed")
+
+// one queued message. key is only set for state updates that can be
+// coalesced (a newer position for the same player replaces the old one).
+type outbound struct {
+	key   string
+	data  []byte
+	close bool // sentinel from Drain: send a close frame once everything before it is written
+}
+
+// Conn owns the write side of a websocket. gorilla only allows one writer at a time
+// so everything goes through a bounded queue drained by a single goroutine.
+// that way a slow client only ever slows down itself, never the tick loop.
+type Conn struct {
+	ws        *websocket.Conn
+	mu        sync.Mutex
+	queue     []outbound
+	keys      map[string]int // coalesce key -> index in queue
+	dropped   int
+	wake      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// wraps the websocket and starts its writer goroutine
+func NewConn(ws *websocket.Conn) *Conn {
+	c := &Conn{
+		ws:     ws,
+		keys:   make(map[string]int),
+		wake:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// underlying websocket for the reader side and control frames
+func (c *Conn) WS() *websocket.Conn {
+	return c.ws
+}
+
+// Send queues an already encoded message.
+// key != "" marks a state update: it replaces any queued update with the same key
+// and gets dropped when the queue is full. messages without a key are events the
+// client can't miss, so if those don't fit the client is too far behind and gets disconnected.
+func (c *Conn) Send(data []byte, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.closed:
+		return ErrConnClosed
+	default:
+	}
+
+	if key != "" {
+		if i, ok := c.keys[key]; ok {
+			// stale update still waiting thus just swap in the newer one
+			c.queue[i].data = data
+			return nil
+		}
+	}
+
+	if len(c.queue) >= sendQueueSize {
+		if key != "" && c.dropped < maxDroppedUpdates {
+			c.dropped++
+			metrics.DroppedUpdates.Inc()
+			return nil
+		}
+		metrics.SlowClientDisconnects.Inc()
+		go c.Close()
+		return ErrConnClosed
+	}
+
+	if key != "" {
+		c.keys[key] = len(c.queue)
+	}
+	c.queue = append(c.queue, outbound{key: key, data: data})
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// encodes and queues an event message
+func (c *Conn) SendJSON(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.Send(data, "")
+}
+
+// number of messages currently waiting to be written
+func (c *Conn) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+// closes the websocket which also makes the reader side fail,
+// so the normal disconnect handling takes care of the player.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.ws.Close()
+	})
+	return err
+}
+
+// Drain lets everything already queued go out, then sends a close frame with the
+// given code and reason and closes the connection. gives up and closes anyway after timeout.
+func (c *Conn) Drain(code int, reason string, timeout time.Duration) {
+	c.mu.Lock()
+	c.queue = append(c.queue, outbound{
+		data:  websocket.FormatCloseMessage(code, reason),
+		close: true,
+	})
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-c.closed:
+	case <-time.After(timeout):
+		c.Close()
+	}
+}
+
+// Done is closed once the connection has been closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// the only goroutine that ever calls WriteMessage on the socket
+func (c *Conn) writeLoop() {
+	for {
+		select {
+		case <-c.wake:
+		case <-c.closed:
+			return
+		}
+
+		// grab everything queued so far and write it without holding the lock
+		c.mu.Lock()
+		batch := c.queue
+		c.queue = nil
+		c.keys = make(map[string]int)
+		c.dropped = 0
+		c.mu.Unlock()
+
+		for _, msg := range batch {
+			if msg.close {
+				c.ws.WriteControl(websocket.CloseMessage, msg.data, time.Now().Add(writeTimeout))
+				c.Close()
+				return
+			}
+			c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := c.ws.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				c.Close()
+				return
+			}
+			metrics.BytesSent.Add(float64(len(msg.data)))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package game
+
+import (
+	"sort"
+	"time"
+)
+
+// Stacking decides what picking up an effect you already have does
+type Stacking int
+
+const (
+	StackRefresh   Stacking = iota // the timer starts over
+	StackExtend                    // the new duration is added to what's left
+	StackIntensity                 // one more stack (up to MaxStacks) and the timer starts over
+	StackIgnore                    // nothing, the pickup is left on the ground
+)
+
+// EffectDef describes one kind of timed status effect. the hooks are all optional.
+type EffectDef struct {
+	Name      string
+	Duration  time.Duration // 0 lasts until something removes it (a used teleport, a broken shield, death)
+	Stacking  Stacking
+	MaxStacks int           // for StackIntensity, 0 means no limit
+	Cooldown  time.Duration // after it ends it can't be applied again for this long
+	Magnitude float64       // what the effect scales by (speed, damage...), 1 if unused
+
+	OnApply  func(p *Player, e *Effect)             // first applied and every restack
+	OnTick   func(p *Player, e *Effect, dt float64) // every simulation tick while active
+	OnExpire func(p *Player, e *Effect)             // ran out, was used up or removed
+}
+
+// Effect is one active effect on a player
+type Effect struct {
+	Def       *EffectDef
+	Stacks    int
+	StartedAt time.Time
+	Expires   time.Time // zero for effects without a duration
+	Acc       float64   // scratch space for OnTick (regen keeps its fractional health here)
+}
+
+// Remaining is how long the effect has left, 0 for effects without a duration
+func (e *Effect) Remaining(now time.Time) time.Duration {
+	if e.Expires.IsZero() {
+		return 0
+	}
+	return max(0, e.Expires.Sub(now))
+}
+
+// HasEffect reports whether the named effect is active
+func (p *Player) HasEffect(name string) bool {
+	_, ok := p.Effects[name]
+	return ok
+}
+
+// CooldownLeft is how long until the named effect can be applied again
+func (p *Player) CooldownLeft(name string, now time.Time) time.Duration {
+	return max(0, p.Cooldowns[name].Sub(now))
+}
+
+// StartCooldown puts the named effect (or an action that uses it) on cooldown for d
+func (p *Player) StartCooldown(name string, d time.Duration, now time.Time) {
+	if d <= 0 {
+		return
+	}
+	if p.Cooldowns == nil {
+		p.Cooldowns = make(map[string]time.Time)
+	}
+	p.Cooldowns[name] = now.Add(d)
+}
+
+// ApplyEffect adds the effect or restacks it following its stacking rule.
+// returns nil when nothing changed (still cooling down, or StackIgnore).
+func (p *Player) ApplyEffect(def *EffectDef, now time.Time) *Effect {
+	if p.CooldownLeft(def.Name, now) > 0 {
+		return nil
+	}
+	if p.Effects == nil {
+		p.Effects = make(map[string]*Effect)
+	}
+	e, ok := p.Effects[def.Name]
+	if !ok {
+		e = &Effect{Def: def, Stacks: 1, StartedAt: now}
+		if def.Duration > 0 {
+			e.Expires = now.Add(def.Duration)
+		}
+		p.Effects[def.Name] = e
+	} else {
+		switch def.Stacking {
+		case StackIgnore:
+			return nil
+		case StackExtend:
+			if def.Duration > 0 {
+				e.Expires = e.Expires.Add(def.Duration)
+			}
+		case StackIntensity:
+			if def.MaxStacks == 0 || e.Stacks < def.MaxStacks {
+				e.Stacks++
+			}
+			fallthrough
+		case StackRefresh:
+			if def.Duration > 0 {
+				e.Expires = now.Add(def.Duration)
+			}
+		}
+		// pick up the latest settings
+		e.Def = def
+	}
+	if def.OnApply != nil {
+		def.OnApply(p, e)
+	}
+	return e
+}
+
+// RemoveEffect ends the named effect early (used up, broken) and starts its cooldown.
+// returns false if it wasn't active.
+func (p *Player) RemoveEffect(name string, now time.Time) bool {
+	e, ok := p.Effects[name]
+	if !ok {
+		return false
+	}
+	p.endEffect(e, now)
+	return true
+}
+
+// TickEffects runs every active effect's OnTick and ends the ones that ran out.
+// returns the names of the effects that ended.
+func (p *Player) TickEffects(now time.Time, dt float64) []string {
+	var ended []string
+	for name, e := range p.Effects {
+		if !e.Expires.IsZero() && !now.Before(e.Expires) {
+			p.endEffect(e, now)
+			ended = append(ended, name)
+			continue
+		}
+		if e.Def.OnTick != nil {
+			e.Def.OnTick(p, e, dt)
+		}
+	}
+	sort.Strings(ended)
+	return ended
+}
+
+// ClearEffects ends everything without starting cooldowns, for death and respawn.
+// returns the names of the effects that ended.
+func (p *Player) ClearEffects() []string {
+	var ended []string
+	for name, e := range p.Effects {
+		delete(p.Effects, name)
+		if e.Def.OnExpire != nil {
+			e.Def.OnExpire(p, e)
+		}
+		ended = append(ended, name)
+	}
+	sort.Strings(ended)
+	return ended
+}
+
+// Magnitude multiplies together the magnitude of every stack of the named effect, 1 when inactive
+func (p *Player) Magnitude(name string) float64 {
+	m := 1.0
+	if e, ok := p.Effects[name]; ok && e.Def.Magnitude != 0 {
+		for range e.Stacks {
+			m *= e.Def.Magnitude
+		}
+	}
+	return m
+}
+
+func (p *Player) endEffect(e *Effect, now time.Time) {
+	delete(p.Effects, e.Def.Name)
+	p.StartCooldown(e.Def.Name, e.Def.Cooldown, now)
+	if e.Def.OnExpire != nil {
+		e.Def.OnExpire(p, e)
+	}
+}
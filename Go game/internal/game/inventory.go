@@ -0,0 +1,90 @@
+package game
+
+import "time"
+
+// how many weapons a player can carry
+const InventorySlots = 2
+
+// ResetInventory leaves the player holding just the one weapon, for spawns and respawns
+func (p *Player) ResetInventory(weapon string) {
+	p.Weapons = [InventorySlots]string{weapon}
+	p.ActiveSlot = 0
+	p.Weapon = weapon
+	p.SwitchReadyAt = time.Time{}
+	p.Heat = [InventorySlots]float64{}
+	p.Overheated = [InventorySlots]bool{}
+}
+
+// HasWeapon reports whether the weapon is in either slot
+func (p *Player) HasWeapon(weapon string) bool {
+	for _, w := range p.Weapons {
+		if w == weapon {
+			return true
+		}
+	}
+	return false
+}
+
+// WeaponHeat is how hot a weapon is. it stays with the weapon when it's dropped, so
+// dropping an overheated gun and grabbing it again doesn't cool it.
+type WeaponHeat struct {
+	Heat       float64
+	Overheated bool
+}
+
+// PickUpWeapon puts the weapon in an empty slot, or in place of the one in hand when both
+// are full, and switches to it. returns the weapon that got swapped out, if any, and its heat.
+func (p *Player) PickUpWeapon(weapon string, heat WeaponHeat) (dropped string, droppedHeat WeaponHeat) {
+	slot := p.ActiveSlot
+	for i, w := range p.Weapons {
+		if w == "" {
+			slot = i
+			break
+		}
+	}
+	dropped, droppedHeat = p.Weapons[slot], p.heatOf(slot)
+	p.Weapons[slot] = weapon
+	p.Heat[slot], p.Overheated[slot] = heat.Heat, heat.Overheated
+	p.ActiveSlot = slot
+	p.Weapon = weapon
+	return dropped, droppedHeat
+}
+
+func (p *Player) heatOf(slot int) WeaponHeat {
+	return WeaponHeat{Heat: p.Heat[slot], Overheated: p.Overheated[slot]}
+}
+
+// SwitchWeapon makes slot the active one. firing is blocked until delay has passed.
+// false if the slot is out of range, empty or already in hand.
+func (p *Player) SwitchWeapon(slot int, now time.Time, delay time.Duration) bool {
+	if slot < 0 || slot >= InventorySlots || slot == p.ActiveSlot || p.Weapons[slot] == "" {
+		return false
+	}
+	p.ActiveSlot = slot
+	p.Weapon = p.Weapons[slot]
+	p.SwitchReadyAt = now.Add(delay)
+	// the new weapon starts spinning up from scratch
+	p.TriggerSince = now
+	return true
+}
+
+// DropWeapon empties the active slot and switches to the other weapon, returning what
+// was dropped and how hot it was. you can't drop your last weapon, in that case it returns "".
+func (p *Player) DropWeapon(now time.Time, delay time.Duration) (string, WeaponHeat) {
+	for i, w := range p.Weapons {
+		if i == p.ActiveSlot || w == "" {
+			continue
+		}
+		dropped, heat := p.Weapons[p.ActiveSlot], p.heatOf(p.ActiveSlot)
+		p.Weapons[p.ActiveSlot] = ""
+		p.Heat[p.ActiveSlot], p.Overheated[p.ActiveSlot] = 0, false
+		p.SwitchWeapon(i, now, delay)
+		return dropped, heat
+	}
+	return "", WeaponHeat{}
+}
+
+// CanFire is false while a weapon switch is still in progress
+func (p *Player) CanFire(now time.Time) bool {
+	return !now.Before(p.SwitchReadyAt)
+}
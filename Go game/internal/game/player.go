@@ -1,49 +1,76 @@
 package game
 
 import (
-	"sync"
 	"time"
-	"github.com/gorilla/websocket"
+
+	"arena-tactics/internal/metrics"
 )
 
 type Player struct {
-	Conn                  *websocket.Conn
-	ConnMu                sync.Mutex
-	ID                    string
-	GameID                string
-	DBID                  int    
-	SessionID             string 
-	Username              string 
-	Kills                 int   
-	Deaths                int   
-	Score                 int   
-	IsIncognito           bool
-	Position              Position
-	LastKnownPosition     Position
-	PendingPosition       *Position
-	Color                 int
-	WriteMu               sync.Mutex
-	Health                int
-	Weapon                string
-	IsDead                bool
-	DeathTime             time.Time
-	DeathTimer            *time.Timer
-	Velocity              Velocity
-	Rotation              float64
-	ForceFieldActive      bool
-	Shield                int
-	HealthRegenActive     bool
-	RegenExpiry           time.Time
-	TeleportAvailable     bool
-	HealthRegenAccumulator float64
+	Conn              *Conn
+	ID                string
+	GameID            string
+	DBID              int
+	SessionID         string
+	IP                string // where they connected from, for admin kicks and bans
+	Username          string
+	Kills             int
+	Deaths            int
+	Score             int
+	IsBot             bool // driven by the server, no connection and nothing in the database
+	IsIncognito       bool
+	Position          Position
+	LastKnownPosition Position
+	PendingPosition   *Position
+	Color             int
+	Sprite            string    // soldier, mech... see profile.Sprites
+	ProfileSavedAt    time.Time // profile changes are rate limited
+	Team              string    // teammates share a team chat, empty in free for all
+	Muted             bool      // can play but not chat
+	MutedUntil        time.Time // zero when the mute is for good
+	ChatAllowance     float64   // messages they can send right now, refills over time
+	ChatCheckedAt     time.Time // when ChatAllowance was last topped up
+	Health            int
+	Weapon            string                 // the one in hand, always Weapons[ActiveSlot]
+	Weapons           [InventorySlots]string // what they're carrying, "" for an empty slot
+	ActiveSlot        int
+	SwitchReadyAt     time.Time // can't fire until a weapon switch finishes
+	TriggerHeld       bool      // automatic weapons keep firing while this is set
+	TriggerSince      time.Time // when the trigger went down, drives spin up
+	Aim               float64   // latest aim in radians from the trigger messages
+	NextShotAt        time.Time
+	Heat              [InventorySlots]float64 // 0 to 1 per slot, each weapon heats and cools on its own
+	Overheated        [InventorySlots]bool    // set at full heat, cleared once the weapon is back to cold
+	IsDead            bool
+	DeathTime         time.Time
+	DeathTimer        *time.Timer
+	DisconnectTimer   *time.Timer          // grace window after a dropped connection
+	DamagedBy         map[string]time.Time // attacker id -> last hit, for assists. cleared on death
+	Velocity          Velocity
+	Rotation          float64
+	// the flags below are kept in step by the effect hooks so the protocol stays the same
+	ForceFieldActive  bool
+	Shield            int
+	HealthRegenActive bool
+	TeleportAvailable bool
+	Effects           map[string]*Effect   // active status effects by name
+	Cooldowns         map[string]time.Time // effect name -> when it can be applied again
+	RTT               time.Duration        // round trip time from the last ping/pong
+	LastPong          time.Time
 }
 
-func (p *Player) SendMessage(msg interface{}) error {
-	p.ConnMu.Lock()
-	defer p.ConnMu.Unlock()
+// messages that know their own type get counted per type in the metrics
+type typedMessage interface {
+	MessageType() string
+}
 
+// queues a message for this player, it never blocks on the network
+func (p *Player) SendMessage(msg interface{}) error {
 	if p.Conn != nil {
-		return p.Conn.WriteJSON(msg)
+		if m, ok := msg.(typedMessage); ok {
+			metrics.MessagesOut.WithLabelValues(m.MessageType()).Inc()
+		}
+		return p.Conn.SendJSON(msg)
 	}
 	return nil
-}
\ No newline at end of file
+}
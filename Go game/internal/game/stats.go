@@ -0,0 +1,33 @@
+package game
+
+import "time"
+
+// MatchStats is one player's record for the current match. it lives on the room,
+// not the player, so it survives a disconnect and is wiped when a new match starts.
+type MatchStats struct {
+	PlayerID       string   `json:"player_id"`
+	Username       string   `json:"username"`
+	Kills          int      `json:"kills"`
+	Deaths         int      `json:"deaths"`
+	Assists        int      `json:"assists"`
+	ShotsFired     int      `json:"shots_fired"`
+	ShotsHit       int      `json:"shots_hit"`
+	Accuracy       float64  `json:"accuracy"` // hits / shots, filled in by Finalize
+	DamageDealt    int      `json:"damage_dealt"`
+	DamageTaken    int      `json:"damage_taken"`
+	ShieldAbsorbed int      `json:"shield_absorbed"` // damage their force field soaked up
+	Streak         int      `json:"streak"`          // kills since their last death
+	LongestStreak  int      `json:"longest_streak"`
+	BestMultiKill  int      `json:"best_multi_kill"`   // most kills chained inside the multi kill window
+	Rewards        []string `json:"rewards,omitempty"` // killstreak rewards earned, in order
+
+	MultiKill  int       `json:"-"` // kills in the current chain
+	LastKillAt time.Time `json:"-"`
+}
+
+// Finalize works out the derived fields before the stats are sent anywhere
+func (s *MatchStats) Finalize() {
+	if s.ShotsFired > 0 {
+		s.Accuracy = float64(s.ShotsHit) / float64(s.ShotsFired)
+	}
+}
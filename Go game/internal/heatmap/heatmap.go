@@ -0,0 +1,117 @@
+package heatmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// the arena is 1000x600 (the spawn code keeps everyone in there), heatmaps cut it into
+// square cells. changing CellSize makes the cells already in the database meaningless.
+const (
+	Width    = 1000
+	Height   = 600
+	CellSize = 25
+	Columns  = Width / CellSize
+	Rows     = Height / CellSize
+)
+
+// what a heatmap counts
+const (
+	Positions = "position" // where players spend their time
+	Kills     = "kill"     // where killers stood
+	Deaths    = "death"    // where players died
+)
+
+// ValidKind is true for the three kinds of heatmap
+func ValidKind(kind string) bool {
+	return kind == Positions || kind == Kills || kind == Deaths
+}
+
+// CellOf is the column and row a point falls in. points off the map count towards the
+// nearest edge cell so nothing gets lost.
+func CellOf(x, y float64) (col, row int) {
+	clamp := func(v float64, cells int) int {
+		c := int(math.Floor(v / CellSize))
+		return max(0, min(cells-1, c))
+	}
+	return clamp(x, Columns), clamp(y, Rows)
+}
+
+// Grid is a heatmap, one counter per cell. Cells is indexed [row][col].
+type Grid struct {
+	Room     string  `json:"room"`
+	Kind     string  `json:"kind"`
+	MatchID  int64   `json:"match_id,omitempty"` // 0 means every match in the room
+	CellSize int     `json:"cell_size"`
+	Total    int     `json:"total"`
+	Max      int     `json:"max"` // the busiest cell
+	Cells    [][]int `json:"cells"`
+}
+
+func NewGrid(room, kind string, matchID int64) *Grid {
+	cells := make([][]int, Rows)
+	for i := range cells {
+		cells[i] = make([]int, Columns)
+	}
+	return &Grid{Room: room, Kind: kind, MatchID: matchID, CellSize: CellSize, Cells: cells}
+}
+
+// Add counts hits in a cell, cells outside the grid are clamped like in CellOf
+func (g *Grid) Add(col, row, hits int) {
+	col = max(0, min(Columns-1, col))
+	row = max(0, min(Rows-1, row))
+	g.Cells[row][col] += hits
+	g.Total += hits
+	g.Max = max(g.Max, g.Cells[row][col])
+}
+
+// AddPoint counts one hit wherever the point falls
+func (g *Grid) AddPoint(x, y float64) {
+	col, row := CellOf(x, y)
+	g.Add(col, row, 1)
+}
+
+// WritePNG draws the grid with scale pixels per cell. empty cells are transparent so the
+// image can go on top of a screenshot of the arena, the rest go from blue to red.
+// intensity is the square root of the share of the busiest cell, otherwise one choke point
+// washes out everything else.
+func (g *Grid) WritePNG(w io.Writer, scale int) error {
+	if scale < 1 {
+		return fmt.Errorf("scale must be at least 1, got %d", scale)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, Columns*scale, Rows*scale))
+	for row := range g.Cells {
+		for col, hits := range g.Cells[row] {
+			if hits == 0 {
+				continue
+			}
+			c := ramp(math.Sqrt(float64(hits) / float64(g.Max)))
+			for y := row * scale; y < (row+1)*scale; y++ {
+				for x := col * scale; x < (col+1)*scale; x++ {
+					img.SetNRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// blue (cold) through green and yellow to red (hot), t is 0 to 1
+func ramp(t float64) color.NRGBA {
+	stops := []color.NRGBA{
+		{0, 0, 255, 140},
+		{0, 255, 0, 170},
+		{255, 255, 0, 200},
+		{255, 0, 0, 230},
+	}
+	t = max(0, min(1, t)) * float64(len(stops)-1)
+	i := min(int(t), len(stops)-2)
+	f := t - float64(i)
+	lerp := func(a, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*f) }
+	a, b := stops[i], stops[i+1]
+	return color.NRGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the subsystems we log under. every one of them has its own level
+// so we can turn the sim up to debug without drowning in network noise.
+const (
+	SubsystemNet   = "net"   // websocket connections and messages
+	SubsystemSim   = "sim"   // the tick loop: combat, movement, collisions, regen
+	SubsystemDB    = "db"    // database writes and lookups
+	SubsystemSpawn = "spawn" // weapon and powerup spawning
+	SubsystemMain  = "main"  // startup, config and shutdown
+)
+
+var (
+	mu     sync.RWMutex
+	output slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+	levels              = map[string]*slog.LevelVar{
+		SubsystemNet:   new(slog.LevelVar),
+		SubsystemSim:   new(slog.LevelVar),
+		SubsystemDB:    new(slog.LevelVar),
+		SubsystemSpawn: new(slog.LevelVar),
+		SubsystemMain:  new(slog.LevelVar),
+	}
+)
+
+// one logger per subsystem, safe to use before Setup (plain text to stderr until then)
+var (
+	Net   = newLogger(SubsystemNet)
+	Sim   = newLogger(SubsystemSim)
+	DB    = newLogger(SubsystemDB)
+	Spawn = newLogger(SubsystemSpawn)
+	Main  = newLogger(SubsystemMain)
+)
+
+// filters on the subsystem's level and then hands the record to whatever output Setup picked
+type subsystemHandler struct {
+	level *slog.LevelVar
+	attrs []slog.Attr
+	group string
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	mu.RLock()
+	out := output
+	mu.RUnlock()
+	out = out.WithAttrs(h.attrs)
+	if h.group != "" {
+		out = out.WithGroup(h.group)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.group = name
+	return &clone
+}
+
+func newLogger(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{
+		level: levels[subsystem],
+		attrs: []slog.Attr{slog.String("subsystem", subsystem)},
+	})
+}
+
+// Setup picks the output format ("text" or "json") and the starting levels.
+// levels is either a single level for everything ("debug") or a comma separated
+// list of overrides ("info,sim=debug,db=warn").
+func Setup(w io.Writer, format, levelSpec string) error {
+	var out slog.Handler
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug} // the per subsystem levels do the filtering
+	switch format {
+	case "", "text":
+		out = slog.NewTextHandler(w, opts)
+	case "json":
+		out = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	if err := applyLevels(levelSpec); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	output = out
+	mu.Unlock()
+
+	// anything still using the log package ends up in the same place
+	slog.SetDefault(Main)
+	return nil
+}
+
+func applyLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl, found := strings.Cut(part, "=")
+		if !found {
+			// bare level applies to every subsystem
+			lvl = name
+			name = ""
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(lvl)); err != nil {
+			return fmt.Errorf("bad log level %q: %v", lvl, err)
+		}
+		if name == "" {
+			for _, v := range levels {
+				v.Set(level)
+			}
+			continue
+		}
+		if err := SetLevel(name, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLevel changes a subsystem's level on the fly
+func SetLevel(subsystem string, level slog.Level) error {
+	v, ok := levels[subsystem]
+	if !ok {
+		return fmt.Errorf("unknown subsystem %q", subsystem)
+	}
+	v.Set(level)
+	return nil
+}
+
+// Levels reports the current level of every subsystem
+func Levels() map[string]string {
+	out := make(map[string]string, len(levels))
+	for name, v := range levels {
+		out[name] = v.Level().String()
+	}
+	return out
+}
+
+// LevelHandler lets operators read and change the levels at runtime:
+//
+//	GET  /admin/log-levels                      -> {"net":"INFO","sim":"INFO",...}
+//	POST /admin/log-levels?subsystem=sim&level=debug
+//	POST /admin/log-levels?level=warn           (every subsystem)
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			spec := r.URL.Query().Get("level")
+			if sub := r.URL.Query().Get("subsystem"); sub != "" {
+				spec = sub + "=" + spec
+			}
+			if err := applyLevels(spec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			Main.Info("log levels changed", "levels", spec, "remote", r.RemoteAddr)
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Levels())
+	})
+}
+
+// Sampler lets through at most one line per key per interval.
+// meant for debug lines inside the tick loop that would otherwise fire 60 times a second.
+type Sampler struct {
+	every time.Duration
+	mu    sync.Mutex
+	last  map[string]time.Time
+}
+
+func NewSampler(every time.Duration) *Sampler {
+	return &Sampler{every: every, last: make(map[string]time.Time)}
+}
+
+// Allow reports whether a line for key should be logged now
+func (s *Sampler) Allow(key string) bool {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.last[key]) < s.every {
+		return false
+	}
+	// keys are usually player ids, forget them all now and then so the map can't grow forever
+	if len(s.last) > 4096 {
+		s.last = make(map[string]time.Time)
+	}
+	s.last[key] = now
+	return true
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// everything is registered on the default registry, which also gives us the
+// go runtime and process (cpu, memory, fds) collectors for free.
+var (
+	ConnectedPlayers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "arena_connected_players",
+		Help: "Players with an open websocket, per room.",
+	}, []string{"room"})
+
+	Rooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "arena_rooms",
+		Help: "Rooms currently running.",
+	})
+
+	// buckets are tuned around the 16.6ms budget of a 60Hz tick
+	TickDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "arena_tick_duration_seconds",
+		Help:    "Time spent simulating a single tick.",
+		Buckets: []float64{.0005, .001, .002, .004, .008, .0166, .033, .066, .1},
+	}, []string{"room"})
+
+	TickOverruns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "arena_tick_overruns_total",
+		Help: "Ticks that took longer than the tick interval.",
+	}, []string{"room"})
+
+	MessagesIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "arena_messages_received_total",
+		Help: "Websocket messages received from clients, by message type.",
+	}, []string{"type"})
+
+	MessagesOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "arena_messages_sent_total",
+		Help: "Websocket messages queued for clients, by message type.",
+	}, []string{"type"})
+
+	BytesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "arena_bytes_sent_total",
+		Help: "Bytes written to websockets.",
+	})
+
+	DroppedUpdates = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "arena_dropped_updates_total",
+		Help: "State updates dropped because a client's send queue was full.",
+	})
+
+	SlowClientDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "arena_slow_client_disconnects_total",
+		Help: "Clients disconnected for falling too far behind.",
+	})
+
+	UpgradeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "arena_websocket_upgrade_failures_total",
+		Help: "HTTP requests that failed to upgrade to a websocket.",
+	})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "arena_db_query_duration_seconds",
+		Help:    "Database call latency, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+
+	DBErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "arena_db_errors_total",
+		Help: "Failed database calls, by operation.",
+	}, []string{"op"})
+
+	TelemetryQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "arena_telemetry_queued",
+		Help: "Telemetry records waiting to be written.",
+	})
+
+	TelemetryWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "arena_telemetry_written_total",
+		Help: "Telemetry records written to the database.",
+	})
+
+	TelemetryDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "arena_telemetry_dropped_total",
+		Help: "Telemetry records thrown away, by reason (queue_full, write_failed, closed).",
+	}, []string{"reason"})
+)
+
+// Handler serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveDB records how long a database call took and whether it failed.
+// sql.ErrNoRows is a normal answer, not a failure.
+func ObserveDB(op string, start time.Time, err error) {
+	DBQueryDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		DBErrors.WithLabelValues(op).Inc()
+	}
+}
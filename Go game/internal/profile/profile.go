@@ -0,0 +1,105 @@
+// Package profile has the rules for what players can call themselves and look like.
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"arena-tactics/internal/wordfilter"
+)
+
+// display names, counted in characters (they're ascii anyway)
+const (
+	MinNameLength = 3
+	MaxNameLength = 16
+)
+
+// what a player can look like. the client draws each one, see web/src/game
+var Sprites = []string{"soldier", "mech", "ship", "creature"}
+
+// DefaultSprite is what players get until they pick one
+const DefaultSprite = "soldier"
+
+// letters, digits, spaces, underscores, dashes and dots. ascii only so nobody can pass for
+// someone else with a lookalike letter
+var nameChars = regexp.MustCompile(`^[A-Za-z0-9 _.-]+$`)
+
+// names nobody gets to pick. default names (Player_...) are reserved too so a chosen name
+// can't pass for someone who hasn't picked one, and bots are "[BOT] ..." which the charset
+// already keeps out.
+var reserved = []string{"admin", "administrator", "moderator", "mod", "server", "system", "bot"}
+
+const defaultPrefix = "player_"
+
+var filter = wordfilter.New()
+
+// ValidateName tidies a display name up (surrounding spaces trimmed, runs of spaces squashed)
+// and checks it against the rules, the error says what's wrong in words a player understands.
+// it doesn't know whether the name is taken, the store does.
+func ValidateName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	switch {
+	case len(name) < MinNameLength || len(name) > MaxNameLength:
+		return "", fmt.Errorf("names are %d to %d characters long", MinNameLength, MaxNameLength)
+	case !nameChars.MatchString(name):
+		return "", errors.New("names can only use letters, numbers, spaces, _ - and .")
+	case !strings.ContainsFunc(name, isLetter):
+		return "", errors.New("names need at least one letter")
+	case slices.Contains(reserved, strings.ToLower(name)) || strings.HasPrefix(strings.ToLower(name), defaultPrefix):
+		return "", errors.New("that name is reserved")
+	case filter.Contains(name):
+		return "", errors.New("that name isn't allowed")
+	}
+	return name, nil
+}
+
+func isLetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// ValidColor is true for a 24 bit rgb color
+func ValidColor(c int) bool {
+	return c >= 0 && c <= 0xFFFFFF
+}
+
+// ValidSprite is true for one of Sprites
+func ValidSprite(s string) bool {
+	return slices.Contains(Sprites, s)
+}
+
+// DefaultColor is the color a session gets until it picks one. it comes from the session id
+// so it's the same on every join, and it's always bright enough to see on the dark map.
+func DefaultColor(sessionID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(sessionID))
+	return hsv(float64(h.Sum32()%360), 0.7, 0.95)
+}
+
+// hue in degrees, saturation and value 0 to 1
+func hsv(h, s, v float64) int {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	channel := func(f float64) int { return int(math.Round((f + m) * 255)) }
+	return channel(r)<<16 | channel(g)<<8 | channel(b)
+}
@@ -0,0 +1,46 @@
+package profile
+
+import "testing"
+
+func TestValidateName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Bob":               "Bob",
+		"  Big   Bob  ":     "Big Bob",
+		"x_X-1.0":           "x_X-1.0",
+		"Scunthorpe Fan":    "Scunthorpe Fan", // ordinary words with a bad one inside
+		"Dickens Reader":    "Dickens Reader",
+		"xXfuckXx":          "",
+		"big dick":          "",
+		"Bo":                "",
+		"a very long name!": "",
+		"Bøb":               "",
+		"1234":              "",
+		"admin":             "",
+		"player_1234":       "",
+		"sh1tlord":          "",
+		"f.u.c.k":           "",
+		"FUUUCK":            "",
+		"[BOT] Bob":         "",
+	} {
+		got, err := ValidateName(name)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%q was allowed as %q", name, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%q gave %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestDefaultColor(t *testing.T) {
+	a := DefaultColor("session-a")
+	if a != DefaultColor("session-a") {
+		t.Error("the same session got two colors")
+	}
+	if !ValidColor(a) || a == DefaultColor("session-b") {
+		t.Errorf("colors %06x and %06x", a, DefaultColor("session-b"))
+	}
+}
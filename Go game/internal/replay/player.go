@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// playback speed limits
+const (
+	MinSpeed = 0.5
+	MaxSpeed = 4.0
+)
+
+// Control is what a viewer sends to steer playback
+type Control struct {
+	Type  string  `json:"type"` // "speed", "seek", "pause" or "play"
+	Speed float64 `json:"speed,omitempty"`
+	At    int64   `json:"at,omitempty"` // milliseconds from the start, for seek
+}
+
+// sent to the viewer next to the recorded messages so it can draw a timeline
+type status struct {
+	Type     string  `json:"type"` // replay_info, replay_state or replay_end
+	Header   *Header `json:"header,omitempty"`
+	Duration int64   `json:"duration"`
+	At       int64   `json:"at"`
+	Speed    float64 `json:"speed"`
+	Paused   bool    `json:"paused"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type playback struct {
+	rp      *Replay
+	send    func([]byte) error
+	frames  *frameReader // positioned just after pending
+	pending *Frame       // next frame to send, nil at the end
+	at      int64        // playhead in ms as of base
+	base    time.Time    // wall clock time the playhead was last set
+	speed   float64
+	paused  bool
+}
+
+// Play streams rp to a viewer through send, paced like the original match and
+// steered by controls, until ctx is cancelled, controls is closed or send fails.
+func Play(ctx context.Context, rp *Replay, send func([]byte) error, controls <-chan Control) error {
+	p := &playback{rp: rp, send: send, speed: 1, base: time.Now()}
+	defer func() {
+		if p.frames != nil {
+			p.frames.close()
+		}
+	}()
+	if err := p.status("replay_info", ""); err != nil {
+		return err
+	}
+	if err := p.seek(0); err != nil {
+		return err
+	}
+
+	ended := false
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		timer.Stop()
+		var due <-chan time.Time
+		if !p.paused && p.pending != nil {
+			wait := float64(p.pending.At-p.position()) / p.speed
+			timer.Reset(time.Duration(wait * float64(time.Millisecond)))
+			due = timer.C
+		}
+		if p.pending == nil && !ended {
+			ended = true
+			if err := p.status("replay_end", ""); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case c, ok := <-controls:
+			if !ok {
+				return nil
+			}
+			if err := p.control(c); err != nil {
+				return err
+			}
+			ended = false
+		case <-due:
+			for _, msg := range p.pending.Messages {
+				if err := p.send(msg); err != nil {
+					return err
+				}
+			}
+			p.setPosition(p.pending.At)
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reads the frame after pending off the disk
+func (p *playback) advance() error {
+	var frame Frame
+	ok, err := p.frames.decode(&frame)
+	if err != nil {
+		return err
+	}
+	p.pending = nil
+	if ok {
+		p.pending = &frame
+	}
+	return nil
+}
+
+// where the playhead is right now
+func (p *playback) position() int64 {
+	if p.paused {
+		return p.at
+	}
+	return p.at + int64(float64(time.Since(p.base).Milliseconds())*p.speed)
+}
+
+func (p *playback) setPosition(at int64) {
+	p.at = at
+	p.base = time.Now()
+}
+
+func (p *playback) control(c Control) error {
+	switch c.Type {
+	case "speed":
+		if c.Speed < MinSpeed || c.Speed > MaxSpeed {
+			return p.status("replay_state", fmt.Sprintf("speed must be between %v and %v", MinSpeed, MaxSpeed))
+		}
+		p.setPosition(p.position())
+		p.speed = c.Speed
+	case "pause":
+		p.setPosition(p.position())
+		p.paused = true
+	case "play":
+		p.paused = false
+		p.setPosition(p.at)
+	case "seek":
+		return p.seek(c.At)
+	default:
+		return p.status("replay_state", fmt.Sprintf("unknown control %q", c.Type))
+	}
+	return p.status("replay_state", "")
+}
+
+// jumps to at: sends the keyframe before it and then everything between the keyframe and at.
+// the file is read again from the start, the keyframe index says how far to skip.
+func (p *playback) seek(at int64) error {
+	at = max(0, min(at, p.rp.Duration()))
+	if p.frames != nil {
+		p.frames.close()
+	}
+	frames, _, err := openFrames(p.rp.path)
+	if err != nil {
+		return err
+	}
+	p.frames = frames
+	k := p.rp.keyframeFor(at)
+	for range k.frame {
+		if ok, err := p.frames.decode(&json.RawMessage{}); err != nil || !ok {
+			return fmt.Errorf("replay ended before keyframe %d: %v", k.frame, err)
+		}
+	}
+	if err := p.advance(); err != nil {
+		return err
+	}
+	if p.pending == nil {
+		return fmt.Errorf("replay ended before keyframe %d", k.frame)
+	}
+	if err := p.send(p.pending.Keyframe); err != nil {
+		return err
+	}
+	// the keyframe was taken at the end of its tick so that tick's messages are already in it
+	for {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if p.pending == nil || p.pending.At > at {
+			break
+		}
+		for _, msg := range p.pending.Messages {
+			if err := p.send(msg); err != nil {
+				return err
+			}
+		}
+	}
+	p.setPosition(at)
+	return p.status("replay_state", "")
+}
+
+func (p *playback) status(kind, errMsg string) error {
+	s := status{
+		Type:     kind,
+		Duration: p.rp.Duration(),
+		At:       p.position(),
+		Speed:    p.speed,
+		Paused:   p.paused,
+		Error:    errMsg,
+	}
+	if kind == "replay_info" {
+		s.Header = &p.rp.Header
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return p.send(data)
+}
@@ -0,0 +1,402 @@
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// a replay file is a gzipped stream of json lines: one Header followed by one Frame
+// per tick that had anything in it. the frames carry the exact messages spectators
+// were sent, so playback doesn't have to re-run the simulation.
+const (
+	Version = 1
+	Ext     = ".replay.gz"
+)
+
+type Header struct {
+	Version   int             `json:"version"`
+	Room      string          `json:"room"`
+	Seed      int64           `json:"seed"`
+	Part      int             `json:"part,omitempty"` // a long match is split over several files, numbered from 1
+	TickRate  int             `json:"tick_rate"`
+	StartedAt time.Time       `json:"started_at"`
+	Settings  json.RawMessage `json:"settings"`
+}
+
+// Frame is everything that happened on one tick.
+// a keyframe is a full state_sync message, written every second or so to make seeking cheap.
+type Frame struct {
+	Tick     uint64            `json:"tick"`
+	At       int64             `json:"at"` // milliseconds since the recording started
+	Inputs   []json.RawMessage `json:"inputs,omitempty"`
+	Messages []json.RawMessage `json:"messages,omitempty"`
+	Keyframe json.RawMessage   `json:"keyframe,omitempty"`
+}
+
+// frames waiting for the writer. a second or so at the highest tick rate, a recorder
+// that falls further behind than that gives up rather than hold up the room.
+const queueSize = 256
+
+// ErrFellBehind is the recording's error when the disk couldn't keep up
+var ErrFellBehind = errors.New("replay writer fell behind, recording stopped")
+
+// Recorder writes a replay as the match is played. the room calls it with its lock held,
+// so all it does there is collect the tick's messages. encoding, compressing and writing
+// happen on the recorder's own goroutine. it isn't safe for concurrent use. a nil
+// *Recorder records nothing so callers don't need to check whether recording is on.
+type Recorder struct {
+	path    string
+	header  Header
+	start   time.Time
+	frame   pending
+	frames  chan pending
+	err     error // first error on the room's side, recording stops after it
+	size    atomic.Int64
+	done    chan struct{}
+	written error // the writer's error, only read after done is closed
+}
+
+// a frame before it's encoded. inputs and the keyframe are encoded by the writer.
+type pending struct {
+	tick     uint64
+	at       int64
+	inputs   []any
+	messages []json.RawMessage
+	keyframe any
+}
+
+func (p *pending) empty() bool {
+	return len(p.inputs) == 0 && len(p.messages) == 0 && p.keyframe == nil
+}
+
+// Create starts a new replay file in dir named after the room and start time
+func Create(dir string, h Header) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	h.Version = Version
+	if h.StartedAt.IsZero() {
+		h.StartedAt = time.Now()
+	}
+	id := fmt.Sprintf("%s-%s", h.Room, h.StartedAt.UTC().Format("20060102T150405.000Z"))
+	path := filepath.Join(dir, id+Ext)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		path:   path,
+		header: h,
+		start:  h.StartedAt,
+		frames: make(chan pending, queueSize),
+		done:   make(chan struct{}),
+	}
+	go r.write(f)
+	return r, nil
+}
+
+// Path is where the replay is being written
+func (r *Recorder) Path() string {
+	if r == nil {
+		return ""
+	}
+	return r.path
+}
+
+// Started is when the recording began
+func (r *Recorder) Started() time.Time {
+	if r == nil {
+		return time.Time{}
+	}
+	return r.start
+}
+
+// Size is roughly how many bytes have made it to disk so far
+func (r *Recorder) Size() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.size.Load()
+}
+
+// Input records an input the server accepted this tick. v is encoded later on the
+// writer's goroutine, so it mustn't be changed after this.
+func (r *Recorder) Input(v any) {
+	if r == nil || r.err != nil {
+		return
+	}
+	r.frame.inputs = append(r.frame.inputs, v)
+}
+
+// Message records an already encoded broadcast
+func (r *Recorder) Message(data []byte) {
+	if r == nil || r.err != nil {
+		return
+	}
+	r.frame.messages = append(r.frame.messages, data)
+}
+
+// Keyframe records a full state message, call it after the tick's broadcasts. like Input
+// it's encoded later, so v mustn't be changed after this.
+func (r *Recorder) Keyframe(v any) {
+	if r == nil || r.err != nil {
+		return
+	}
+	r.frame.keyframe = v
+}
+
+// Discard throws away what was collected for this tick
+func (r *Recorder) Discard() {
+	if r == nil {
+		return
+	}
+	r.frame = pending{}
+}
+
+// EndTick hands the frame for tick (if anything happened) to the writer and starts the next one
+func (r *Recorder) EndTick(tick uint64, now time.Time) {
+	if r == nil || r.err != nil {
+		return
+	}
+	if !r.frame.empty() {
+		r.frame.tick = tick
+		r.frame.at = now.Sub(r.start).Milliseconds()
+		select {
+		case r.frames <- r.frame:
+		default:
+			r.err = ErrFellBehind
+		}
+	}
+	r.frame = pending{}
+}
+
+// Close ends the recording without waiting for it to reach the disk, Wait does that.
+// whatever was collected since the last EndTick is dropped.
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+	close(r.frames)
+}
+
+// Wait blocks until the writer has finished the file after Close. returns the first error
+// seen while recording.
+func (r *Recorder) Wait() error {
+	if r == nil {
+		return nil
+	}
+	<-r.done
+	if r.err != nil {
+		return r.err
+	}
+	return r.written
+}
+
+// the writer goroutine, it owns the file
+func (r *Recorder) write(f *os.File) {
+	defer close(r.done)
+	gz := gzip.NewWriter(&counter{w: f, n: &r.size})
+	buf := bufio.NewWriter(gz)
+	enc := json.NewEncoder(buf)
+	err := enc.Encode(r.header)
+	for p := range r.frames {
+		if err != nil {
+			continue // drain so the room never blocks
+		}
+		err = enc.Encode(p.encode())
+	}
+	for _, e := range []error{buf.Flush(), gz.Close(), f.Close()} {
+		if err == nil {
+			err = e
+		}
+	}
+	r.written = err
+}
+
+func (p pending) encode() *Frame {
+	frame := &Frame{Tick: p.tick, At: p.at, Messages: p.messages}
+	for _, in := range p.inputs {
+		if data, err := json.Marshal(in); err == nil {
+			frame.Inputs = append(frame.Inputs, data)
+		}
+	}
+	if p.keyframe != nil {
+		frame.Keyframe, _ = json.Marshal(p.keyframe)
+	}
+	return frame
+}
+
+// counts what goes through to the file
+type counter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// Replay is a recording opened for playback. only the header and where the keyframes are
+// stay in memory, the frames are streamed from disk as they're played.
+type Replay struct {
+	Header    Header
+	path      string
+	duration  int64
+	keyframes []keyframeAt
+}
+
+type keyframeAt struct {
+	frame int // frames before it in the file
+	at    int64
+}
+
+// Duration is how long the recording runs, in milliseconds
+func (rp *Replay) Duration() int64 {
+	return rp.duration
+}
+
+// Open reads through a replay file once to find its length and keyframes. a recording
+// cut short by a crash (or still being written) is readable up to the last whole frame.
+func Open(path string) (*Replay, error) {
+	fr, header, err := openFrames(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fr.close()
+	rp := &Replay{Header: header, path: path}
+	for n := 0; ; n++ {
+		// just the parts the index needs
+		var frame struct {
+			At       int64           `json:"at"`
+			Keyframe json.RawMessage `json:"keyframe"`
+		}
+		ok, err := fr.decode(&frame)
+		if err != nil {
+			return nil, fmt.Errorf("reading replay frame %d: %v", n, err)
+		}
+		if !ok {
+			break
+		}
+		if frame.Keyframe != nil {
+			rp.keyframes = append(rp.keyframes, keyframeAt{frame: n, at: frame.At})
+		}
+		rp.duration = frame.At
+	}
+	if len(rp.keyframes) == 0 || rp.keyframes[0].frame != 0 {
+		return nil, errors.New("replay doesn't start with a keyframe")
+	}
+	return rp, nil
+}
+
+// the keyframe playback at the given time starts from, the last one at or before it
+func (rp *Replay) keyframeFor(at int64) keyframeAt {
+	k := sort.Search(len(rp.keyframes), func(i int) bool { return rp.keyframes[i].at > at }) - 1
+	return rp.keyframes[max(k, 0)]
+}
+
+// frames read one at a time from the start of a file
+type frameReader struct {
+	f   *os.File
+	dec *json.Decoder
+}
+
+func openFrames(path string) (*frameReader, Header, error) {
+	var h Header
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, h, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, h, err
+	}
+	fr := &frameReader{f: f, dec: json.NewDecoder(gz)}
+	if err := fr.dec.Decode(&h); err != nil {
+		fr.close()
+		return nil, h, fmt.Errorf("reading replay header: %v", err)
+	}
+	if h.Version != Version {
+		fr.close()
+		return nil, h, fmt.Errorf("unsupported replay version %d", h.Version)
+	}
+	return fr, h, nil
+}
+
+// reads the next frame into v, false at the end of the file
+func (fr *frameReader) decode(v any) (bool, error) {
+	if err := fr.dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (fr *frameReader) close() {
+	fr.f.Close()
+}
+
+// Info describes a replay file without loading it
+type Info struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	StartedAt time.Time `json:"started_at"`
+	Size      int64     `json:"size"`
+}
+
+var validID = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Path turns a replay id from a url into a file path in dir
+func Path(dir, id string) (string, error) {
+	if !validID.MatchString(id) || strings.Contains(id, "..") {
+		return "", fmt.Errorf("invalid replay id %q", id)
+	}
+	return filepath.Join(dir, id+Ext), nil
+}
+
+// List returns every replay in dir, newest first
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var out []Info
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), Ext)
+		if !ok || e.IsDir() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		info := Info{ID: id, Size: fi.Size(), StartedAt: fi.ModTime()}
+		// ids are <room>-<start time>
+		if i := strings.LastIndex(id, "-"); i > 0 {
+			info.Room = id[:i]
+			if t, err := time.Parse("20060102T150405.000Z", id[i+1:]); err == nil {
+				info.StartedAt = t
+			}
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.After(out[j].StartedAt) })
+	return out, nil
+}
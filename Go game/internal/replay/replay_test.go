@@ -0,0 +1,79 @@
+package replay
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// records ticks 1..n, one message each and a keyframe every 10
+func record(t *testing.T, dir string, n int) string {
+	t.Helper()
+	start := time.Now()
+	rec, err := Create(dir, Header{Room: "test", Seed: 1, TickRate: 10, StartedAt: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tick := 1; tick <= n; tick++ {
+		rec.Input(map[string]int{"tick": tick})
+		rec.Message([]byte(`{"type":"tick","seq":` + itoa(tick) + `}`))
+		if tick%10 == 1 {
+			rec.Keyframe(map[string]any{"type": "state_sync", "seq": tick})
+		}
+		rec.EndTick(uint64(tick), start.Add(time.Duration(tick)*100*time.Millisecond))
+	}
+	rec.Close()
+	if err := rec.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Size() == 0 {
+		t.Error("nothing counted as written")
+	}
+	return rec.Path()
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+func TestRecordAndPlay(t *testing.T) {
+	path := record(t, t.TempDir(), 30)
+	rp, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Duration() != 3000 || len(rp.keyframes) != 3 {
+		t.Fatalf("duration %d with %d keyframes, want 3000 and 3", rp.Duration(), len(rp.keyframes))
+	}
+
+	// seeking to 2.55s starts from the keyframe at tick 21 and catches up to tick 25
+	var got []string
+	p := &playback{rp: rp, speed: 1, send: func(data []byte) error {
+		var m struct {
+			Type string `json:"type"`
+			Seq  int    `json:"seq"`
+		}
+		json.Unmarshal(data, &m)
+		got = append(got, m.Type+itoa(m.Seq))
+		return nil
+	}}
+	defer func() { p.frames.close() }()
+	if err := p.seek(2550); err != nil {
+		t.Fatal(err)
+	}
+	want := "state_sync21 tick22 tick23 tick24 tick25 replay_state0"
+	if strings.Join(got, " ") != want {
+		t.Errorf("seek sent %q, want %q", strings.Join(got, " "), want)
+	}
+	if p.pending == nil || p.pending.Tick != 26 {
+		t.Errorf("next frame up is %+v, want tick 26", p.pending)
+	}
+
+	// and back again, the file is read from the top
+	got = nil
+	if err := p.seek(0); err != nil || got[0] != "state_sync1" {
+		t.Errorf("seeking back sent %q (%v)", got, err)
+	}
+}
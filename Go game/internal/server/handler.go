@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// biggest request body we'll read, admin requests are tiny
+const maxBodyBytes = 1 << 20
+
+// WriteJSON sends v as a json response with the given status
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError sends {"error": "..."} so every endpoint fails the same way
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// DecodeJSON reads the request body into v, unknown fields are an error
+// so a typo in an admin request doesn't silently do nothing.
+func DecodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("bad request body: %v", err)
+	}
+	return nil
+}
+
+// the proxies whose X-Forwarded-For we believe, set once on startup
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies takes the ips or cidr ranges (10.0.0.0/8) of the proxies in front of
+// the server, ClientIP only reads X-Forwarded-For on requests that come through them
+func SetTrustedProxies(proxies []string) error {
+	prefixes, err := parseProxies(proxies)
+	if err != nil {
+		return err
+	}
+	trustedProxies = prefixes
+	return nil
+}
+
+// reads a list of ips and cidr ranges
+func parseProxies(proxies []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, p := range proxies {
+		if strings.Contains(p, "/") {
+			prefix, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("bad proxy range %q: %v", p, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy address %q: %v", p, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP is the address the request came from. X-Forwarded-For is anyone's to write, so
+// it's only read when the connection comes from a trusted proxy, and then the client is
+// the right-most address that isn't one of our proxies (everything left of it could be made up).
+func ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !trusted(remote) {
+		return remote
+	}
+	// several headers count as one comma separated list
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			// garbage in the chain, the proxy that sent it is all we know
+			return remote
+		}
+		if !trusted(hop) {
+			return hop
+		}
+	}
+	return remote
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trustedProxies = nil })
+
+	cases := []struct {
+		name, remote string
+		forwarded    []string
+		want         string
+	}{
+		{"direct", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"header from a client is ignored", "203.0.113.5:4000", []string{"1.2.3.4"}, "203.0.113.5"},
+		{"through the ingress", "10.1.2.3:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"made up hops on the left are skipped", "10.1.2.3:4000", []string{"1.2.3.4, 198.51.100.7, 192.168.1.1"}, "198.51.100.7"},
+		{"several headers", "10.1.2.3:4000", []string{"1.2.3.4", "198.51.100.7"}, "198.51.100.7"},
+		{"garbage", "10.1.2.3:4000", []string{"not-an-ip"}, "10.1.2.3"},
+		{"only proxies", "10.1.2.3:4000", []string{"10.9.9.9"}, "10.1.2.3"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for _, f := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if got := ClientIP(r); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	if err := SetTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("a bad range was accepted")
+	}
+}
@@ -0,0 +1,429 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"sync"
+	"time"
+
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/heatmap"
+	"arena-tactics/internal/logging"
+	"arena-tactics/internal/metrics"
+)
+
+// Options size the pipeline. memory is bounded by Buffer records queued plus one batch being written.
+type Options struct {
+	Buffer        int           // records that can wait for the writer, past that they're dropped
+	BatchSize     int           // a batch is written as soon as it's this big
+	FlushInterval time.Duration // and at least this often when it isn't
+}
+
+// Writer gets positions, heatmap hits, the event log, kill and death counts and the matches table
+// into the database without the game ever waiting on it.
+// rooms hand it records from under their lock, one goroutine batches them up and writes each
+// batch with a single multi row insert. when the database can't keep up the queue fills and new
+// records are dropped (and counted), the game carries on either way.
+type Writer struct {
+	store database.Store
+	opts  Options
+	queue chan record
+
+	// sessions removed from the store, and when. their positions from before that are stale
+	// and would bring back the last known position RemovePlayer just deleted. the lock only
+	// ever covers the map, never a write, so Forget doesn't wait on the database.
+	mu        sync.Mutex
+	forgotten map[string]time.Time
+
+	// ids of the matches started but not yet ended, by key. only the writer goroutine uses it.
+	matches map[string]int64
+
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWriter starts the writer goroutine, Close stops it
+func NewWriter(store database.Store, opts Options) *Writer {
+	w := newWriter(store, opts)
+	go w.run()
+	return w
+}
+
+func newWriter(store database.Store, opts Options) *Writer {
+	return &Writer{
+		store:     store,
+		opts:      opts,
+		queue:     make(chan record, opts.Buffer),
+		forgotten: make(map[string]time.Time),
+		matches:   make(map[string]int64),
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+type recordKind uint8
+
+const (
+	positionRecord recordKind = iota
+	hitRecord
+	eventRecord
+	pickupRecord
+	statsRecord
+	matchStartRecord
+	matchEndRecord
+)
+
+// one thing waiting to be written, kind says which of the fields it is. positions are by far
+// the most common so they're kept inline, the rest are pointers to keep the queue small.
+type record struct {
+	kind     recordKind
+	position models.Position
+	cell     models.HeatmapCell
+	event    *models.Event
+	details  any // marshalled into event.Details by the writer rather than on the tick loop
+	pickup   *models.Pickup
+	stats    *statsDelta
+	match    *matchRecord
+}
+
+type statsDelta struct {
+	sessionID     string
+	kills, deaths int
+}
+
+type matchRecord struct {
+	match   models.Match
+	started func(id int64)
+}
+
+// Position queues a movement sample, it's logged to player_positions and becomes the session's
+// last known position. never blocks.
+func (w *Writer) Position(p models.Position) {
+	w.enqueue(record{kind: positionRecord, position: p})
+}
+
+// Hit counts a kill or death at a spot on the map into the match's heatmap. never blocks.
+func (w *Writer) Hit(matchID int64, room, kind string, x, y float64) {
+	col, row := heatmap.CellOf(x, y)
+	w.enqueue(record{kind: hitRecord, cell: models.HeatmapCell{MatchID: matchID, Room: room, Kind: kind, X: col, Y: row, Hits: 1}})
+}
+
+// Event queues an entry for the event log. details is the struct that goes with the event's
+// type, it's turned into JSON by the writer so it mustn't be changed after this. never blocks.
+func (w *Writer) Event(e models.Event, details any) {
+	w.enqueue(record{kind: eventRecord, event: &e, details: details})
+}
+
+// Pickup queues a picked up weapon or powerup for the pickups table. never blocks.
+func (w *Writer) Pickup(p models.Pickup) {
+	w.enqueue(record{kind: pickupRecord, pickup: &p})
+}
+
+// Stats adds to a player's lifetime kills and deaths. never blocks.
+func (w *Writer) Stats(sessionID string, kills, deaths int) {
+	w.enqueue(record{kind: statsRecord, stats: &statsDelta{sessionID, kills, deaths}})
+}
+
+// StartMatch queues the row for a new match. once it's written started is called with the
+// match's id from its own goroutine, it isn't called when the write fails. never blocks.
+func (w *Writer) StartMatch(m models.Match, started func(id int64)) {
+	w.enqueue(record{kind: matchStartRecord, match: &matchRecord{match: m, started: started}})
+}
+
+// EndMatch records how a match that went through StartMatch ended, found by its key so the
+// caller doesn't have to wait for the id. never blocks.
+func (w *Writer) EndMatch(m models.Match) {
+	w.enqueue(record{kind: matchEndRecord, match: &matchRecord{match: m}})
+}
+
+func (w *Writer) enqueue(r record) {
+	select {
+	case <-w.closing:
+		metrics.TelemetryDropped.WithLabelValues("closed").Inc()
+		return
+	default:
+	}
+	select {
+	case w.queue <- r:
+	default:
+		metrics.TelemetryDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// Forget stops a session's queued positions from updating its last known position. call it
+// before removing the session from the store. a batch that was already being written when
+// it was called has the position it wrote taken back out afterwards. never blocks on a write.
+func (w *Writer) Forget(sessionID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.forgotten[sessionID] = time.Now()
+}
+
+// Close writes out everything queued so far and stops the writer. records that come in
+// afterwards are dropped. gives up when ctx runs out.
+func (w *Writer) Close(ctx context.Context) error {
+	w.closeOnce.Do(func() { close(w.closing) })
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]record, 0, w.opts.BatchSize)
+	for {
+		select {
+		case r := <-w.queue:
+			batch = append(batch, r)
+			if len(batch) >= w.opts.BatchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+		case <-w.closing:
+			// whatever made it into the queue before Close still gets written
+			for {
+				select {
+				case r := <-w.queue:
+					batch = append(batch, r)
+					if len(batch) >= w.opts.BatchSize {
+						batch = w.flush(batch)
+					}
+				default:
+					w.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// writes the batch and hands it back emptied for reuse
+func (w *Writer) flush(batch []record) []record {
+	metrics.TelemetryQueued.Set(float64(len(w.queue)))
+
+	var positions []models.Position
+	var events []models.Event
+	var pickups []models.Pickup
+	var starts, ends []*matchRecord
+	stats := make(map[string]statsDelta)
+	cells := make(map[models.HeatmapCell]int) // hits left at 0 in the key, the same cell is hit a lot
+	for _, r := range batch {
+		switch r.kind {
+		case positionRecord:
+			positions = append(positions, r.position)
+		case hitRecord:
+			c := r.cell
+			c.Hits = 0
+			cells[c] += r.cell.Hits
+		case eventRecord:
+			events = append(events, withDetails(*r.event, r.details))
+		case pickupRecord:
+			pickups = append(pickups, *r.pickup)
+		case statsRecord:
+			s := stats[r.stats.sessionID]
+			s.kills += r.stats.kills
+			s.deaths += r.stats.deaths
+			stats[r.stats.sessionID] = s
+		case matchStartRecord:
+			starts = append(starts, r.match)
+		case matchEndRecord:
+			ends = append(ends, r.match)
+		}
+	}
+	// a match that starts and ends in the same batch still needs its row before the end
+	// everything in the batch was queued by now, so only sessions forgotten before this
+	// can have stale positions in it
+	cutoff := time.Now()
+	w.mu.Lock()
+	forgotten := maps.Clone(w.forgotten)
+	w.mu.Unlock()
+
+	w.startMatches(starts)
+	w.writeStats(stats)
+	w.writePositions(positions, forgotten, cutoff)
+	w.writeCells(cells)
+	w.writeEvents(events)
+	w.writePickups(pickups)
+	w.endMatches(ends)
+
+	// an empty queue means every record from before the cutoff has been seen and dealt with,
+	// so nothing stale is left for the sessions forgotten before it
+	if len(w.queue) == 0 {
+		w.mu.Lock()
+		maps.DeleteFunc(w.forgotten, func(_ string, at time.Time) bool { return !at.After(cutoff) })
+		w.mu.Unlock()
+	}
+	return batch[:0]
+}
+
+// logs the positions and updates the last known ones. forgotten is who'd been forgotten by
+// the cutoff, when the batch was put together.
+func (w *Writer) writePositions(batch []models.Position, forgotten map[string]time.Time, cutoff time.Time) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.store.InsertPlayerPositions(batch); err != nil {
+		metrics.TelemetryDropped.WithLabelValues("write_failed").Add(float64(len(batch)))
+		logging.DB.Error("writing positions failed", "rows", len(batch), "err", err)
+		return
+	}
+	metrics.TelemetryWritten.Add(float64(len(batch)))
+
+	// only the newest sample per session matters for the last known position,
+	// and none from before the session was forgotten
+	latest := make(map[string]int, len(batch))
+	for i, p := range batch {
+		if at, ok := forgotten[p.SessionID]; ok && !p.At.After(at) {
+			continue
+		}
+		latest[p.SessionID] = i
+	}
+	last := make([]models.Position, 0, len(latest))
+	for i, p := range batch {
+		if j, ok := latest[p.SessionID]; ok && j == i {
+			last = append(last, p)
+		}
+	}
+	if err := w.store.UpdateLastKnownPositions(last); err != nil {
+		logging.DB.Error("updating last known positions failed", "sessions", len(last), "err", err)
+		return
+	}
+
+	// sessions forgotten while that was being written may have been removed from the store
+	// before it landed, their stale position comes back out
+	w.mu.Lock()
+	var stale []string
+	for _, p := range last {
+		if at, ok := w.forgotten[p.SessionID]; ok && at.After(cutoff) && !p.At.After(at) {
+			stale = append(stale, p.SessionID)
+		}
+	}
+	w.mu.Unlock()
+	for _, session := range stale {
+		if err := w.store.RemoveLastKnownPosition(session); err != nil {
+			logging.DB.Error("removing a stale last known position failed", "session", session, "err", err)
+		}
+	}
+}
+
+// adds the hits to their heatmap cells
+func (w *Writer) writeCells(cells map[models.HeatmapCell]int) {
+	if len(cells) == 0 {
+		return
+	}
+	batch := make([]models.HeatmapCell, 0, len(cells))
+	hits := 0
+	for c, n := range cells {
+		c.Hits = n
+		batch = append(batch, c)
+		hits += n
+	}
+	if err := w.store.AddHeatmapCells(batch); err != nil {
+		metrics.TelemetryDropped.WithLabelValues("write_failed").Add(float64(hits))
+		logging.DB.Error("writing heatmap hits failed", "cells", len(batch), "err", err)
+		return
+	}
+	metrics.TelemetryWritten.Add(float64(hits))
+}
+
+// an event that can't be marshalled is still logged, just without its details
+func withDetails(e models.Event, details any) models.Event {
+	if details == nil {
+		return e
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		logging.DB.Warn("marshalling event details failed", "type", e.Type, "err", err)
+		return e
+	}
+	e.Details = b
+	return e
+}
+
+// appends the batch to the event log
+func (w *Writer) writeEvents(batch []models.Event) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.store.InsertEvents(batch); err != nil {
+		metrics.TelemetryDropped.WithLabelValues("write_failed").Add(float64(len(batch)))
+		logging.DB.Error("writing events failed", "rows", len(batch), "err", err)
+		return
+	}
+	metrics.TelemetryWritten.Add(float64(len(batch)))
+}
+
+// logs the batch's pickups
+func (w *Writer) writePickups(batch []models.Pickup) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.store.InsertPickups(batch); err != nil {
+		metrics.TelemetryDropped.WithLabelValues("write_failed").Add(float64(len(batch)))
+		logging.DB.Error("writing pickups failed", "rows", len(batch), "err", err)
+		return
+	}
+	metrics.TelemetryWritten.Add(float64(len(batch)))
+}
+
+// adds each session's kills and deaths to its lifetime totals
+func (w *Writer) writeStats(stats map[string]statsDelta) {
+	for session, s := range stats {
+		if err := w.store.UpdatePlayerStats(session, s.kills, s.deaths); err != nil {
+			metrics.TelemetryDropped.WithLabelValues("write_failed").Inc()
+			logging.DB.Error("updating player stats failed", "session", session, "err", err)
+			continue
+		}
+		metrics.TelemetryWritten.Inc()
+	}
+}
+
+// writes the new matches' rows. like endMatches it only runs on the writer goroutine, the
+// only one that touches w.matches
+func (w *Writer) startMatches(starts []*matchRecord) {
+	for _, r := range starts {
+		m := r.match
+		if err := w.store.StartMatch(&m); err != nil {
+			metrics.TelemetryDropped.WithLabelValues("write_failed").Inc()
+			logging.DB.Error("recording match start failed", "room", m.Room, "match", m.Key, "err", err)
+			continue
+		}
+		metrics.TelemetryWritten.Inc()
+		w.matches[m.Key] = m.ID
+		// the room takes its own lock to store the id, the writer shouldn't wait on a room
+		if r.started != nil {
+			go r.started(m.ID)
+		}
+	}
+}
+
+// finishes the matches' rows, found by key
+func (w *Writer) endMatches(ends []*matchRecord) {
+	for _, r := range ends {
+		m := r.match
+		id, ok := w.matches[m.Key]
+		if !ok {
+			// its start was dropped or failed, there's no row to finish
+			metrics.TelemetryDropped.WithLabelValues("write_failed").Inc()
+			continue
+		}
+		delete(w.matches, m.Key)
+		m.ID = id
+		if err := w.store.EndMatch(&m); err != nil {
+			metrics.TelemetryDropped.WithLabelValues("write_failed").Inc()
+			logging.DB.Error("recording match end failed", "room", m.Room, "match", m.Key, "err", err)
+			continue
+		}
+		metrics.TelemetryWritten.Inc()
+	}
+}
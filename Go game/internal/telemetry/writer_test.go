@@ -0,0 +1,203 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"arena-tactics/internal/database"
+	"arena-tactics/internal/database/models"
+	"arena-tactics/internal/game"
+	"arena-tactics/internal/heatmap"
+)
+
+func sample(session string, x float64) models.Position {
+	return models.Position{SessionID: session, PlayerID: "p-" + session, X: x, Y: x, At: time.Now()}
+}
+
+// waits for the writer to catch up to n rows
+func waitForRows(t *testing.T, store *database.Memory, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(store.Positions()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d rows written, want %d", len(store.Positions()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriterBatchesAndKeepsLastPosition(t *testing.T) {
+	store := database.NewMemory()
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 3, FlushInterval: time.Hour})
+	defer w.Close(context.Background())
+
+	// a full batch goes out without waiting for the interval
+	w.Position(sample("a", 1))
+	w.Position(sample("a", 2))
+	w.Position(sample("b", 3))
+	waitForRows(t, store, 3)
+
+	pos, _ := store.GetLastKnownPosition("a")
+	if pos == nil || *pos != (game.Position{X: 2, Y: 2}) {
+		t.Errorf("last position of a is %v, want the newest sample {2 2}", pos)
+	}
+}
+
+func TestWriterFlushesOnIntervalAndClose(t *testing.T) {
+	store := database.NewMemory()
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	w.Position(sample("a", 1))
+	waitForRows(t, store, 1)
+
+	// close writes whatever is left even though the batch isn't full
+	w.Position(sample("a", 2))
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(store.Positions()); n != 2 {
+		t.Errorf("%d rows after close, want 2", n)
+	}
+	w.Position(sample("a", 3))
+	if n := len(store.Positions()); n != 2 {
+		t.Errorf("a position after close was written, %d rows", n)
+	}
+}
+
+func TestWriterForgetKeepsLastPositionGone(t *testing.T) {
+	store := database.NewMemory()
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 100, FlushInterval: time.Hour})
+
+	w.Position(sample("leaving", 1))
+	w.Position(sample("returning", 2))
+	time.Sleep(time.Millisecond) // the forget has to come strictly after the queued samples
+	w.Forget("leaving")
+	w.Forget("returning")
+	// the same session coming back afterwards is a new player, their positions count
+	w.Position(sample("returning", 3))
+	w.Close(context.Background())
+
+	if pos, _ := store.GetLastKnownPosition("leaving"); pos != nil {
+		t.Errorf("a position queued before Forget came back as the last known one: %v", pos)
+	}
+	if pos, _ := store.GetLastKnownPosition("returning"); pos == nil || pos.X != 3 {
+		t.Errorf("returning session's last position is %v, want the one after Forget", pos)
+	}
+	// the movement log keeps all of them for the heatmaps
+	if n := len(store.Positions()); n != 3 {
+		t.Errorf("%d rows written, want 3", n)
+	}
+}
+
+// a store whose position inserts hang until released, like a database that's struggling
+type slowStore struct {
+	*database.Memory
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) InsertPlayerPositions(batch []models.Position) error {
+	s.writing <- struct{}{}
+	<-s.release
+	return s.Memory.InsertPlayerPositions(batch)
+}
+
+func TestWriterForgetDuringWrite(t *testing.T) {
+	store := &slowStore{Memory: database.NewMemory(), writing: make(chan struct{}), release: make(chan struct{})}
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 1, FlushInterval: time.Hour})
+	w.Position(sample("leaving", 1))
+	<-store.writing
+
+	// the room calls this under its lock, it mustn't wait for the write
+	forgot := make(chan struct{})
+	go func() {
+		w.Forget("leaving")
+		store.RemovePlayer("leaving")
+		close(forgot)
+	}()
+	select {
+	case <-forgot:
+	case <-time.After(time.Second):
+		t.Fatal("Forget waited on a database write")
+	}
+	close(store.release)
+	w.Close(context.Background())
+
+	if pos, _ := store.GetLastKnownPosition("leaving"); pos != nil {
+		t.Errorf("the write in flight brought back the removed session's position: %v", pos)
+	}
+}
+
+func TestWriterCountsHits(t *testing.T) {
+	store := database.NewMemory()
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 100, FlushInterval: time.Hour})
+	w.Hit(1, "main", heatmap.Deaths, 10, 10)
+	w.Hit(1, "main", heatmap.Deaths, 20, 20) // same 25px cell
+	w.Hit(1, "main", heatmap.Deaths, 500, 300)
+	w.Hit(1, "main", heatmap.Kills, 10, 10)
+	w.Close(context.Background())
+
+	grid, _ := store.Heatmap("main", heatmap.Deaths, 1)
+	if grid.Cells[0][0] != 2 || grid.Total != 3 {
+		t.Errorf("deaths heatmap has %d in the corner and %d in total, want 2 and 3", grid.Cells[0][0], grid.Total)
+	}
+}
+
+func TestWriterLogsEvents(t *testing.T) {
+	store := database.NewMemory()
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 100, FlushInterval: time.Hour})
+	w.Event(models.Event{SessionID: "a", Type: models.EventDeath, At: time.Now()}, models.DeathDetails{KillerID: "7", Weapon: "shotgun"})
+	w.Event(models.Event{SessionID: "a", Type: models.EventSpawn, At: time.Now()}, nil)
+	w.Pickup(models.Pickup{PickupID: "w1", Kind: "weapon", Type: "shotgun", SessionID: "a"})
+	w.Close(context.Background())
+
+	events, _ := store.Timeline("a", 0)
+	if len(events) != 2 {
+		t.Fatalf("%d events written, want 2", len(events))
+	}
+	if got := string(events[0].Details); got != `{"killer_id":"7","weapon":"shotgun"}` {
+		t.Errorf("death details are %s", got)
+	}
+	if events[1].Details != nil {
+		t.Errorf("an event without details got %s", events[1].Details)
+	}
+	if n := len(store.Pickups()); n != 1 {
+		t.Errorf("%d pickups written, want 1", n)
+	}
+}
+
+func TestWriterRecordsStatsAndMatches(t *testing.T) {
+	store := database.NewMemory()
+	store.GetOrCreatePlayer("a")
+	w := NewWriter(store, Options{Buffer: 100, BatchSize: 100, FlushInterval: time.Hour})
+	started := make(chan int64, 1)
+	w.StartMatch(models.Match{Key: "main-1", Room: "main", StartedAt: time.Now()}, func(id int64) { started <- id })
+	w.Stats("a", 1, 0)
+	w.Stats("a", 1, 1)
+	// ended in the same batch it started in, before the game knows the id
+	ended := time.Now()
+	w.EndMatch(models.Match{Key: "main-1", Room: "main", EndedAt: &ended, WinnerSessionID: "a"})
+	w.Close(context.Background())
+
+	id := <-started
+	if m := store.Match(id); m == nil || m.EndedAt == nil || m.WinnerSessionID != "a" {
+		t.Errorf("match %d is %+v, want it ended and won by a", id, m)
+	}
+	if p, _ := store.GetOrCreatePlayer("a"); p.Kills != 2 || p.Deaths != 1 {
+		t.Errorf("a has %d kills and %d deaths, want 2 and 1", p.Kills, p.Deaths)
+	}
+}
+
+func TestWriterDropsWhenFull(t *testing.T) {
+	store := database.NewMemory()
+	// not running yet, so nothing drains the queue while we fill it
+	w := newWriter(store, Options{Buffer: 2, BatchSize: 10, FlushInterval: time.Hour})
+	for i := range 5 {
+		w.Position(sample("a", float64(i)))
+	}
+	go w.run()
+	w.Close(context.Background())
+	if n := len(store.Positions()); n != 2 {
+		t.Errorf("%d rows written from a queue of 2, want 2", n)
+	}
+}
@@ -0,0 +1,209 @@
+// Package wordfilter catches blocked words in text players write, with the usual tricks
+// (sh1t, f.u.c.k, fuuuck) undone first.
+package wordfilter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// caught anywhere, so "xXfuckXx" and "f u c k" don't get through
+var blockedAnywhere = []string{
+	"fuck", "shit", "cunt", "bitch", "asshole", "pussy", "whore", "slut", "wank", "twat",
+	"nigger", "nigga", "faggot", "retard", "hitler",
+}
+
+// only caught as a whole word, they hide inside ordinary ones (fag in fagus, rape in grape)
+var blockedWords = []string{"fag", "rape", "nazi", "dick", "cock", "bastard"}
+
+// ordinary words with something from blockedAnywhere inside. they're cut out of a word
+// before it's checked, so "swanky" is fine but "swankfuck" isn't.
+var allowed = []string{"scunthorpe", "shiitake", "shitake", "penistone", "swank"}
+
+// Filter is a set of blocked words. the zero value blocks nothing.
+type Filter struct {
+	anywhere []runs // all normalised
+	words    []runs
+	allowed  []string
+}
+
+// New builds a filter from the built in lists, extra words are only caught whole
+func New(extra ...string) *Filter {
+	f := &Filter{}
+	for _, w := range blockedAnywhere {
+		f.anywhere = append(f.anywhere, runsOf(normalise(w)))
+	}
+	for _, w := range append(append([]string(nil), blockedWords...), extra...) {
+		if n := normalise(w); n != "" {
+			f.words = append(f.words, runsOf(n))
+		}
+	}
+	for _, w := range allowed {
+		f.allowed = append(f.allowed, normalise(w))
+	}
+	return f
+}
+
+// Contains reports whether s has any blocked word in it. it goes a word at a time like
+// Mask, so "this hit" is fine, and words spelled out a letter at a time (f u c k, f.u.c.k)
+// count as one word.
+func (f *Filter) Contains(s string) bool {
+	for _, w := range words(s) {
+		if f.blocked(s[w.start:w.end]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask stars out every blocked word in s and leaves the rest as it was. words are found the
+// same way as in Contains.
+func (f *Filter) Mask(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, w := range words(s) {
+		if !f.blocked(s[w.start:w.end]) {
+			continue
+		}
+		b.WriteString(s[last:w.start])
+		for _, r := range s[w.start:w.end] {
+			if isWordRune(r) {
+				r = '*'
+			}
+			b.WriteRune(r)
+		}
+		last = w.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// where a word is in the text
+type span struct{ start, end int }
+
+// the words in s. a run of single letter words is taken as one word, with whatever's
+// between the letters inside its span.
+func words(s string) []span {
+	var tokens []span
+	start := -1
+	for i, r := range s {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			tokens = append(tokens, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, span{start, len(s)})
+	}
+	single := func(t span) bool { return utf8.RuneCountInString(s[t.start:t.end]) == 1 }
+
+	var out []span
+	for i := 0; i < len(tokens); {
+		j := i + 1
+		if single(tokens[i]) {
+			for j < len(tokens) && single(tokens[j]) {
+				j++
+			}
+		}
+		out = append(out, span{tokens[i].start, tokens[j-1].end})
+		i = j
+	}
+	return out
+}
+
+// whether one word is blocked
+func (f *Filter) blocked(word string) bool {
+	n := normalise(word)
+	whole := runsOf(n)
+	for _, w := range f.words {
+		if len(w) == len(whole) && whole.matchesAt(0, w) {
+			return true
+		}
+	}
+	for _, a := range f.allowed {
+		n = strings.ReplaceAll(n, a, " ")
+	}
+	for _, piece := range strings.Fields(n) {
+		r := runsOf(piece)
+		for _, w := range f.anywhere {
+			if r.contains(w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// letters and the digits and symbols people use for them
+func isWordRune(r rune) bool {
+	_, leet := leetspeak[r]
+	return unicode.IsLetter(r) || leet
+}
+
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't',
+}
+
+// lower case, leetspeak undone and everything that isn't a letter dropped
+func normalise(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if l, ok := leetspeak[r]; ok {
+			r = l
+		}
+		if unicode.IsLetter(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// the same letter n times in a row
+type run struct {
+	letter rune
+	n      int
+}
+
+// a word as runs of the same letter, "fuuuck" is f u*3 c k
+type runs []run
+
+func runsOf(s string) runs {
+	var out runs
+	for _, r := range s {
+		if len(out) > 0 && out[len(out)-1].letter == r {
+			out[len(out)-1].n++
+			continue
+		}
+		out = append(out, run{r, 1})
+	}
+	return out
+}
+
+// whether w shows up in rs starting at run i. a run in rs can be longer than the one it
+// matches, so drawn out letters (fuuuck) are still caught, but not shorter, so "nigeria"
+// isn't taken for a word with a double g.
+func (rs runs) matchesAt(i int, w runs) bool {
+	if i+len(w) > len(rs) {
+		return false
+	}
+	for j, r := range w {
+		if rs[i+j].letter != r.letter || rs[i+j].n < r.n {
+			return false
+		}
+	}
+	return true
+}
+
+func (rs runs) contains(w runs) bool {
+	for i := range rs {
+		if rs.matchesAt(i, w) {
+			return true
+		}
+	}
+	return false
+}
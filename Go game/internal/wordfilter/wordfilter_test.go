@@ -0,0 +1,63 @@
+package wordfilter
+
+import "testing"
+
+func TestContains(t *testing.T) {
+	f := New("noob")
+	for s, want := range map[string]bool{
+		// ordinary text, some of it with something blocked across or inside the words
+		"gg well played":     false,
+		"Push It":            false,
+		"this hit was lucky": false,
+		"who really won":     false,
+		"Nigeria":            false,
+		"visiting Niger":     false,
+		"swanky hat":         false,
+		"Scunthorpe United":  false,
+		"shiitake mushrooms": false,
+		"a cocktail":         false,
+		"Dickens":            false,
+		"grape juice":        false,
+		"a b c":              false,
+		"noobish":            false,
+		"":                   false,
+		"what the fuck":      true,
+		"sh1t":               true,
+		"f.u.c.k":            true,
+		"f u c k off":        true,
+		"FUUUCK":             true,
+		"xXfuckXx":           true,
+		"swankfuck":          true,
+		"big dick":           true,
+		"total n00b":         true,
+		"NOOOB":              true,
+	} {
+		if got := f.Contains(s); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	f := New()
+	for s, want := range map[string]string{
+		"Push It":            "Push It",
+		"who really won":     "who really won",
+		"Nigeria and swanky": "Nigeria and swanky",
+		"oh shit, sorry":     "oh ****, sorry",
+		"f.u.c.k this":       "*.*.*.* this",
+		"you sh1tlord.":      "you ********.",
+		"xXfuckXx gg":        "******** gg",
+	} {
+		if got := f.Mask(s); got != want {
+			t.Errorf("Mask(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestZeroFilter(t *testing.T) {
+	var f Filter
+	if f.Contains("fuck") || f.Mask("fuck") != "fuck" {
+		t.Error("the zero filter blocked something")
+	}
+}
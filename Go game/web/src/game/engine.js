@@ -138,6 +138,34 @@ export class GameEngine {
 			this.otherPlayers.removeWeapon(weaponID);
 		});
 
+		this.network.onWeaponHeat((heat, overheated, spin) => {
+			this.player.heat = heat;
+			this.player.overheated = overheated;
+			this.player.spin = spin;
+		});
+
+		this.network.onWeaponSwitch((playerID, weaponType) => {
+			if (playerID === this.playerId) {
+				this.player.setWeapon(weaponType);
+			}
+		});
+
+		// e picks up, 1 and 2 switch slots, g drops the weapon in hand
+		window.addEventListener("keydown", (e) => {
+			switch (e.key.toLowerCase()) {
+				case "e":
+					this.network.sendPickupWeapon();
+					break;
+				case "1":
+				case "2":
+					this.network.sendSwitchWeapon(Number(e.key));
+					break;
+				case "g":
+					this.network.sendDropWeapon();
+					break;
+			}
+		});
+
 		this.network.onPlayerRespawn((id, position, health, weapon) => {
 			console.log("Player respawn:", id);
 
@@ -210,30 +238,60 @@ export class GameEngine {
 			console.log("Powerup picked up:", { playerID, powerupID, type });
 			// removes the powerup sprite from the world.
 			this.otherPlayers.removePowerUp(powerupID);
-			if (playerID === this.playerId) {
-				this.player.teleportAvailable = false;
-				this.player.forceFieldActive = false;
-				this.player.healthRegenActive = false;
-				this.hud.hidePowerUp("teleportation");
-				this.hud.hidePowerUp("force_field");
-				this.hud.hidePowerUp("health_regen");
-
-				switch (type) {
-					case "teleportation":
-						this.player.teleportAvailable = true;
-						this.hud.showPowerUp("teleportation");
-						break;
-					case "force_field":
-						this.player.forceFieldActive = true;
-						this.player.shield = 100;
-						this.hud.showPowerUp("force_field");
-						break;
-					case "health_regen":
-						this.player.healthRegenActive = true;
-						this.hud.showPowerUp("health_regen");
-						break;
-				}
+		});
+
+		// powerups and killstreak rewards both arrive as effects, so they no longer cancel each other
+		const effectHud = { teleportation: "teleportation", force_field: "force_field", health_regen: "health_regen" };
+		this.network.onEffectStart(({ player_id, effect, magnitude }) => {
+			if (player_id !== this.playerId) {
+				if (effect === "invisibility") this.otherPlayers.setVisible(player_id, false);
+				return;
+			}
+			switch (effect) {
+				case "teleportation":
+					this.player.teleportAvailable = true;
+					break;
+				case "force_field":
+					this.player.forceFieldActive = true;
+					this.player.shield = 100;
+					break;
+				case "health_regen":
+					this.player.healthRegenActive = true;
+					break;
+				case "speed_boost":
+					this.player.speedMultiplier = magnitude || 1;
+					break;
+				case "radar":
+					this.player.radarActive = true;
+					break;
+			}
+			if (effectHud[effect]) this.hud.showPowerUp(effectHud[effect]);
+		});
+
+		this.network.onEffectEnd(({ player_id, effect }) => {
+			if (player_id !== this.playerId) {
+				if (effect === "invisibility") this.otherPlayers.setVisible(player_id, true);
+				return;
+			}
+			switch (effect) {
+				case "teleportation":
+					this.player.teleportAvailable = false;
+					break;
+				case "force_field":
+					this.player.forceFieldActive = false;
+					this.player.shield = 0;
+					break;
+				case "health_regen":
+					this.player.healthRegenActive = false;
+					break;
+				case "speed_boost":
+					this.player.speedMultiplier = 1;
+					break;
+				case "radar":
+					this.player.radarActive = false;
+					break;
 			}
+			if (effectHud[effect]) this.hud.hidePowerUp(effectHud[effect]);
 		});
 
 
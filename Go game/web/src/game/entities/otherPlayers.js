@@ -111,6 +111,14 @@ export class OtherPlayers {
         console.log(`Updated player ${id} to position:`, position);
     }
 
+    // hides a player while they're invisible
+    setVisible(id, visible) {
+        const playerSprite = this.players.get(id);
+        if (playerSprite) {
+            playerSprite.visible = visible;
+        }
+    }
+
     // removes a player from the game.
     removePlayer(id) {
         const playerSprite = this.players.get(id);
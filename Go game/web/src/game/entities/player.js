@@ -248,7 +248,8 @@ export class Player {
         }
 
         // Movement with boundary checking
-        const speed = 5;
+        // speed boost scales this, the server sends the multiplier with the effect
+        const speed = 5 * (this.speedMultiplier || 1);
         let newX = this.sprite.x;
         let newY = this.sprite.y;
         if (input.keys.w) newY -= speed;
@@ -272,13 +273,27 @@ export class Player {
             rotation: this.sprite.rotation
         });
 
-        this.handleWeaponInput(input.weaponInputState);
+        this.updateTrigger(input.weaponInputState);
         this.healthBar.position.set(this.sprite.x, this.sprite.y);
         this.redrawShield();
+    }
 
-        // Weapon updates when we're alive and kicking
-        if (!this.isDead && !this.disableInput) {
-            this.weapon.update(input);
+    // the server does the firing now (fire rate, spin up, heat), we just tell it when the
+    // trigger goes down and up and keep it posted on where we're aiming while it's down
+    updateTrigger(weaponInput) {
+        const held = weaponInput.isHolding && !this.isDead && !this.disableInput;
+        const now = Date.now();
+        if (held !== this.triggerHeld) {
+            this.triggerHeld = held;
+            this.lastAimSent = now;
+            this.lastAim = this.sprite.rotation;
+            this.network.sendTrigger(held, this.sprite.rotation);
+            return;
+        }
+        if (held && this.sprite.rotation !== this.lastAim && now - (this.lastAimSent || 0) >= 50) {
+            this.lastAimSent = now;
+            this.lastAim = this.sprite.rotation;
+            this.network.sendTrigger(true, this.sprite.rotation);
         }
     }
 
@@ -27,6 +27,13 @@ export function setupNetwork() {
 	const MAX_DELAY = 30000;
 	let reconnectAttempt = 0;
 	const MAX_RECONNECT_ATTEMPTS = 5;
+	// highest server tick we've seen, sent back on reconnect so the server knows how far behind we are
+	let lastSeq = 0;
+	// ?spectate=1 (optionally &follow=<player id>) watches the room instead of joining it
+	const params = new URLSearchParams(window.location.search);
+	const spectating = params.has("spectate");
+	// ?replay=<id> plays back a recorded match instead of connecting to a live room
+	const replayId = params.get("replay");
   
 	const gameState = {
 	  playerId: null,
@@ -36,6 +43,7 @@ export function setupNetwork() {
 	  position: initialDeathState.position,
 	  deathTime: initialDeathState.deathTime,
 	  matchActive: false,
+	  spectateTarget: params.get("follow") || null,
 	};
 	let isInitialized = false;
 	const callbacks = {
@@ -45,6 +53,8 @@ export function setupNetwork() {
 	  playerRespawn: null,
 	  healthUpdate: null,
 	  weaponPickup: null,
+	  weaponSwitch: null,
+	  weaponHeat: null,
 	  bulletUpdate: null,
 	  bulletHit: null,
 	  weaponSpawn: null,
@@ -54,6 +64,21 @@ export function setupNetwork() {
 	  playerDisconnect: null,
 	  lobbyUpdate: null,
 	  matchStarted: null,
+	  matchEnd: null,
+	  spectateTarget: null,
+	  replayState: null,
+	  damage: null,
+	  kill: null,
+	  scoreboard: null,
+	  killstreak: null,
+	  multiKill: null,
+	  effectStart: null,
+	  effectEnd: null,
+	  profileUpdate: null,
+	  profileError: null,
+	  chat: null,
+	  chatError: null,
+	  muted: null,
 	};
   
 	// async function detectIncognito() {
@@ -64,20 +89,107 @@ export function setupNetwork() {
 	// 	return false;
 	//   }
 	// }
+	// full authoritative state from the server (on join and on every reconnect).
+	// replays it through the same callbacks the individual messages use.
+	function applyStateSync(selfId, state) {
+	  if (!state) return;
+	  gameState.playerId = selfId;
+	  for (const p of state.players || []) {
+		if (callbacks.effectStart) {
+		  for (const e of p.effects || []) {
+			callbacks.effectStart({
+			  player_id: p.player_id,
+			  effect: e.name,
+			  stacks: e.stacks,
+			  duration: e.remaining,
+			  magnitude: e.magnitude,
+			});
+		  }
+		}
+		if (callbacks.profileUpdate) {
+		  callbacks.profileUpdate(p);
+		}
+		if (p.player_id === selfId) {
+		  gameState.health = p.health;
+		  gameState.weapon = p.weapon;
+		  gameState.isDead = p.is_dead;
+		  gameState.position = p.position;
+		  gameState.deathTime = p.is_dead ? p.death_time : null;
+		  isInitialized = true;
+		  if (callbacks.playerInit) {
+			callbacks.playerInit(
+			  p.player_id,
+			  p.color,
+			  p.position || { x: 0, y: 0 },
+			  p.health,
+			  p.weapon,
+			  p.is_dead,
+			  p.teleportAvailable,
+			  p.forceFieldActive,
+			  p.healthRegenActive
+			);
+		  }
+		  if (p.is_dead) {
+			localStorage.setItem(
+			  "playerDeathState",
+			  JSON.stringify({ isDead: true, deathTime: p.death_time, position: p.position })
+			);
+		  } else {
+			localStorage.removeItem("playerDeathState");
+		  }
+		  continue;
+		}
+		if (callbacks.playerPosition) {
+		  callbacks.playerPosition(p.player_id, p.position, p.color);
+		}
+		if (callbacks.healthUpdate) {
+		  callbacks.healthUpdate(p.player_id, p.health);
+		}
+		if (p.is_dead && callbacks.playerDeath) {
+		  callbacks.playerDeath(p.player_id, p.position);
+		}
+	  }
+	  for (const w of state.weapons || []) {
+		if (callbacks.weaponSpawn) {
+		  callbacks.weaponSpawn(w.id, w.position, w.type);
+		}
+	  }
+	  for (const pu of state.powerups || []) {
+		if (callbacks.powerupSpawn) {
+		  callbacks.powerupSpawn(pu.id, pu.position, pu.type);
+		}
+	  }
+	  // the recent chat, a reconnect sends what we may already have shown so sent_at +
+	  // player_id is there to skip those
+	  for (const m of state.chat || []) {
+		if (callbacks.chat) {
+		  callbacks.chat(m);
+		}
+	  }
+	}
+
 	// main network 
 	function connect() {
 	  if (ws?.readyState === WebSocket.OPEN) return;
 	  const delay = Math.min(baseReconnectDelay * 2 ** reconnectAttempt, MAX_DELAY);
 	  baseReconnectDelay *= 1.3;
 	  setTimeout(() => {
-		ws = new WebSocket("ws://localhost:8080/ws");
+		ws = new WebSocket(
+		  replayId
+			? `ws://localhost:8080/replays/${encodeURIComponent(replayId)}`
+			: "ws://localhost:8080/ws"
+		);
 		ws.onopen = () => {
 		  console.log("Connected to server with session:", sessionId);
 		  reconnectAttempt = 0;
+		  if (replayId) return; // the replay starts streaming on its own
 		  ws.send(
 			JSON.stringify({
 			  type: "session_init",
 			  session_id: sessionId,
+			  last_seq: lastSeq,
+			  spectate: spectating,
+			  target: gameState.spectateTarget || undefined,
 			})
 		  );
 		};
@@ -98,7 +210,34 @@ export function setupNetwork() {
 			  console.warn("Invalid message format:", message);
 			  return;
 			}
+			if (message.seq && message.seq > lastSeq) {
+			  lastSeq = message.seq;
+			}
 			switch (message.type) {
+			  case "state_sync":
+				applyStateSync(message.player_id, message.state);
+				if (message.spectate) {
+				  gameState.spectateTarget = message.target || null;
+				  if (callbacks.spectateTarget) {
+					callbacks.spectateTarget(gameState.spectateTarget);
+				  }
+				}
+				break;
+			  case "replay_info":
+			  case "replay_state":
+			  case "replay_end":
+				// playback position, speed and length for the replay controls
+				if (callbacks.replayState) {
+				  callbacks.replayState(message);
+				}
+				break;
+			  case "spectate_target":
+				// who the camera should follow (killer cam while dead), null means free camera
+				gameState.spectateTarget = message.target || null;
+				if (callbacks.spectateTarget) {
+				  callbacks.spectateTarget(gameState.spectateTarget);
+				}
+				break;
 			  case "player_init":
 				gameState.playerId = message.player_id;
 				gameState.health = message.health;
@@ -201,6 +340,7 @@ export function setupNetwork() {
 			  case "weapon_pickup":
 				if (message.player_id === gameState.playerId) {
 				  gameState.weapon = message.weapon;
+				  gameState.inventory = message.inventory;
 				}
 				if (callbacks.weaponPickup) {
 				  callbacks.weaponPickup(
@@ -210,6 +350,25 @@ export function setupNetwork() {
 				  );
 				}
 				break;
+			  case "weapon_switch":
+				if (message.player_id === gameState.playerId) {
+				  gameState.weapon = message.weapon;
+				}
+				if (callbacks.weaponSwitch) {
+				  callbacks.weaponSwitch(message.player_id, message.weapon, message.slot);
+				}
+				break;
+			  case "weapon_heat":
+				// only ever sent to the shooter
+				if (callbacks.weaponHeat) {
+				  callbacks.weaponHeat(message.heat || 0, !!message.overheated, message.spin || 0);
+				}
+				break;
+			  case "weapon_drop":
+				if (message.player_id === gameState.playerId) {
+				  gameState.inventory = message.inventory;
+				}
+				break;
 			  case "player_death":
 				if (message.player_id === gameState.playerId) {
 				  gameState.isDead = true;
@@ -261,6 +420,90 @@ export function setupNetwork() {
 				  callbacks.lobbyUpdate(message.players);
 				}
 				break;
+			  case "server_shutdown":
+				// the server is restarting, keep retrying until the new one is up
+				console.log("Server shutting down:", message.reason);
+				reconnectAttempt = 0;
+				baseReconnectDelay = 1000;
+				break;
+			  case "kicked":
+			  case "banned":
+				// an admin removed us, reconnecting would just get us turned away again
+				console.warn(`You were ${message.type}:`, message.reason);
+				reconnectAttempt = MAX_RECONNECT_ATTEMPTS;
+				break;
+			  case "match_end":
+				gameState.matchActive = false;
+				if (callbacks.matchEnd) {
+				  callbacks.matchEnd(message.scoreboard, message.stats || []);
+				}
+				break;
+			  case "damage":
+				if (callbacks.damage) {
+				  callbacks.damage(message);
+				}
+				break;
+			  case "kill":
+				if (callbacks.kill) {
+				  callbacks.kill(message);
+				}
+				break;
+			  case "killstreak":
+				if (callbacks.killstreak) {
+				  callbacks.killstreak(message);
+				}
+				break;
+			  case "multikill":
+				if (callbacks.multiKill) {
+				  callbacks.multiKill(message.player_id, message.multi_kill);
+				}
+				break;
+			  case "effect_start":
+				if (callbacks.effectStart) {
+				  callbacks.effectStart(message);
+				}
+				break;
+			  case "effect_end":
+				if (callbacks.effectEnd) {
+				  callbacks.effectEnd(message);
+				}
+				break;
+			  case "profile_update":
+				// someone (maybe us) joined or changed their name, color or sprite
+				if (callbacks.profileUpdate) {
+				  callbacks.profileUpdate(message);
+				}
+				break;
+			  case "profile_error":
+				if (callbacks.profileError) {
+				  callbacks.profileError(message.reason);
+				}
+				break;
+			  case "chat":
+				if (callbacks.chat) {
+				  callbacks.chat(message);
+				}
+				break;
+			  case "chat_error":
+				if (callbacks.chatError) {
+				  callbacks.chatError(message.reason);
+				}
+				break;
+			  case "muted":
+				if (callbacks.muted) {
+				  callbacks.muted({ reason: message.reason, duration: message.duration || 0 });
+				}
+				break;
+			  case "unmuted":
+				if (callbacks.muted) {
+				  callbacks.muted(null);
+				}
+				break;
+			  case "scoreboard":
+				if (callbacks.scoreboard) {
+				  callbacks.scoreboard(message.scoreboard, message.stats || []);
+				}
+				break;
 			  case "match_started":
 				gameState.matchActive = true;
 				if (callbacks.matchStarted) {
@@ -311,6 +554,11 @@ export function setupNetwork() {
 		  );
 		}
 	  },
+	  sendTrigger: (held, rotation) => {
+		if (ws?.readyState === WebSocket.OPEN && isInitialized) {
+		  ws.send(JSON.stringify({ type: "trigger", held, rotation }));
+		}
+	  },
 	  forceReconnect: () => {
 		if (ws) {
 		  ws.close();
@@ -328,6 +576,22 @@ export function setupNetwork() {
 		  );
 		}
 	  },
+	  // inventory: pick up the closest weapon, switch to slot 1 or 2, drop the one in hand
+	  sendPickupWeapon: () => {
+		if (ws?.readyState === WebSocket.OPEN && !gameState.isDead && isInitialized) {
+		  ws.send(JSON.stringify({ type: "pickup_weapon" }));
+		}
+	  },
+	  sendSwitchWeapon: (slot) => {
+		if (ws?.readyState === WebSocket.OPEN && !gameState.isDead && isInitialized) {
+		  ws.send(JSON.stringify({ type: "switch_weapon", slot }));
+		}
+	  },
+	  sendDropWeapon: () => {
+		if (ws?.readyState === WebSocket.OPEN && !gameState.isDead && isInitialized) {
+		  ws.send(JSON.stringify({ type: "drop_weapon" }));
+		}
+	  },
 	  sendJoinMatch: () => {
 		if (ws?.readyState === WebSocket.OPEN && !gameState.matchActive && isInitialized) {
 		  ws.send(JSON.stringify({ type: "join_match" }));
@@ -386,6 +650,104 @@ export function setupNetwork() {
 	  onMatchStarted: (cb) => {
 		callbacks.matchStarted = cb;
 	  },
+	  onMatchEnd: (cb) => {
+		callbacks.matchEnd = cb;
+	  },
+	  // (heat 0-1, overheated, spin up 0-1) for the weapon in hand
+	  onWeaponHeat: (cb) => {
+		callbacks.weaponHeat = cb;
+	  },
+	  onWeaponSwitch: (cb) => {
+		callbacks.weaponSwitch = cb;
+	  },
+	  onSpectateTarget: (cb) => {
+		callbacks.spectateTarget = cb;
+	  },
+	  // {player_id, attacker_id, weapon, damage, absorbed, health}
+	  onDamage: (cb) => {
+		callbacks.damage = cb;
+	  },
+	  // kill feed entry: {killer_id, player_id, weapon, assists, streak}
+	  onKill: (cb) => {
+		callbacks.kill = cb;
+	  },
+	  // {player_id, streak, reward, duration} where reward is force_field, teleport or radar
+	  onKillstreak: (cb) => {
+		callbacks.killstreak = cb;
+	  },
+	  onMultiKill: (cb) => {
+		callbacks.multiKill = cb;
+	  },
+	  // {player_id, effect, stacks, duration, magnitude} when an effect starts or restacks
+	  onEffectStart: (cb) => {
+		callbacks.effectStart = cb;
+	  },
+	  // {player_id, effect, reason, cooldown}
+	  onEffectEnd: (cb) => {
+		callbacks.effectEnd = cb;
+	  },
+	  onScoreboard: (cb) => {
+		callbacks.scoreboard = cb;
+	  },
+	  // {player_id, username, color, sprite} for every player on join and whenever one changes
+	  onProfileUpdate: (cb) => {
+		callbacks.profileUpdate = cb;
+	  },
+	  // why our last profile change was turned down (name taken, not allowed...)
+	  onProfileError: (cb) => {
+		callbacks.profileError = cb;
+	  },
+	  // any of {name, color, sprite}, sprite is soldier, mech, ship or creature
+	  sendProfile: (profile) => {
+		if (ws?.readyState === WebSocket.OPEN && isInitialized) {
+		  ws.send(JSON.stringify({ type: "profile_update", profile }));
+		}
+	  },
+	  // {player_id, username, text, scope, target, quick_chat, sent_at}, scope is room, team or whisper
+	  onChat: (cb) => {
+		callbacks.chat = cb;
+	  },
+	  // why our last chat message wasn't sent (muted, too fast, too long...)
+	  onChatError: (cb) => {
+		callbacks.chatError = cb;
+	  },
+	  // {reason, duration} when an admin mutes us (duration 0 is for good), null when it's lifted
+	  onMuted: (cb) => {
+		callbacks.muted = cb;
+	  },
+	  // scope is room (the default), team or whisper with target the player id
+	  sendChat: (text, scope = "room", target = "") => {
+		if (ws?.readyState === WebSocket.OPEN && isInitialized) {
+		  ws.send(JSON.stringify({ type: "chat", text, scope, target }));
+		}
+	  },
+	  // one of gg, hello, thanks, sorry, nice_shot, help, follow_me, on_my_way, retreat
+	  sendQuickChat: (id, scope = "room") => {
+		if (ws?.readyState === WebSocket.OPEN && isInitialized) {
+		  ws.send(JSON.stringify({ type: "chat", quick_chat: id, scope }));
+		}
+	  },
+	  requestScoreboard: () => {
+		if (ws?.readyState === WebSocket.OPEN) {
+		  ws.send(JSON.stringify({ type: "scoreboard_request" }));
+		}
+	  },
+	  // follow a player (spectators, or anyone while dead), no id for the free camera
+	  spectate: (playerId) => {
+		if (ws?.readyState === WebSocket.OPEN) {
+		  ws.send(JSON.stringify({ type: "spectate", target: playerId || "" }));
+		}
+	  },
+	  isSpectating: () => spectating || !!replayId,
+	  onReplayState: (cb) => {
+		callbacks.replayState = cb;
+	  },
+	  // {type: "speed", speed: 2}, {type: "seek", at: ms}, {type: "pause"} or {type: "play"}
+	  replayControl: (control) => {
+		if (replayId && ws?.readyState === WebSocket.OPEN) {
+		  ws.send(JSON.stringify(control));
+		}
+	  },
 	  isConnected: () => ws?.readyState === WebSocket.OPEN,
 	  getState: () => ({ ...gameState }),
 	};